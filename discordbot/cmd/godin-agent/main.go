@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"godin/pkg/agent"
+	"godin/pkg/azqclient"
+	"godin/pkg/events"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	account := flag.String("account", "", "storage account of the events queue")
+	queueName := flag.String("queue", "events", "name of the events queue")
+	container := flag.String("container", "valheim-server", "container running the game server")
+	patternsPath := flag.String("patterns", "", "json array of {regexp, type} patterns, defaults to the ones the bot reacts to")
+	statePath := flag.String("state", "/var/lib/godin-agent/state.json", "where the lines already sent are remembered")
+	imdsUrl := flag.String("imds", "http://169.254.169.254", "instance metadata service")
+	heartbeatInterval := flag.Duration("heartbeat", 30*time.Second, "how often to tell the bot the game server is running")
+	watchEvictions := flag.Bool("watch-evictions", true, "stop the game server and tell the bot when azure preempts the spot vm")
+	flag.Parse()
+
+	patterns := agent.DefaultPatterns()
+	if *patternsPath != "" {
+		var err error
+		patterns, err = agent.LoadPatterns(*patternsPath)
+		if err != nil {
+			log.Fatalf("error loading patterns: %v", err)
+		}
+	}
+	eventsQueue, err := azqclient.NewQueueClientWithManagedIdentity(*account, *queueName)
+	if err != nil {
+		log.Fatalf("error creating events queue client: %v", err)
+	}
+	dedupe, err := agent.LoadDedupe(*statePath)
+	if err != nil {
+		log.Fatalf("error loading dedupe state: %v", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a := agent.NewAgent(agent.DockerLogs{Container: *container}, patterns, eventsQueue, dedupe)
+	gameServer := agent.DockerContainer{Name: *container}
+	ip, err := agent.PublicIp(ctx, *imdsUrl)
+	if err != nil {
+		log.Fatalf("error getting public ip: %v", err)
+	}
+	if err := a.Emit(events.PublicIp, events.PublicIpPayload{Ip: ip}); err != nil {
+		log.Fatalf("error sending public ip: %v", err)
+	}
+	if *watchEvictions {
+		vmName, err := agent.VmName(ctx, *imdsUrl)
+		if err != nil {
+			log.Fatalf("error getting vm name: %v", err)
+		}
+		watcher := agent.NewEvictionWatcher(*imdsUrl, vmName, gameServer, a)
+		go watcher.Run(ctx, time.Second)
+	}
+	go a.Heartbeat(ctx, gameServer, *heartbeatInterval)
+	log.Printf("Following logs of %s", *container)
+	a.Run(ctx)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"godin/pkg/cmdclient"
+	"godin/pkg/commands"
+	"log"
+	"os"
+)
+
+func main() {
+	guildId := flag.String("guild", "", "register the commands in this guild only, they show up instantly unlike global ones")
+	dryRun := flag.Bool("dry-run", false, "print the changes without applying them")
+	prune := flag.Bool("prune", true, "delete registered commands that have no definition")
+	baseUrl := flag.String("base-url", cmdclient.DiscordApiUrl, "discord API base url")
+	flag.Parse()
+
+	botToken := os.Getenv("DISCORD_BOT_TOKEN")
+	applicationId := os.Getenv("DISCORD_APPLICATION_ID")
+	if botToken == "" || applicationId == "" {
+		log.Fatal("DISCORD_BOT_TOKEN and DISCORD_APPLICATION_ID environment variables must be set")
+	}
+
+	registry := cmdclient.NewClient(*baseUrl, botToken, applicationId, *guildId)
+	registered, err := registry.List()
+	if err != nil {
+		log.Fatalf("error listing registered commands: %v", err)
+	}
+	changes := commands.Plan(commands.Definitions, registered, *prune)
+	if len(changes) == 0 {
+		log.Println("Commands are up to date")
+		return
+	}
+	for _, change := range changes {
+		log.Println(change)
+	}
+	if *dryRun {
+		log.Printf("Dry run, %d changes not applied", len(changes))
+		return
+	}
+	if err := commands.Apply(registry, changes); err != nil {
+		log.Fatalf("error syncing commands: %v", err)
+	}
+	log.Printf("Applied %d changes", len(changes))
+}
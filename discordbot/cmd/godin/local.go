@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"godin/pkg/discinteraction"
+	"godin/pkg/disclient"
+	"godin/pkg/handlers"
+	"godin/pkg/history"
+	"godin/pkg/local"
+	"godin/pkg/permissions"
+	"godin/pkg/queue"
+	"godin/pkg/signature"
+	"godin/pkg/steamapi"
+	"godin/pkg/vmssclient"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	localWorldName    = "local"
+	localPollInterval = 500 * time.Millisecond
+	localBootDelay    = 3 * time.Second
+	localHeartbeat    = 30 * time.Second
+	// localRole is the role of the local users, the default policy lets it run every command
+	localRole = "local"
+)
+
+// runLocal serves the interactions API and runs the reaction and poison functions in-process, with queues
+// and state in dataDir and fake discord, vmss and steam backends
+func runLocal(listenAddr, dataDir, steamNames string) {
+	eventsQueue, err := queue.NewFileQueue(filepath.Join(dataDir, "events.json"))
+	if err != nil {
+		log.Fatalf("error creating events queue: %v", err)
+	}
+	poisonQueue, err := queue.NewFileQueue(filepath.Join(dataDir, "events-poison.json"))
+	if err != nil {
+		log.Fatalf("error creating poison queue: %v", err)
+	}
+	steamclient, err := local.NewSteamClient(steamNames)
+	if err != nil {
+		log.Fatalf("error creating steam client: %v", err)
+	}
+	heartbeatMaxGap, err := heartbeatMaxGapFromEnv()
+	if err != nil {
+		log.Fatalf("error reading HEARTBEAT_MAX_GAP: %v", err)
+	}
+	idleConfig, err := handlers.IdleConfigFromEnv()
+	if err != nil {
+		log.Fatalf("error reading idle config: %v", err)
+	}
+	// a single fake vm, so it keeps sending heartbeats from one event to the next
+	vm := local.NewVmssClient(eventsQueue, localBootDelay, localHeartbeat)
+	// state goes to a json file in the data dir, unless STATE_STORAGE picks another backend
+	storage := handlers.StorageConfigFromEnv()
+	if storage.Backend == "" {
+		storage.Backend = handlers.StorageFile
+		storage.Path = filepath.Join(dataDir, "state.json")
+	}
+	storage.RowKey = localWorldName
+	storageClients, err := storage.Open()
+	if err != nil {
+		log.Fatalf("error opening state storage: %v", err)
+	}
+	historyRetention, err := history.RetentionFromEnv()
+	if err != nil {
+		log.Fatalf("error reading history retention: %v", err)
+	}
+	backends := handlers.Backends{
+		TableClient:      storageClients.TableClient,
+		Partition:        storageClients.Partition,
+		HistoryRetention: historyRetention,
+		DefaultPolicy: permissions.Policy{
+			Commands: map[string]permissions.Rule{"replay": {Roles: []string{localRole}}},
+			Default:  &permissions.Rule{Roles: []string{localRole}},
+		},
+		Discord: func() (disclient.DiscordClientInterface, error) {
+			return local.NewDiscordClient(), nil
+		},
+		Vmss: func(ip string) (vmssclient.VmssClientInterface, error) {
+			return vm, nil
+		},
+		Steam: func() steamapi.ClientInterface {
+			return steamclient
+		},
+	}
+	// requests are signed by a key generated on every run, /local/command signs them for you
+	signer, err := signature.NewSigner()
+	if err != nil {
+		log.Fatalf("error creating signer: %v", err)
+	}
+	interactions := handlers.NewInteractionHandler(signer.Verifier(signature.DefaultMaxAge), eventsQueue, backends)
+
+	ctx := context.Background()
+	go handlers.NewReactionHandler(os.Getenv("UNKNOWN_EVENT_FALLBACK"), backends).Consume(ctx, eventsQueue, poisonQueue, localPollInterval)
+	go handlers.NewPoisonHandler(backends).Consume(ctx, poisonQueue, localPollInterval)
+	go handlers.NewWatchdogHandler(backends, heartbeatMaxGap).Watch(ctx, time.Minute)
+	go handlers.NewIdleHandler(backends, eventsQueue, idleConfig).Watch(ctx, time.Minute)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/interactions", interactions)
+	mux.HandleFunc("/local/command", func(w http.ResponseWriter, r *http.Request) {
+		interaction := localInteraction(r, discinteraction.InteractionCommand)
+		interaction["data"] = map[string]interface{}{"name": r.URL.Query().Get("name")}
+		serveSigned(w, interactions, signer, interaction)
+	})
+	mux.HandleFunc("/local/click", func(w http.ResponseWriter, r *http.Request) {
+		interaction := localInteraction(r, discinteraction.InteractionMessageComponent)
+		interaction["data"] = map[string]interface{}{
+			"custom_id":      r.URL.Query().Get("custom_id"),
+			"component_type": discinteraction.ComponentButton,
+		}
+		serveSigned(w, interactions, signer, interaction)
+	})
+	mux.HandleFunc("/local/vm", func(w http.ResponseWriter, r *http.Request) {
+		line, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := local.EnqueueLogLine(eventsQueue, string(bytes.TrimSpace(line))); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/local/crash", func(w http.ResponseWriter, r *http.Request) {
+		vm.Crash()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/local/evict", func(w http.ResponseWriter, r *http.Request) {
+		vm.Crash()
+		if err := local.EnqueueEvicted(eventsQueue); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	log.Printf("Running locally with data in %s, interactions public key %s", dataDir, signer.PublicKey())
+	log.Printf("Listening on %s...", listenAddr)
+	log.Fatal(http.ListenAndServe(listenAddr, mux))
+}
+
+// localInteraction builds an interaction of the user in the user query parameter, 100 by default
+func localInteraction(r *http.Request, interactionType int) map[string]interface{} {
+	user := r.URL.Query().Get("user")
+	if user == "" {
+		user = "100"
+	}
+	return map[string]interface{}{
+		"id":     uuid.NewString(),
+		"type":   interactionType,
+		"token":  "local-" + uuid.NewString(),
+		"member": map[string]interface{}{"user": map[string]string{"id": user, "username": "user" + user}, "roles": []string{localRole}},
+	}
+}
+
+// serveSigned signs the interaction and hands it to the interactions API
+func serveSigned(w http.ResponseWriter, interactions http.Handler, signer *signature.Signer, interaction map[string]interface{}) {
+	body, err := json.Marshal(interaction)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/interactions", bytes.NewReader(body))
+	signer.Sign(req, body)
+	rec := httptest.NewRecorder()
+	interactions.ServeHTTP(rec, req)
+	log.Printf("Interaction %s answered with %d: %s", interaction["token"], rec.Code, bytes.TrimSpace(rec.Body.Bytes()))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+}
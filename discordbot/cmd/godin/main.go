@@ -1,20 +1,66 @@
 package main
 
 import (
+	"flag"
+	"godin/pkg/azqclient"
 	"godin/pkg/handlers"
+	"godin/pkg/signature"
 	"log"
 	"net/http"
 	"os"
+	"time"
 )
 
 func main() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/interactions", handlers.InteractionHandler)
-	mux.HandleFunc("/reactions", handlers.ReactionHandler)
+	localMode := flag.Bool("local", false, "run without the functions host, azure and discord, see README")
+	dataDir := flag.String("data-dir", ".godin-local", "where --local keeps its queues and state")
+	steamNames := flag.String("steam-names", "", "json object of steam id to player name --local answers steam lookups with")
+	flag.Parse()
+
 	listenAddr := ":8080"
 	if val, ok := os.LookupEnv("FUNCTIONS_CUSTOMHANDLER_PORT"); ok {
 		listenAddr = ":" + val
 	}
+	if *localMode {
+		runLocal(listenAddr, *dataDir, *steamNames)
+		return
+	}
+
+	verifier, err := signature.NewEd25519Verifier(os.Getenv("DISCORD_PUBLIC_KEY"), signature.DefaultMaxAge)
+	if err != nil {
+		log.Fatalf("error creating request verifier from DISCORD_PUBLIC_KEY: %v", err)
+	}
+	eventsQueue, err := azqclient.NewQueueClient("events")
+	if err != nil {
+		log.Fatalf("error creating events queue client: %v", err)
+	}
+	heartbeatMaxGap, err := heartbeatMaxGapFromEnv()
+	if err != nil {
+		log.Fatalf("error reading HEARTBEAT_MAX_GAP: %v", err)
+	}
+	idleConfig, err := handlers.IdleConfigFromEnv()
+	if err != nil {
+		log.Fatalf("error reading idle config: %v", err)
+	}
+	backends, err := handlers.AzureBackends()
+	if err != nil {
+		log.Fatalf("error creating backends: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/interactions", handlers.NewInteractionHandler(verifier, eventsQueue, backends))
+	mux.Handle("/reactions", handlers.NewReactionHandler(os.Getenv("UNKNOWN_EVENT_FALLBACK"), backends))
+	mux.Handle("/poison", handlers.NewPoisonHandler(backends))
+	mux.Handle("/watchdog", handlers.NewWatchdogHandler(backends, heartbeatMaxGap))
+	mux.Handle("/idle", handlers.NewIdleHandler(backends, eventsQueue, idleConfig))
 	log.Printf("Listening on %s...", listenAddr)
 	log.Fatal(http.ListenAndServe(listenAddr, mux))
 }
+
+// heartbeatMaxGapFromEnv reads the heartbeat gap the server is flagged unhealthy after, as a go duration
+func heartbeatMaxGapFromEnv() (time.Duration, error) {
+	value := os.Getenv("HEARTBEAT_MAX_GAP")
+	if value == "" {
+		return handlers.DefaultHeartbeatMaxGap, nil
+	}
+	return time.ParseDuration(value)
+}
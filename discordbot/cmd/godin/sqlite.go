@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// registers the sqlite driver the sql state storage defaults to, behind the sqlite tag so the functions binary
+// doesn't link it, other drivers have to be imported here too
+import _ "modernc.org/sqlite"
@@ -0,0 +1,294 @@
+// Package agent follows the game server logs on the vm and sends the events they stand for to the events queue
+package agent
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"godin/pkg/events"
+	"godin/pkg/queue"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+	enqueueAttempts   = 3
+)
+
+// Pattern maps the log lines matching Regexp to an event of Type. When Regexp has a capture group
+// its first one is the steam id of the player the line is about, or the character name of Character events
+type Pattern struct {
+	Regexp string      `json:"regexp"`
+	Type   events.Type `json:"type"`
+	re     *regexp.Regexp
+}
+
+// DefaultPatterns are the game server log lines the reaction handler reacts to
+func DefaultPatterns() []Pattern {
+	patterns, _ := compile([]Pattern{
+		{Regexp: `Server is now listening`, Type: events.Listening},
+		{Regexp: `Got connection SteamID (\d{17})`, Type: events.PlayerJoined},
+		{Regexp: `Closing socket (\d{17})`, Type: events.PlayerLeft},
+		{Regexp: `Got character ZDOID from (.+) : -?[1-9]\d*:\d+`, Type: events.Character},
+	})
+	return patterns
+}
+
+// LoadPatterns reads a json array of patterns
+func LoadPatterns(path string) ([]Pattern, error) {
+	contentBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading patterns: %v", err)
+	}
+	var patterns []Pattern
+	if err := json.Unmarshal(contentBytes, &patterns); err != nil {
+		return nil, fmt.Errorf("error unmarshalling patterns: %v", err)
+	}
+	return compile(patterns)
+}
+
+func compile(patterns []Pattern) ([]Pattern, error) {
+	for i := range patterns {
+		re, err := regexp.Compile(patterns[i].Regexp)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling pattern %q: %v", patterns[i].Regexp, err)
+		}
+		patterns[i].re = re
+	}
+	return patterns, nil
+}
+
+// LogSource streams the game server log lines, prefixed with their RFC3339 timestamp,
+// starting at since when it isn't zero. The stream ends when the server stops
+type LogSource interface {
+	Follow(ctx context.Context, since time.Time) (io.ReadCloser, error)
+}
+
+// DockerLogs follows the logs of a container, stdout and stderr merged
+type DockerLogs struct {
+	Container string
+}
+
+func (dl DockerLogs) Follow(ctx context.Context, since time.Time) (io.ReadCloser, error) {
+	args := []string{"logs", "--follow", "--timestamps"}
+	if !since.IsZero() {
+		args = append(args, "--since", since.Format(time.RFC3339Nano))
+	}
+	cmd := exec.CommandContext(ctx, "docker", append(args, dl.Container)...)
+	reader, writer := io.Pipe()
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error running docker logs: %v", err)
+	}
+	go func() {
+		writer.CloseWithError(cmd.Wait())
+	}()
+	return reader, nil
+}
+
+type Agent struct {
+	source         LogSource
+	patterns       []Pattern
+	events         queue.Queue
+	dedupe         *Dedupe
+	reconnectDelay time.Duration
+	retryDelay     time.Duration
+}
+
+func NewAgent(source LogSource, patterns []Pattern, events queue.Queue, dedupe *Dedupe) *Agent {
+	return &Agent{
+		source:         source,
+		patterns:       patterns,
+		events:         events,
+		dedupe:         dedupe,
+		reconnectDelay: minReconnectDelay,
+		retryDelay:     time.Second,
+	}
+}
+
+// Run follows the log until ctx is done, following it again whenever the stream ends,
+// like when the container restarts or an event can't be sent, with a delay that grows while it keeps failing
+func (a *Agent) Run(ctx context.Context) {
+	delay := a.reconnectDelay
+	for {
+		lines, err := a.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if lines != 0 && err == nil {
+			delay = a.reconnectDelay
+		}
+		log.Printf("Log stream ended after %d lines (%v), following again in %s", lines, err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// follow handles the lines of one log stream, from the last one already handled. A line that can't be sent
+// ends the stream, the lines after it would move the last one handled past it
+func (a *Agent) follow(ctx context.Context) (int, error) {
+	stream, err := a.source.Follow(ctx, a.dedupe.Since())
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+	lines := 0
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		lines++
+		if err := a.handleLine(scanner.Text()); err != nil {
+			return lines, fmt.Errorf("error handling log line: %v", err)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// handleLine sends the event of a line matching a pattern, once. Only matching lines are remembered,
+// and not when they fail to be sent, so following the log again from the last one sent sends them
+func (a *Agent) handleLine(rawLine string) error {
+	at, line := splitTimestamp(rawLine)
+	id := lineId(rawLine)
+	if a.dedupe.Seen(id) {
+		return nil
+	}
+	for _, pattern := range a.patterns {
+		match := pattern.re.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		var payload interface{} = events.LogPayload{Line: line}
+		if pattern.Type == events.Character && len(match) > 1 {
+			payload = events.CharacterPayload{Name: match[1], Line: line}
+		} else if len(match) > 1 {
+			payload = events.PlayerPayload{SteamId: match[1], Line: line}
+		}
+		log.Printf("Event: %s", line)
+		if err := a.emit(pattern.Type, at, payload); err != nil {
+			return err
+		}
+		return a.dedupe.Add(id, at)
+	}
+	return nil
+}
+
+// Emit enqueues an event that happens now, retrying a few times before giving up
+func (a *Agent) Emit(eventType events.Type, payload interface{}) error {
+	return a.emit(eventType, time.Time{}, payload)
+}
+
+// emit enqueues an event that happened at, the time of the log line it stands for, the zero time is now
+func (a *Agent) emit(eventType events.Type, at time.Time, payload interface{}) error {
+	event, err := events.New(eventType, events.SourceVm, nil, payload)
+	if err != nil {
+		return err
+	}
+	if !at.IsZero() {
+		event.Timestamp = at.UTC()
+	}
+	message, err := events.Encode(event)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = a.events.Enqueue(message)
+		if err == nil {
+			log.Printf("Sent %s event %s", event.Type, event.CorrelationId)
+			return nil
+		}
+		if attempt == enqueueAttempts {
+			return fmt.Errorf("error sending %s event after %d attempts: %v", event.Type, attempt, err)
+		}
+		time.Sleep(time.Duration(attempt) * a.retryDelay)
+	}
+}
+
+// Heartbeat sends a heartbeat every interval while the container is running, until ctx is done.
+// The watchdog of the bot flags the server unhealthy when they stop
+func (a *Agent) Heartbeat(ctx context.Context, container Container, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		running, err := container.Running(ctx)
+		if err != nil {
+			log.Printf("Error checking the game server container: %v", err)
+			continue
+		}
+		if !running {
+			log.Printf("Game server container isn't running, skipping heartbeat")
+			continue
+		}
+		if err := a.Emit(events.Heartbeat, nil); err != nil {
+			log.Printf("Error sending heartbeat: %v", err)
+		}
+	}
+}
+
+// splitTimestamp splits the timestamp docker prefixes lines with from the line itself
+func splitTimestamp(rawLine string) (time.Time, string) {
+	prefix, line, found := strings.Cut(rawLine, " ")
+	if !found {
+		return time.Time{}, rawLine
+	}
+	at, err := time.Parse(time.RFC3339Nano, prefix)
+	if err != nil {
+		return time.Time{}, rawLine
+	}
+	return at, line
+}
+
+// lineId identifies a line with its timestamp, so a player joining twice isn't taken for a duplicate
+func lineId(rawLine string) string {
+	sum := sha256.Sum256([]byte(rawLine))
+	return hex.EncodeToString(sum[:])
+}
+
+// PublicIp asks the instance metadata service for the public ip of the vm
+func PublicIp(ctx context.Context, imdsUrl string) (string, error) {
+	return metadataText(ctx, imdsUrl, "/metadata/instance/network/interface/0/ipv4/ipAddress/0/publicIpAddress?api-version=2021-05-01&format=text")
+}
+
+// VmName asks the instance metadata service for the name of the vm, the one scheduled events list it as
+func VmName(ctx context.Context, imdsUrl string) (string, error) {
+	return metadataText(ctx, imdsUrl, "/metadata/instance/compute/name?api-version=2021-05-01&format=text")
+}
+
+func metadataText(ctx context.Context, imdsUrl, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsUrl+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error getting instance metadata: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading instance metadata: %v", err)
+	}
+	value := strings.TrimSpace(string(body))
+	if resp.StatusCode != http.StatusOK || value == "" {
+		return "", fmt.Errorf("instance metadata %s not found: %d %s", path, resp.StatusCode, value)
+	}
+	return value, nil
+}
@@ -0,0 +1,218 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"godin/pkg/events"
+	"godin/pkg/queue"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLogSource streams one log per Follow call, like a container restarting between them
+type TestLogSource struct {
+	logs   []string
+	since  []time.Time
+	cancel context.CancelFunc
+}
+
+func (tls *TestLogSource) Follow(ctx context.Context, since time.Time) (io.ReadCloser, error) {
+	tls.since = append(tls.since, since)
+	if len(tls.logs) == 0 {
+		tls.cancel()
+		return nil, fmt.Errorf("container not found")
+	}
+	// like docker logs --since, lines older than since are left out
+	lines := []string{}
+	for _, line := range strings.Split(tls.logs[0], "\n") {
+		if at, _ := splitTimestamp(line); !at.Before(since) {
+			lines = append(lines, line)
+		}
+	}
+	tls.logs = tls.logs[1:]
+	return io.NopCloser(strings.NewReader(strings.Join(lines, "\n"))), nil
+}
+
+func TestRun(t *testing.T) {
+	firstRun := strings.Join([]string{
+		"2024-10-05T22:00:00.000000001Z Loading world",
+		"2024-10-05T22:00:01.000000001Z Server is now listening",
+		"2024-10-05T22:01:00.000000001Z Got connection SteamID 76561198073103840",
+	}, "\n")
+	// the restarted container is followed from the last line sent, which comes again
+	secondRun := strings.Join([]string{
+		"2024-10-05T22:01:00.000000001Z Got connection SteamID 76561198073103840",
+		"2024-10-05T22:02:00.000000001Z Closing socket 76561198073103840",
+		"2024-10-05T22:03:00.000000001Z Got connection SteamID 76561198073103840",
+	}, "\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	source := &TestLogSource{logs: []string{firstRun, secondRun}, cancel: cancel}
+	statePath := filepath.Join(t.TempDir(), "agent", "state.json")
+	dedupe, err := LoadDedupe(statePath)
+	if err != nil {
+		t.Fatalf("error loading dedupe state: %v", err)
+	}
+	eventsQueue := queue.NewMemoryQueue()
+	agent := NewAgent(source, DefaultPatterns(), eventsQueue, dedupe)
+	agent.reconnectDelay = time.Millisecond
+	agent.Run(ctx)
+
+	expectedTypes := []events.Type{events.Listening, events.PlayerJoined, events.PlayerLeft, events.PlayerJoined}
+	expectedTimes := []time.Time{
+		time.Date(2024, 10, 5, 22, 0, 1, 1, time.UTC),
+		time.Date(2024, 10, 5, 22, 1, 0, 1, time.UTC),
+		time.Date(2024, 10, 5, 22, 2, 0, 1, time.UTC),
+		time.Date(2024, 10, 5, 22, 3, 0, 1, time.UTC),
+	}
+	for i, expectedType := range expectedTypes {
+		msg, _ := eventsQueue.Dequeue(time.Minute)
+		if msg == nil {
+			t.Fatalf("expected a %s event but the queue was empty", expectedType)
+		}
+		event, err := events.Decode(msg.Text)
+		if err != nil {
+			t.Fatalf("error decoding event: %v", err)
+		}
+		if event.Type != expectedType || event.Source != events.SourceVm {
+			t.Errorf("expected %s event from the vm but was %s from %s", expectedType, event.Type, event.Source)
+		}
+		// events happen when the server logged them, not when they were sent
+		if !event.Timestamp.Equal(expectedTimes[i]) {
+			t.Errorf("expected the %s event to be at %s, the time of its log line, but was %s", expectedType, expectedTimes[i], event.Timestamp)
+		}
+		if expectedType == events.PlayerJoined {
+			var payload events.PlayerPayload
+			event.DecodePayload(&payload)
+			if payload.SteamId != "76561198073103840" {
+				t.Errorf("expected steam id 76561198073103840 but was %s", payload.SteamId)
+			}
+		}
+	}
+	if msg, _ := eventsQueue.Dequeue(time.Minute); msg != nil {
+		t.Errorf("expected no more events but got %s", msg.Text)
+	}
+	expectedSince := time.Date(2024, 10, 5, 22, 1, 0, 1, time.UTC)
+	if len(source.since) < 2 || !source.since[1].Equal(expectedSince) {
+		t.Errorf("expected the log to be followed again from %s but was %v", expectedSince, source.since)
+	}
+
+	reloaded, err := LoadDedupe(statePath)
+	if err != nil {
+		t.Fatalf("error reloading dedupe state: %v", err)
+	}
+	if !reloaded.Seen(lineId("2024-10-05T22:02:00.000000001Z Closing socket 76561198073103840")) {
+		t.Errorf("expected sent lines to be remembered across restarts")
+	}
+}
+
+// FailingQueue fails the enqueues of the events of one type a number of times
+type FailingQueue struct {
+	*queue.MemoryQueue
+	failing  events.Type
+	failures int
+}
+
+func (fq *FailingQueue) Enqueue(text string) error {
+	if event, _ := events.Decode(text); event.Type == fq.failing && fq.failures > 0 {
+		fq.failures--
+		return fmt.Errorf("queue unavailable")
+	}
+	return fq.MemoryQueue.Enqueue(text)
+}
+
+func TestRunResendsFailedLine(t *testing.T) {
+	lines := strings.Join([]string{
+		"2024-10-05T22:00:01.000000001Z Server is now listening",
+		"2024-10-05T22:01:00.000000001Z Got connection SteamID 76561198073103840",
+		"2024-10-05T22:02:00.000000001Z Closing socket 76561198073103840",
+	}, "\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	// the log is followed again from the last line sent, before the one that failed
+	source := &TestLogSource{logs: []string{lines, lines}, cancel: cancel}
+	dedupe, err := LoadDedupe(filepath.Join(t.TempDir(), "agent", "state.json"))
+	if err != nil {
+		t.Fatalf("error loading dedupe state: %v", err)
+	}
+	eventsQueue := &FailingQueue{MemoryQueue: queue.NewMemoryQueue(), failing: events.PlayerJoined, failures: enqueueAttempts}
+	agent := NewAgent(source, DefaultPatterns(), eventsQueue, dedupe)
+	agent.reconnectDelay = time.Millisecond
+	agent.retryDelay = time.Millisecond
+	agent.Run(ctx)
+
+	expectedSince := time.Date(2024, 10, 5, 22, 0, 1, 1, time.UTC)
+	if len(source.since) < 2 || !source.since[1].Equal(expectedSince) {
+		t.Errorf("expected the log to be followed again from %s but was %v", expectedSince, source.since)
+	}
+	for _, expectedType := range []events.Type{events.Listening, events.PlayerJoined, events.PlayerLeft} {
+		msg, _ := eventsQueue.Dequeue(time.Minute)
+		if msg == nil {
+			t.Fatalf("expected a %s event but the queue was empty", expectedType)
+		}
+		if event, _ := events.Decode(msg.Text); event.Type != expectedType {
+			t.Errorf("expected %s event but was %s", expectedType, event.Type)
+		}
+	}
+	if msg, _ := eventsQueue.Dequeue(time.Minute); msg != nil {
+		t.Errorf("expected no more events but got %s", msg.Text)
+	}
+}
+
+func TestLoadPatterns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patterns.json")
+	if err := os.WriteFile(path, []byte(`[{"regexp": "(", "type": "listening"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPatterns(path); err == nil {
+		t.Errorf("expected an invalid regexp to fail")
+	}
+}
+
+func TestPublicIp(t *testing.T) {
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("20.30.40.50"))
+	}))
+	defer imds.Close()
+	ip, err := PublicIp(context.Background(), imds.URL)
+	if err != nil || ip != "20.30.40.50" {
+		t.Errorf("expected public ip 20.30.40.50 but was %s (%v)", ip, err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	type testcase struct {
+		Name               string
+		Running            bool
+		ExpectedHeartbeats bool
+	}
+	testcases := []testcase{
+		{Name: "running", Running: true, ExpectedHeartbeats: true},
+		{Name: "crashed", Running: false, ExpectedHeartbeats: false},
+	}
+	for _, tc := range testcases {
+		eventsQueue := queue.NewMemoryQueue()
+		agent := NewAgent(nil, nil, eventsQueue, nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		agent.Heartbeat(ctx, &TestContainer{running: tc.Running}, 10*time.Millisecond)
+		cancel()
+		msg, _ := eventsQueue.Dequeue(time.Minute)
+		if (msg != nil) != tc.ExpectedHeartbeats {
+			t.Errorf("%s - expected heartbeats to be sent to be %t but got %v", tc.Name, tc.ExpectedHeartbeats, msg)
+			continue
+		}
+		if msg != nil {
+			if event, _ := events.Decode(msg.Text); event.Type != events.Heartbeat {
+				t.Errorf("%s - expected a heartbeat but was %s", tc.Name, event.Type)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxSeen bounds how many line ids are remembered, lines older than that are filtered by Since anyway
+const maxSeen = 1000
+
+// Dedupe remembers the log lines already handled, persisted so a restarted agent doesn't send them again
+type Dedupe struct {
+	path string
+	// LastLine is the timestamp of the last line handled, following the log again starts there
+	LastLine time.Time `json:"last_line"`
+	// Ids are the ids of the last lines handled, oldest first
+	Ids  []string `json:"ids"`
+	seen map[string]bool
+}
+
+func LoadDedupe(path string) (*Dedupe, error) {
+	d := &Dedupe{
+		path: path,
+		seen: make(map[string]bool),
+	}
+	contentBytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading dedupe state: %v", err)
+	}
+	if err := json.Unmarshal(contentBytes, d); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dedupe state %s: %v", path, err)
+	}
+	for _, id := range d.Ids {
+		d.seen[id] = true
+	}
+	return d, nil
+}
+
+func (d *Dedupe) Since() time.Time {
+	return d.LastLine
+}
+
+func (d *Dedupe) Seen(id string) bool {
+	return d.seen[id]
+}
+
+// Add remembers a line and persists the state
+func (d *Dedupe) Add(id string, at time.Time) error {
+	d.seen[id] = true
+	d.Ids = append(d.Ids, id)
+	if len(d.Ids) > maxSeen {
+		delete(d.seen, d.Ids[0])
+		d.Ids = d.Ids[1:]
+	}
+	if at.After(d.LastLine) {
+		d.LastLine = at
+	}
+	contentBytes, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("error marshalling dedupe state: %v", err)
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, contentBytes, 0644); err != nil {
+		return fmt.Errorf("error writing dedupe state: %v", err)
+	}
+	return os.Rename(tmp, d.path)
+}
@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"godin/pkg/events"
+	"log"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	scheduledEventsPath = "/metadata/scheduledevents?api-version=2020-07-01"
+	// preemptions are announced 30 seconds ahead, the container has to be stopped well within that
+	stopTimeout = 20 * time.Second
+)
+
+// ScheduledEvent is an event of the azure scheduled events api
+type ScheduledEvent struct {
+	EventId      string   `json:"EventId"`
+	EventType    string   `json:"EventType"`
+	ResourceType string   `json:"ResourceType"`
+	Resources    []string `json:"Resources"`
+	EventStatus  string   `json:"EventStatus"`
+	NotBefore    string   `json:"NotBefore"`
+}
+
+type scheduledEvents struct {
+	DocumentIncarnation int              `json:"DocumentIncarnation"`
+	Events              []ScheduledEvent `json:"Events"`
+}
+
+type startRequest struct {
+	EventId string `json:"EventId"`
+}
+
+// Container is the game server container, stopping it makes the server save the world
+type Container interface {
+	Stop(ctx context.Context) error
+	Running(ctx context.Context) (bool, error)
+}
+
+type DockerContainer struct {
+	Name string
+}
+
+func (dc DockerContainer) Stop(ctx context.Context) error {
+	output, err := exec.CommandContext(ctx, "docker", "stop", "--time", strconv.Itoa(int(stopTimeout.Seconds())), dc.Name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error stopping %s: %v: %s", dc.Name, err, output)
+	}
+	return nil
+}
+
+func (dc DockerContainer) Running(ctx context.Context) (bool, error) {
+	output, err := exec.CommandContext(ctx, "docker", "inspect", "--format", "{{.State.Running}}", dc.Name).Output()
+	if err != nil {
+		return false, fmt.Errorf("error inspecting %s: %v", dc.Name, err)
+	}
+	return strings.TrimSpace(string(output)) == "true", nil
+}
+
+// EvictionWatcher polls the scheduled events of the vm and saves the world before a preemption
+type EvictionWatcher struct {
+	imdsUrl   string
+	vmName    string
+	container Container
+	agent     *Agent
+	client    *http.Client
+	handled   map[string]bool
+}
+
+// NewEvictionWatcher watches the events of vmName, or of every vm of the document when it's empty
+func NewEvictionWatcher(imdsUrl, vmName string, container Container, agent *Agent) *EvictionWatcher {
+	return &EvictionWatcher{
+		imdsUrl:   imdsUrl,
+		vmName:    vmName,
+		container: container,
+		agent:     agent,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		handled:   make(map[string]bool),
+	}
+}
+
+// Run polls every pollInterval until ctx is done, azure recommends polling at least once a second for preemptions
+func (ew *EvictionWatcher) Run(ctx context.Context, pollInterval time.Duration) {
+	for {
+		if err := ew.poll(ctx); err != nil {
+			log.Printf("Error polling scheduled events: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (ew *EvictionWatcher) poll(ctx context.Context) error {
+	document, err := ew.scheduledEvents(ctx)
+	if err != nil {
+		return err
+	}
+	for _, event := range document.Events {
+		if event.EventType != "Preempt" || ew.handled[event.EventId] {
+			continue
+		}
+		if ew.vmName != "" && !slices.Contains(event.Resources, ew.vmName) {
+			continue
+		}
+		ew.handled[event.EventId] = true
+		ew.handlePreempt(ctx, event)
+	}
+	return nil
+}
+
+// handlePreempt stops the server so it saves the world, tells the bot and only then acknowledges the event,
+// since acknowledging it lets azure evict the vm right away
+func (ew *EvictionWatcher) handlePreempt(ctx context.Context, event ScheduledEvent) {
+	log.Printf("Preempt event %s scheduled not before %s, stopping the game server", event.EventId, event.NotBefore)
+	if err := ew.container.Stop(ctx); err != nil {
+		log.Printf("Error stopping the game server: %v", err)
+	}
+	if err := ew.agent.Emit(events.Evicted, events.EvictedPayload{EventId: event.EventId, NotBefore: event.NotBefore}); err != nil {
+		log.Printf("Error sending evicted event: %v", err)
+	}
+	if err := ew.acknowledge(ctx, event.EventId); err != nil {
+		log.Printf("Error acknowledging event %s: %v", event.EventId, err)
+	}
+}
+
+func (ew *EvictionWatcher) scheduledEvents(ctx context.Context) (scheduledEvents, error) {
+	var document scheduledEvents
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ew.imdsUrl+scheduledEventsPath, nil)
+	if err != nil {
+		return document, err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := ew.client.Do(req)
+	if err != nil {
+		return document, fmt.Errorf("error getting scheduled events: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return document, fmt.Errorf("error getting scheduled events: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return document, fmt.Errorf("error decoding scheduled events: %v", err)
+	}
+	return document, nil
+}
+
+func (ew *EvictionWatcher) acknowledge(ctx context.Context, eventId string) error {
+	body, err := json.Marshal(map[string][]startRequest{"StartRequests": {{EventId: eventId}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ew.imdsUrl+scheduledEventsPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Metadata", "true")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ew.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return nil
+}
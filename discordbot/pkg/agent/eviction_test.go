@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"godin/pkg/events"
+	"godin/pkg/queue"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type TestContainer struct {
+	stops   int
+	running bool
+}
+
+func (tc *TestContainer) Stop(ctx context.Context) error {
+	tc.stops++
+	tc.running = false
+	return nil
+}
+
+func (tc *TestContainer) Running(ctx context.Context) (bool, error) {
+	return tc.running, nil
+}
+
+func TestEvictionWatcher(t *testing.T) {
+	document := `{"DocumentIncarnation": 2, "Events": [
+		{"EventId": "redeploy-1", "EventType": "Redeploy", "Resources": ["valheim-server-vmss_0"], "EventStatus": "Scheduled"},
+		{"EventId": "preempt-1", "EventType": "Preempt", "Resources": ["other-vm"], "EventStatus": "Scheduled"},
+		{"EventId": "preempt-2", "EventType": "Preempt", "Resources": ["valheim-server-vmss_0"], "EventStatus": "Scheduled", "NotBefore": "Sat, 05 Oct 2024 22:00:30 GMT"}
+	]}`
+	acknowledged := []string{}
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Path != "/metadata/scheduledevents" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost {
+			var body struct {
+				StartRequests []startRequest
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			for _, request := range body.StartRequests {
+				acknowledged = append(acknowledged, request.EventId)
+			}
+			return
+		}
+		w.Write([]byte(document))
+	}))
+	defer imds.Close()
+
+	eventsQueue := queue.NewMemoryQueue()
+	dedupe, _ := LoadDedupe(filepath.Join(t.TempDir(), "state.json"))
+	container := &TestContainer{}
+	watcher := NewEvictionWatcher(imds.URL, "valheim-server-vmss_0", container, NewAgent(nil, nil, eventsQueue, dedupe))
+	for i := 0; i < 2; i++ {
+		if err := watcher.poll(context.Background()); err != nil {
+			t.Fatalf("error polling scheduled events: %v", err)
+		}
+	}
+
+	if container.stops != 1 {
+		t.Errorf("expected the game server to be stopped once but was %d times", container.stops)
+	}
+	if !reflect.DeepEqual(acknowledged, []string{"preempt-2"}) {
+		t.Errorf("expected preempt-2 to be acknowledged but was %v", acknowledged)
+	}
+	msg, _ := eventsQueue.Dequeue(time.Minute)
+	if msg == nil {
+		t.Fatalf("expected an evicted event")
+	}
+	event, _ := events.Decode(msg.Text)
+	var payload events.EvictedPayload
+	event.DecodePayload(&payload)
+	if event.Type != events.Evicted || payload.EventId != "preempt-2" {
+		t.Errorf("expected evicted event for preempt-2 but was %s for %s", event.Type, payload.EventId)
+	}
+	if msg, _ := eventsQueue.Dequeue(time.Minute); msg != nil {
+		t.Errorf("expected a single evicted event but got %s", msg.Text)
+	}
+}
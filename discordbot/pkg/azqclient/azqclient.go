@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"godin/pkg/queue"
 	"os"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
 )
 
@@ -35,8 +38,8 @@ func NewQueueClient(queueName string) (*QueueClient, error) {
 	}, nil
 }
 
-// EnqueueMessage adds a message to the queue
-func (qc *QueueClient) EnqueueMessage(message string) error {
+// Enqueue adds a message to the queue
+func (qc *QueueClient) Enqueue(message string) error {
 	b64message := base64.StdEncoding.EncodeToString([]byte(message))
 	_, err := qc.client.EnqueueMessage(context.Background(), b64message, nil)
 	if err != nil {
@@ -44,3 +47,54 @@ func (qc *QueueClient) EnqueueMessage(message string) error {
 	}
 	return nil
 }
+
+// Dequeue gets the next visible message, hiding it for visibilityTimeout, or nil when the queue is empty
+func (qc *QueueClient) Dequeue(visibilityTimeout time.Duration) (*queue.Message, error) {
+	timeout := int32(visibilityTimeout.Seconds())
+	resp, err := qc.client.DequeueMessage(context.Background(), &azqueue.DequeueMessageOptions{
+		VisibilityTimeout: &timeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to dequeue message: %w", err)
+	}
+	if len(resp.Messages) == 0 {
+		return nil, nil
+	}
+	dequeued := resp.Messages[0]
+	text, err := base64.StdEncoding.DecodeString(*dequeued.MessageText)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message %s: %w", *dequeued.MessageID, err)
+	}
+	return &queue.Message{
+		Id:           *dequeued.MessageID,
+		PopReceipt:   *dequeued.PopReceipt,
+		Text:         string(text),
+		DequeueCount: *dequeued.DequeueCount,
+	}, nil
+}
+
+// Delete removes a dequeued message for good
+func (qc *QueueClient) Delete(msg *queue.Message) error {
+	_, err := qc.client.DeleteMessage(context.Background(), msg.Id, msg.PopReceipt, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	return nil
+}
+
+// NewQueueClientWithManagedIdentity creates a QueueClient authenticated with the managed identity of the vm it runs on,
+// the credential caches its token and refreshes it before it expires
+func NewQueueClientWithManagedIdentity(accountName, queueName string) (*QueueClient, error) {
+	credential, err := azidentity.NewManagedIdentityCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create managed identity credential: %w", err)
+	}
+	queueUrl := fmt.Sprintf("https://%s.queue.core.windows.net/%s", accountName, queueName)
+	queueClient, err := azqueue.NewQueueClient(queueUrl, credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create queue client: %w", err)
+	}
+	return &QueueClient{
+		client: queueClient,
+	}, nil
+}
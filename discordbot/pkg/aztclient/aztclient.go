@@ -6,17 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"reflect"
 	"time"
 
+	"godin/pkg/entitycodec"
 	"godin/pkg/godinerrors"
 	"godin/pkg/statestorageinterface"
 	"godin/pkg/utils"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
-	"github.com/iancoleman/strcase"
 )
 
 type WriteableEntity struct {
@@ -38,6 +38,19 @@ type TableClient struct {
 
 // NewTableClient creates a new instance of TableClient for the specified queue
 func NewTableClient(tableName, partitionKey, rowKey string) (TableClientInterface, error) {
+	tableClient, err := newClient(tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &TableClient{
+		client:       tableClient,
+		partitionKey: partitionKey,
+		rowKey:       rowKey,
+	}, nil
+}
+
+// newClient creates a client of the table in the AzureWebJobsStorage account
+func newClient(tableName string) (*aztables.Client, error) {
 	// Get the connection string from environment variables
 	connString := os.Getenv("AzureWebJobsStorage")
 	if connString == "" {
@@ -52,13 +65,7 @@ func NewTableClient(tableName, partitionKey, rowKey string) (TableClientInterfac
 	}
 
 	// Create a QueueClient for the specified queue
-	tableClient := serviceClient.NewClient(tableName)
-
-	return &TableClient{
-		client:       tableClient,
-		partitionKey: partitionKey,
-		rowKey:       rowKey,
-	}, nil
+	return serviceClient.NewClient(tableName), nil
 }
 
 func (tc *TableClient) createIfResourceNotFound(err error) (aztables.GetEntityResponse, error) {
@@ -99,7 +106,8 @@ func (tc *TableClient) Read(columns ...string) (map[string]interface{}, error) {
 	return state, nil
 }
 
-func (tc *TableClient) genEntity(state statestorageinterface.StateAttributes) aztables.EDMEntity {
+// genEntity encodes the state with entitycodec
+func (tc *TableClient) genEntity(state statestorageinterface.StateAttributes) (aztables.EDMEntity, error) {
 	timestamp := aztables.EDMDateTime(time.Now())
 	entity := aztables.Entity{
 		PartitionKey: tc.partitionKey,
@@ -107,23 +115,38 @@ func (tc *TableClient) genEntity(state statestorageinterface.StateAttributes) az
 		Timestamp:    timestamp,
 	}
 
-	properties := make(map[string]interface{})
-	value := reflect.ValueOf(state)
-	typeOfState := value.Type()
-	for i := 0; i < value.NumField(); i++ {
-		key := strcase.ToSnake(typeOfState.Field(i).Name)
-		value := value.Field(i).Interface()
-		properties[key] = value.(string)
+	properties, err := entitycodec.Encode(state)
+	if err != nil {
+		return aztables.EDMEntity{}, err
 	}
 	return aztables.EDMEntity{
 		Entity:     entity,
-		Properties: properties,
+		Properties: edmProperties(properties),
+	}, nil
+}
+
+// edmProperties types the timestamps and integers of entitycodec properties, so azure keeps them as such
+func edmProperties(properties map[string]interface{}) map[string]interface{} {
+	typed := make(map[string]interface{}, len(properties))
+	for key, value := range properties {
+		switch value := value.(type) {
+		case time.Time:
+			typed[key] = aztables.EDMDateTime(value)
+		case int64:
+			typed[key] = aztables.EDMInt64(value)
+		default:
+			typed[key] = value
+		}
 	}
+	return typed
 }
 
 // Write to table
 func (tc *TableClient) Write(state statestorageinterface.StateAttributes) error {
-	entity := tc.genEntity(state)
+	entity, err := tc.genEntity(state)
+	if err != nil {
+		return err
+	}
 	updateOpts := aztables.UpdateEntityOptions{
 		IfMatch:    tc.etag,
 		UpdateMode: aztables.UpdateModeReplace,
@@ -134,10 +157,17 @@ func (tc *TableClient) Write(state statestorageinterface.StateAttributes) error
 	}
 	log.Printf("Updating entity with: %s", string(entityBytes))
 	updatedEntity, err := tc.client.UpdateEntity(context.TODO(), entityBytes, &updateOpts)
-	tc.etag = &updatedEntity.ETag
 	if err != nil {
+		var responseError *azcore.ResponseError
+		if errors.As(err, &responseError) && responseError.StatusCode == http.StatusPreconditionFailed {
+			return godinerrors.WriteError{
+				Code:    godinerrors.ConflictError,
+				Message: fmt.Sprintf("error updating entity, it changed since it was read: %v", err),
+			}
+		}
 		return err
 	}
+	tc.etag = &updatedEntity.ETag
 	return nil
 }
 
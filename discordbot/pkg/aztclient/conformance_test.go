@@ -0,0 +1,27 @@
+package aztclient_test
+
+import (
+	"godin/pkg/aztclient"
+	"godin/pkg/tclienttest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestConformance runs against the existing table TEST_STATE_STORAGE_NAME of the AzureWebJobsStorage account,
+// azurite works too
+func TestConformance(t *testing.T) {
+	tableName := os.Getenv("TEST_STATE_STORAGE_NAME")
+	if tableName == "" {
+		t.Skip("no table to test against, set TEST_STATE_STORAGE_NAME and AzureWebJobsStorage")
+	}
+	// entities are left behind, a prefix per run keeps runs apart
+	prefix := strconv.FormatInt(time.Now().UnixNano(), 10) + "-"
+	tclienttest.Run(t, func(partitionKey, rowKey string) (aztclient.TableClientInterface, error) {
+		return aztclient.NewTableClient(tableName, partitionKey, rowKey)
+	}, prefix)
+	tclienttest.RunPartition(t, func(partitionKey string) (aztclient.PartitionClientInterface, error) {
+		return aztclient.NewPartitionClient(tableName, partitionKey)
+	}, prefix)
+}
@@ -0,0 +1,175 @@
+package aztclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"godin/pkg/godinerrors"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
+)
+
+// Row is an entity of a partition, its properties are encoded with entitycodec. ETag is empty for rows
+// that were never written
+type Row struct {
+	Key        string
+	ETag       string
+	Properties map[string]interface{}
+}
+
+// PartitionClientInterface keeps the rows of a partition, for records kept next to the state entity
+type PartitionClientInterface interface {
+	// Get returns the row of key, without etag nor properties if it doesn't exist
+	Get(key string) (Row, error)
+	// Put creates the row when its ETag is empty, failing with a conflict if it exists, or replaces it,
+	// failing with a conflict if it changed since it was read
+	Put(row Row) error
+	// List returns up to limit rows in key order, starting after the key after
+	List(after string, limit int) ([]Row, error)
+	// Delete removes the row of key, rows that don't exist are ignored
+	Delete(key string) error
+}
+
+// NewestFirstKey is a row key prefix that lists later times first, List returns rows in ascending key order
+func NewestFirstKey(at time.Time) string {
+	return fmt.Sprintf("%019d", math.MaxInt64-at.UnixNano())
+}
+
+// PartitionClient is a partition of an azure table
+type PartitionClient struct {
+	partitionKey string
+	client       *aztables.Client
+}
+
+// NewPartitionClient creates a PartitionClient for partitionKey in the table
+func NewPartitionClient(tableName, partitionKey string) (PartitionClientInterface, error) {
+	client, err := newClient(tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &PartitionClient{
+		partitionKey: partitionKey,
+		client:       client,
+	}, nil
+}
+
+func (pc *PartitionClient) Get(key string) (Row, error) {
+	entity, err := pc.client.GetEntity(context.TODO(), pc.partitionKey, key, nil)
+	if err != nil {
+		var responseError *azcore.ResponseError
+		if errors.As(err, &responseError) && responseError.ErrorCode == string(aztables.ResourceNotFound) {
+			return Row{Key: key}, nil
+		}
+		return Row{}, fmt.Errorf("error reading row %s: %v", key, err)
+	}
+	row, err := parseRow(entity.Value)
+	if err != nil {
+		return Row{}, err
+	}
+	row.ETag = string(entity.ETag)
+	return row, nil
+}
+
+// parseRow reads an entity, leaving out its keys, timestamp and odata annotations
+func parseRow(value []byte) (Row, error) {
+	properties := map[string]interface{}{}
+	if err := json.Unmarshal(value, &properties); err != nil {
+		return Row{}, fmt.Errorf("error unmarshalling row: %v", err)
+	}
+	row := Row{Properties: map[string]interface{}{}}
+	for key, property := range properties {
+		switch {
+		case key == "RowKey":
+			row.Key, _ = property.(string)
+		case key == "PartitionKey" || key == "Timestamp" || strings.Contains(key, "odata."):
+		default:
+			row.Properties[key] = property
+		}
+	}
+	if etag, ok := properties["odata.etag"].(string); ok {
+		row.ETag = etag
+	}
+	return row, nil
+}
+
+func (pc *PartitionClient) Put(row Row) error {
+	entity := aztables.EDMEntity{
+		Entity: aztables.Entity{
+			PartitionKey: pc.partitionKey,
+			RowKey:       row.Key,
+			Timestamp:    aztables.EDMDateTime(time.Now()),
+		},
+		Properties: edmProperties(row.Properties),
+	}
+	entityBytes, err := entity.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if row.ETag == "" {
+		_, err = pc.client.AddEntity(context.TODO(), entityBytes, nil)
+	} else {
+		etag := azcore.ETag(row.ETag)
+		_, err = pc.client.UpdateEntity(context.TODO(), entityBytes, &aztables.UpdateEntityOptions{
+			IfMatch:    &etag,
+			UpdateMode: aztables.UpdateModeReplace,
+		})
+	}
+	if err != nil {
+		var responseError *azcore.ResponseError
+		if errors.As(err, &responseError) && (responseError.StatusCode == http.StatusConflict || responseError.StatusCode == http.StatusPreconditionFailed) {
+			return godinerrors.WriteError{
+				Code:    godinerrors.ConflictError,
+				Message: fmt.Sprintf("error writing row %s, it changed since it was read: %v", row.Key, err),
+			}
+		}
+		return fmt.Errorf("error writing row %s: %v", row.Key, err)
+	}
+	return nil
+}
+
+func (pc *PartitionClient) List(after string, limit int) ([]Row, error) {
+	filter := fmt.Sprintf("PartitionKey eq '%s' and RowKey gt '%s'", escapeFilter(pc.partitionKey), escapeFilter(after))
+	top := int32(min(limit, 1000))
+	pager := pc.client.NewListEntitiesPager(&aztables.ListEntitiesOptions{Filter: &filter, Top: &top})
+	rows := []Row{}
+	for len(rows) < limit && pager.More() {
+		page, err := pager.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("error listing rows: %v", err)
+		}
+		for _, value := range page.Entities {
+			row, err := parseRow(value)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows, nil
+}
+
+// escapeFilter quotes a value of an odata filter string
+func escapeFilter(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
+
+func (pc *PartitionClient) Delete(key string) error {
+	if _, err := pc.client.DeleteEntity(context.TODO(), pc.partitionKey, key, nil); err != nil {
+		var responseError *azcore.ResponseError
+		if errors.As(err, &responseError) && responseError.ErrorCode == string(aztables.ResourceNotFound) {
+			return nil
+		}
+		return fmt.Errorf("error deleting row %s: %v", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package cmdclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"godin/pkg/commands"
+	"io"
+	"net/http"
+)
+
+const DiscordApiUrl = "https://discord.com/api/v10"
+
+// Client registers application commands through the discord REST API,
+// globally or, when a guild id is given, for that guild only
+type Client struct {
+	baseUrl       string
+	botToken      string
+	applicationId string
+	guildId       string
+}
+
+func NewClient(baseurl, bottoken, applicationid, guildid string) commands.Registry {
+	return &Client{
+		baseUrl:       baseurl,
+		botToken:      bottoken,
+		applicationId: applicationid,
+		guildId:       guildid,
+	}
+}
+
+func (c *Client) commandsUrl() string {
+	if c.guildId != "" {
+		return fmt.Sprintf("%s/applications/%s/guilds/%s/commands", c.baseUrl, c.applicationId, c.guildId)
+	}
+	return fmt.Sprintf("%s/applications/%s/commands", c.baseUrl, c.applicationId)
+}
+
+func (c *Client) do(method, url string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error marshalling request body: %v", err)
+		}
+		reqBody = bytes.NewReader(bodyBytes)
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", "Bot "+c.botToken)
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading body: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s failed with status %d: %s", method, url, resp.StatusCode, respBody)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("error unmarshalling response: %v", err)
+	}
+	return nil
+}
+
+func (c *Client) List() ([]commands.Command, error) {
+	registered := []commands.Command{}
+	if err := c.do(http.MethodGet, c.commandsUrl(), nil, &registered); err != nil {
+		return nil, err
+	}
+	return registered, nil
+}
+
+func (c *Client) Create(cmd commands.Command) error {
+	return c.do(http.MethodPost, c.commandsUrl(), cmd, nil)
+}
+
+func (c *Client) Edit(id string, cmd commands.Command) error {
+	cmd.ID = ""
+	return c.do(http.MethodPatch, c.commandsUrl()+"/"+id, cmd, nil)
+}
+
+func (c *Client) Delete(id string) error {
+	return c.do(http.MethodDelete, c.commandsUrl()+"/"+id, nil, nil)
+}
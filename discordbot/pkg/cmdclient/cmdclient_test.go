@@ -0,0 +1,113 @@
+package cmdclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"godin/pkg/commands"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDiscord stands in for the application commands endpoints of the discord API
+type fakeDiscord struct {
+	mu       sync.Mutex
+	nextId   int
+	commands map[string]commands.Command
+	requests []string
+}
+
+func (fd *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.requests = append(fd.requests, r.Method+" "+r.URL.Path)
+	if r.Header.Get("Authorization") != "Bot token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	prefix := "/applications/app/guilds/guild/commands"
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	switch r.Method {
+	case http.MethodGet:
+		registered := []commands.Command{}
+		for _, cmd := range fd.commands {
+			registered = append(registered, cmd)
+		}
+		json.NewEncoder(w).Encode(registered)
+	case http.MethodPost, http.MethodPatch:
+		var cmd commands.Command
+		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost {
+			fd.nextId++
+			id = fmt.Sprint(fd.nextId)
+		}
+		cmd.ID = id
+		// discord fills in defaults the definitions leave unset
+		cmd.DMPermission = new(bool)
+		*cmd.DMPermission = true
+		fd.commands[id] = cmd
+		json.NewEncoder(w).Encode(cmd)
+	case http.MethodDelete:
+		delete(fd.commands, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestSync(t *testing.T) {
+	fd := &fakeDiscord{
+		nextId: 100,
+		commands: map[string]commands.Command{
+			"1": {ID: "1", Type: commands.ChatInputCommand, Name: "ping", Description: "Check if the bot is alive"},
+			"2": {ID: "2", Type: commands.ChatInputCommand, Name: "start", Description: "outdated description"},
+			"3": {ID: "3", Type: commands.ChatInputCommand, Name: "restart", Description: "no longer supported"},
+		},
+	}
+	server := httptest.NewServer(fd)
+	defer server.Close()
+	client := NewClient(server.URL, "token", "app", "guild")
+
+	registered, err := client.List()
+	if err != nil {
+		t.Fatalf("error listing commands: %v", err)
+	}
+	changes := commands.Plan(commands.Definitions, registered, true)
+	planned := []string{}
+	for _, change := range changes {
+		planned = append(planned, change.String())
+	}
+	expected := []string{"update /start", "create /stop", "create /status", "create /history", "create /sessions", "create /leaderboard", "create /whois", "create /replay", "delete /restart"}
+	if !reflect.DeepEqual(planned, expected) {
+		t.Errorf("expected plan to be %v but was %v", expected, planned)
+	}
+	if err := commands.Apply(client, changes); err != nil {
+		t.Fatalf("error applying changes: %v", err)
+	}
+
+	registered, err = client.List()
+	if err != nil {
+		t.Fatalf("error listing commands: %v", err)
+	}
+	if changes := commands.Plan(commands.Definitions, registered, true); len(changes) != 0 {
+		t.Errorf("expected no changes after sync but got %v", changes)
+	}
+	if len(registered) != len(commands.Definitions) {
+		t.Errorf("expected %d registered commands but got %d", len(commands.Definitions), len(registered))
+	}
+}
+
+func TestPlanWithoutPrune(t *testing.T) {
+	registered := []commands.Command{
+		{ID: "3", Type: commands.ChatInputCommand, Name: "restart", Description: "no longer supported"},
+	}
+	for _, change := range commands.Plan(commands.Definitions, registered, false) {
+		if change.Kind == commands.Delete {
+			t.Errorf("expected no deletes without prune but got %s", change)
+		}
+	}
+}
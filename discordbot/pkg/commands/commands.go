@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"godin/pkg/utils"
+	"sort"
+)
+
+// Application command types
+const (
+	ChatInputCommand = 1
+)
+
+// Application command option types
+const (
+	StringOption = 3
+)
+
+type Choice struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+type Option struct {
+	Type        int      `json:"type"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Required    bool     `json:"required,omitempty"`
+	Choices     []Choice `json:"choices,omitempty"`
+	Options     []Option `json:"options,omitempty"`
+}
+
+// Command is a slash command definition as the discord application commands API takes it
+type Command struct {
+	ID          string   `json:"id,omitempty"`
+	Type        int      `json:"type,omitempty"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Options     []Option `json:"options,omitempty"`
+	// permission bit set, as a string, a member needs to see the command. "0" hides it from everyone but admins
+	DefaultMemberPermissions *string `json:"default_member_permissions,omitempty"`
+	DMPermission             *bool   `json:"dm_permission,omitempty"`
+}
+
+// Definitions are the commands InteractionHandler supports, godin-register syncs them to discord
+var Definitions = []Command{
+	{
+		Type:        ChatInputCommand,
+		Name:        "ping",
+		Description: "Check if the bot is alive",
+	},
+	{
+		Type:        ChatInputCommand,
+		Name:        "start",
+		Description: "Start the Valheim server",
+	},
+	{
+		Type:        ChatInputCommand,
+		Name:        "stop",
+		Description: "Stop the Valheim server",
+	},
+	{
+		Type:        ChatInputCommand,
+		Name:        "status",
+		Description: "Show the Valheim server status, connect address and online players",
+	},
+	{
+		Type:        ChatInputCommand,
+		Name:        "history",
+		Description: "Page through the recent changes of the Valheim server state",
+	},
+	{
+		Type:        ChatInputCommand,
+		Name:        "sessions",
+		Description: "Summarize the recent Valheim server sessions and the monthly uptime",
+	},
+	{
+		Type:        ChatInputCommand,
+		Name:        "leaderboard",
+		Description: "List the players with the most playtime",
+	},
+	{
+		Type:        ChatInputCommand,
+		Name:        "whois",
+		Description: "Show the playtime of a player",
+		Options: []Option{
+			{
+				Type:        StringOption,
+				Name:        "player",
+				Description: "Steam name or id of the player",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Type:                     ChatInputCommand,
+		Name:                     "replay",
+		Description:              "Enqueue the last event that failed to be handled again",
+		DefaultMemberPermissions: utils.ToPtr("0"),
+	},
+}
+
+// Registry is where commands are registered, either the global or a guild application commands
+type Registry interface {
+	List() ([]Command, error)
+	Create(Command) error
+	Edit(id string, cmd Command) error
+	Delete(id string) error
+}
+
+type ChangeKind string
+
+const (
+	Create ChangeKind = "create"
+	Update ChangeKind = "update"
+	Delete ChangeKind = "delete"
+)
+
+type Change struct {
+	Kind    ChangeKind
+	Command Command
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s /%s", c.Kind, c.Command.Name)
+}
+
+// equal compares the fields godin manages, fields left unset in the definition are whatever discord defaults them to
+func equal(definition, registered Command) bool {
+	registered.ID = ""
+	if definition.DefaultMemberPermissions == nil {
+		registered.DefaultMemberPermissions = nil
+	}
+	if definition.DMPermission == nil {
+		registered.DMPermission = nil
+	}
+	if definition.Type == 0 {
+		registered.Type = 0
+	}
+	definitionJson, _ := json.Marshal(definition)
+	registeredJson, _ := json.Marshal(registered)
+	return string(definitionJson) == string(registeredJson)
+}
+
+// Plan diffs the definitions against the registered commands, registered commands without a definition
+// are only deleted when prune is set
+func Plan(definitions, registered []Command, prune bool) []Change {
+	byName := make(map[string]Command)
+	for _, cmd := range registered {
+		byName[cmd.Name] = cmd
+	}
+	changes := []Change{}
+	defined := make(map[string]bool)
+	for _, def := range definitions {
+		defined[def.Name] = true
+		current, ok := byName[def.Name]
+		if !ok {
+			changes = append(changes, Change{Kind: Create, Command: def})
+			continue
+		}
+		if !equal(def, current) {
+			def.ID = current.ID
+			changes = append(changes, Change{Kind: Update, Command: def})
+		}
+	}
+	if prune {
+		names := []string{}
+		for name := range byName {
+			if !defined[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			changes = append(changes, Change{Kind: Delete, Command: byName[name]})
+		}
+	}
+	return changes
+}
+
+// Apply runs the planned changes against the registry
+func Apply(registry Registry, changes []Change) error {
+	for _, change := range changes {
+		var err error
+		switch change.Kind {
+		case Create:
+			err = registry.Create(change.Command)
+		case Update:
+			err = registry.Edit(change.Command.ID, change.Command)
+		case Delete:
+			err = registry.Delete(change.Command.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("error applying %s: %v", change, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package discinteraction
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Interaction types from Discord API
+const (
+	InteractionPing             = 1
+	InteractionCommand          = 2
+	InteractionMessageComponent = 3
+)
+
+// Message component types from Discord API
+const (
+	ComponentActionRow = 1
+	ComponentButton    = 2
+)
+
+// Button styles from Discord API
+const (
+	ButtonPrimary   = 1
+	ButtonSecondary = 2
+	ButtonSuccess   = 3
+	ButtonDanger    = 4
+)
+
+// Application command option types from Discord API
+const (
+	OptionSubcommand      = 1
+	OptionSubcommandGroup = 2
+	OptionString          = 3
+	OptionInteger         = 4
+	OptionBoolean         = 5
+	OptionUser            = 6
+	OptionChannel         = 7
+	OptionRole            = 8
+	OptionMentionable     = 9
+	OptionNumber          = 10
+)
+
+type User struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+}
+
+// Member is the guild member that invoked the interaction, it is only sent for interactions in a guild
+type Member struct {
+	User  *User    `json:"user"`
+	Nick  string   `json:"nick"`
+	Roles []string `json:"roles"`
+}
+
+type Role struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Resolved holds the full objects for the ids sent as user and role option values
+type Resolved struct {
+	Users   map[string]User   `json:"users"`
+	Members map[string]Member `json:"members"`
+	Roles   map[string]Role   `json:"roles"`
+}
+
+type Option struct {
+	Name    string          `json:"name"`
+	Type    int             `json:"type"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Options []Option        `json:"options,omitempty"`
+}
+
+// Data is the command data for command interactions, and the clicked component for component interactions
+type Data struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Type     int      `json:"type"`
+	Options  []Option `json:"options"`
+	Resolved Resolved `json:"resolved"`
+
+	CustomID      string `json:"custom_id"`
+	ComponentType int    `json:"component_type"`
+}
+
+// Interaction structure to parse JSON payload from Discord
+type Interaction struct {
+	ID            string  `json:"id"`
+	ApplicationID string  `json:"application_id"`
+	Type          int     `json:"type"`
+	Token         string  `json:"token"`
+	GuildID       string  `json:"guild_id"`
+	ChannelID     string  `json:"channel_id"`
+	Data          Data    `json:"data"`
+	Member        *Member `json:"member"`
+	User          *User   `json:"user"`
+}
+
+// Invoker returns the user that ran the command, discord sends it under member in guilds and under user in DMs
+func (i Interaction) Invoker() User {
+	if i.Member != nil && i.Member.User != nil {
+		return *i.Member.User
+	}
+	if i.User != nil {
+		return *i.User
+	}
+	return User{}
+}
+
+// Roles returns the guild role ids of the invoker, empty outside of guilds
+func (i Interaction) Roles() []string {
+	if i.Member == nil {
+		return []string{}
+	}
+	return i.Member.Roles
+}
+
+// Command is an invoked slash command with its subcommand group and subcommand resolved
+type Command struct {
+	Name            string
+	SubcommandGroup string
+	Subcommand      string
+	Options         Options
+}
+
+// Path returns the full command as typed by the user, e.g. "server start"
+func (c Command) Path() string {
+	parts := []string{c.Name}
+	if c.SubcommandGroup != "" {
+		parts = append(parts, c.SubcommandGroup)
+	}
+	if c.Subcommand != "" {
+		parts = append(parts, c.Subcommand)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Command walks down subcommand groups and subcommands so the options returned are the leaf ones
+func (i Interaction) Command() Command {
+	cmd := Command{Name: i.Data.Name}
+	options := i.Data.Options
+	if len(options) == 1 && options[0].Type == OptionSubcommandGroup {
+		cmd.SubcommandGroup = options[0].Name
+		options = options[0].Options
+	}
+	if len(options) == 1 && options[0].Type == OptionSubcommand {
+		cmd.Subcommand = options[0].Name
+		options = options[0].Options
+	}
+	cmd.Options = Options{
+		options:  options,
+		resolved: i.Data.Resolved,
+	}
+	return cmd
+}
+
+// Options gives typed access to the values of a command options,
+// the getters return false when the option wasn't sent or has another type
+type Options struct {
+	options  []Option
+	resolved Resolved
+}
+
+func (o Options) Len() int {
+	return len(o.options)
+}
+
+func (o Options) find(name string, types ...int) (Option, bool) {
+	for _, opt := range o.options {
+		if opt.Name != name {
+			continue
+		}
+		for _, t := range types {
+			if opt.Type == t {
+				return opt, true
+			}
+		}
+		return Option{}, false
+	}
+	return Option{}, false
+}
+
+func (o Options) String(name string) (string, bool) {
+	opt, ok := o.find(name, OptionString)
+	if !ok {
+		return "", false
+	}
+	var value string
+	if err := json.Unmarshal(opt.Value, &value); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+func (o Options) Int(name string) (int64, bool) {
+	opt, ok := o.find(name, OptionInteger)
+	if !ok {
+		return 0, false
+	}
+	var value int64
+	if err := json.Unmarshal(opt.Value, &value); err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+func (o Options) Bool(name string) (bool, bool) {
+	opt, ok := o.find(name, OptionBoolean)
+	if !ok {
+		return false, false
+	}
+	var value bool
+	if err := json.Unmarshal(opt.Value, &value); err != nil {
+		return false, false
+	}
+	return value, true
+}
+
+// id returns the snowflake value of user, role and mentionable options
+func (o Options) id(name string, types ...int) (string, bool) {
+	opt, ok := o.find(name, types...)
+	if !ok {
+		return "", false
+	}
+	var id string
+	if err := json.Unmarshal(opt.Value, &id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// User returns the resolved user of a user option, only the id is set if discord didn't resolve it
+func (o Options) User(name string) (User, bool) {
+	id, ok := o.id(name, OptionUser, OptionMentionable)
+	if !ok {
+		return User{}, false
+	}
+	if user, ok := o.resolved.Users[id]; ok {
+		return user, true
+	}
+	if _, isRole := o.resolved.Roles[id]; isRole {
+		return User{}, false
+	}
+	return User{ID: id}, true
+}
+
+// Role returns the resolved role of a role option, only the id is set if discord didn't resolve it
+func (o Options) Role(name string) (Role, bool) {
+	id, ok := o.id(name, OptionRole, OptionMentionable)
+	if !ok {
+		return Role{}, false
+	}
+	if role, ok := o.resolved.Roles[id]; ok {
+		return role, true
+	}
+	if _, isUser := o.resolved.Users[id]; isUser {
+		return Role{}, false
+	}
+	return Role{ID: id}, true
+}
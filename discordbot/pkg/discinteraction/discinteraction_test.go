@@ -0,0 +1,86 @@
+package discinteraction
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCommandOptions(t *testing.T) {
+	payload := `{
+		"type": 2,
+		"token": "token1",
+		"guild_id": "guild1",
+		"member": {"user": {"id": "100", "username": "viking"}, "roles": ["admins"]},
+		"data": {
+			"name": "admin",
+			"options": [{
+				"name": "player",
+				"type": 2,
+				"options": [{
+					"name": "ban",
+					"type": 1,
+					"options": [
+						{"name": "player", "type": 6, "value": "200"},
+						{"name": "reason", "type": 3, "value": "griefing"},
+						{"name": "days", "type": 4, "value": 7},
+						{"name": "silent", "type": 5, "value": true},
+						{"name": "role", "type": 8, "value": "300"}
+					]
+				}]
+			}],
+			"resolved": {
+				"users": {"200": {"id": "200", "username": "griefer"}},
+				"roles": {"300": {"id": "300", "name": "vikings"}}
+			}
+		}
+	}`
+	var interaction Interaction
+	if err := json.Unmarshal([]byte(payload), &interaction); err != nil {
+		t.Fatalf("error unmarshalling interaction: %v", err)
+	}
+	if invoker := interaction.Invoker(); invoker.ID != "100" {
+		t.Errorf("expected invoker to be 100 but was %s", invoker.ID)
+	}
+	if roles := interaction.Roles(); len(roles) != 1 || roles[0] != "admins" {
+		t.Errorf("expected roles to be [admins] but were %v", roles)
+	}
+	cmd := interaction.Command()
+	if cmd.Path() != "admin player ban" {
+		t.Errorf("expected command path to be 'admin player ban' but was '%s'", cmd.Path())
+	}
+	if user, ok := cmd.Options.User("player"); !ok || user.Username != "griefer" {
+		t.Errorf("expected player option to be resolved to griefer but was %v (%t)", user, ok)
+	}
+	if reason, ok := cmd.Options.String("reason"); !ok || reason != "griefing" {
+		t.Errorf("expected reason to be griefing but was %s (%t)", reason, ok)
+	}
+	if days, ok := cmd.Options.Int("days"); !ok || days != 7 {
+		t.Errorf("expected days to be 7 but was %d (%t)", days, ok)
+	}
+	if silent, ok := cmd.Options.Bool("silent"); !ok || !silent {
+		t.Errorf("expected silent to be true but was %t (%t)", silent, ok)
+	}
+	if role, ok := cmd.Options.Role("role"); !ok || role.Name != "vikings" {
+		t.Errorf("expected role to be resolved to vikings but was %v (%t)", role, ok)
+	}
+	if _, ok := cmd.Options.Int("reason"); ok {
+		t.Errorf("expected reading a string option as integer to fail")
+	}
+	if _, ok := cmd.Options.String("missing"); ok {
+		t.Errorf("expected reading a missing option to fail")
+	}
+}
+
+func TestDMInvoker(t *testing.T) {
+	payload := `{"type": 2, "user": {"id": "100"}, "data": {"name": "status"}}`
+	var interaction Interaction
+	if err := json.Unmarshal([]byte(payload), &interaction); err != nil {
+		t.Fatalf("error unmarshalling interaction: %v", err)
+	}
+	if invoker := interaction.Invoker(); invoker.ID != "100" {
+		t.Errorf("expected invoker to be 100 but was %s", invoker.ID)
+	}
+	if cmd := interaction.Command(); cmd.Path() != "status" || cmd.Options.Len() != 0 {
+		t.Errorf("expected command to be status without options but was %s with %d options", cmd.Path(), cmd.Options.Len())
+	}
+}
@@ -9,22 +9,33 @@ import (
 
 type DiscordClientInterface interface {
 	SendMessage(msg string) error
+	SendAdminMessage(msg string) error
+	EditInteractionResponse(token, msg string) error
+	SendButtonMessage(msg, label, customId string) error
 }
 
 type DiscordClient struct {
-	channelId string
-	client    *discordgo.Session
+	channelId      string
+	adminChannelId string
+	applicationId  string
+	client         *discordgo.Session
 }
 
-func NewDiscordClient(bottoken, channelid string) (DiscordClientInterface, error) {
+// NewDiscordClient creates a client posting to channelid, admin messages go to adminchannelid or to channelid if it's empty
+func NewDiscordClient(bottoken, channelid, adminchannelid, applicationid string) (DiscordClientInterface, error) {
 	discord, err := discordgo.New("Bot " + bottoken)
 	if err != nil {
 		log.Printf("error creating discord client: %v", err)
 		return nil, fmt.Errorf("error creating discord client: %v", err)
 	}
+	if adminchannelid == "" {
+		adminchannelid = channelid
+	}
 	return &DiscordClient{
-		client:    discord,
-		channelId: channelid,
+		client:         discord,
+		channelId:      channelid,
+		adminChannelId: adminchannelid,
+		applicationId:  applicationid,
 	}, nil
 }
 
@@ -34,3 +45,46 @@ func (dc *DiscordClient) SendMessage(msg string) error {
 	}
 	return nil
 }
+
+// SendAdminMessage posts to the admin channel, for failures players don't need to see
+func (dc *DiscordClient) SendAdminMessage(msg string) error {
+	if _, err := dc.client.ChannelMessageSend(dc.adminChannelId, msg); err != nil {
+		return err
+	}
+	return nil
+}
+
+// EditInteractionResponse replaces the content of the original response of a deferred interaction,
+// interaction tokens are valid for 15 minutes after the interaction was received
+func (dc *DiscordClient) EditInteractionResponse(token, msg string) error {
+	interaction := &discordgo.Interaction{
+		AppID: dc.applicationId,
+		Token: token,
+	}
+	if _, err := dc.client.InteractionResponseEdit(interaction, &discordgo.WebhookEdit{Content: &msg}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SendButtonMessage posts to the channel with a single button, its clicks come to the interactions API with customId
+func (dc *DiscordClient) SendButtonMessage(msg, label, customId string) error {
+	message := &discordgo.MessageSend{
+		Content: msg,
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    label,
+						Style:    discordgo.PrimaryButton,
+						CustomID: customId,
+					},
+				},
+			},
+		},
+	}
+	if _, err := dc.client.ChannelMessageSendComplex(dc.channelId, message); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+// Package entitycodec converts structs to table entity properties and back, driven by their `table` struct tags:
+//
+//	Ip     string    `table:"ip,required"`
+//	Since  time.Time `table:"since"`
+//	Secret string    `table:"-"`
+//
+// Fields without a tag are named after their snake cased field name. Strings, bools and numbers are kept as they
+// are, timestamps as time.Time, omitted when zero so clearing one clears its column, and anything else, like
+// slices, maps and nested structs, as a json string, empty when nil, empty or zero.
+package entitycodec
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/strcase"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// field is a struct field along with its column
+type field struct {
+	index    int
+	column   string
+	required bool
+}
+
+// fields lists the columns of a struct type, in field order
+func fields(structType reflect.Type) []field {
+	columns := []field{}
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+		tag := structField.Tag.Get("table")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = strcase.ToSnake(structField.Name)
+		}
+		columns = append(columns, field{index: i, column: name, required: options == "required"})
+	}
+	return columns
+}
+
+// structValue dereferences v down to the struct it stands for
+func structValue(v interface{}) (reflect.Value, error) {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return reflect.Value{}, fmt.Errorf("error encoding entity: nil %s", value.Type())
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("error encoding entity: %s isn't a struct", value.Type())
+	}
+	return value, nil
+}
+
+// Required lists the columns tagged required, for TableClientInterface.Read
+func Required(v interface{}) []string {
+	value, err := structValue(v)
+	if err != nil {
+		return nil
+	}
+	required := []string{}
+	for _, f := range fields(value.Type()) {
+		if f.required {
+			required = append(required, f.column)
+		}
+	}
+	return required
+}
+
+// Encode returns the properties of the struct v
+func Encode(v interface{}) (map[string]interface{}, error) {
+	value, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+	properties := map[string]interface{}{}
+	for _, f := range fields(value.Type()) {
+		property, err := encodeValue(value.Field(f.index))
+		if err != nil {
+			return nil, fmt.Errorf("error encoding column %s: %v", f.column, err)
+		}
+		if property != nil {
+			properties[f.column] = property
+		}
+	}
+	return properties, nil
+}
+
+// encodeValue returns the property of a field, nil to leave the column out
+func encodeValue(value reflect.Value) (interface{}, error) {
+	if value.Type() == timeType {
+		at := value.Interface().(time.Time)
+		if at.IsZero() {
+			return nil, nil
+		}
+		return at.UTC(), nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Bool:
+		return value.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows a 64 bit integer", value.Uint())
+		}
+		return int64(value.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), nil
+	case reflect.Pointer:
+		if value.IsNil() {
+			return "", nil
+		}
+		return encodeJson(value)
+	case reflect.Slice, reflect.Map:
+		if value.Len() == 0 {
+			return "", nil
+		}
+		return encodeJson(value)
+	case reflect.Array, reflect.Struct:
+		if value.IsZero() {
+			return "", nil
+		}
+		return encodeJson(value)
+	}
+	return nil, fmt.Errorf("unsupported type %s", value.Type())
+}
+
+func encodeJson(value reflect.Value) (string, error) {
+	valueBytes, err := json.Marshal(value.Interface())
+	if err != nil {
+		return "", err
+	}
+	return string(valueBytes), nil
+}
+
+// Decode sets the fields of the struct v points to from properties. Missing columns leave their field zero,
+// required ones are checked by TableClientInterface.Read
+func Decode(properties map[string]interface{}, v interface{}) error {
+	pointer := reflect.ValueOf(v)
+	if pointer.Kind() != reflect.Pointer || pointer.IsNil() || pointer.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("error decoding entity: %T isn't a pointer to a struct", v)
+	}
+	value := pointer.Elem()
+	for _, f := range fields(value.Type()) {
+		target := value.Field(f.index)
+		target.Set(reflect.Zero(target.Type()))
+		property, ok := properties[f.column]
+		if !ok || property == nil {
+			continue
+		}
+		if err := decodeValue(property, target); err != nil {
+			return fmt.Errorf("error decoding column %s: %v", f.column, err)
+		}
+	}
+	return nil
+}
+
+// decodeValue sets target from a property, as the backends return it: json values, strings for 64 bit
+// integers and timestamps in azure tables, or the values Encode returned for in-memory ones
+func decodeValue(property interface{}, target reflect.Value) error {
+	if target.Type() == timeType {
+		at, err := decodeTime(property)
+		if err != nil {
+			return err
+		}
+		target.Set(reflect.ValueOf(at))
+		return nil
+	}
+	switch target.Kind() {
+	case reflect.String:
+		text, ok := property.(string)
+		if !ok {
+			return fmt.Errorf("expected a string but was %T", property)
+		}
+		target.SetString(text)
+	case reflect.Bool:
+		switch typed := property.(type) {
+		case bool:
+			target.SetBool(typed)
+		case string:
+			parsed, err := strconv.ParseBool(typed)
+			if err != nil {
+				return err
+			}
+			target.SetBool(parsed)
+		default:
+			return fmt.Errorf("expected a bool but was %T", property)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, err := decodeInt(property)
+		if err != nil {
+			return err
+		}
+		if target.OverflowInt(number) {
+			return fmt.Errorf("%d overflows %s", number, target.Type())
+		}
+		target.SetInt(number)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, err := decodeInt(property)
+		if err != nil {
+			return err
+		}
+		if number < 0 || target.OverflowUint(uint64(number)) {
+			return fmt.Errorf("%d overflows %s", number, target.Type())
+		}
+		target.SetUint(uint64(number))
+	case reflect.Float32, reflect.Float64:
+		switch typed := property.(type) {
+		case float64:
+			target.SetFloat(typed)
+		case int64:
+			target.SetFloat(float64(typed))
+		case string:
+			parsed, err := strconv.ParseFloat(typed, 64)
+			if err != nil {
+				return err
+			}
+			target.SetFloat(parsed)
+		default:
+			return fmt.Errorf("expected a number but was %T", property)
+		}
+	case reflect.Slice, reflect.Map, reflect.Pointer, reflect.Array, reflect.Struct:
+		text, ok := property.(string)
+		if !ok {
+			return fmt.Errorf("expected a json string but was %T", property)
+		}
+		if text == "" {
+			return nil
+		}
+		if err := json.Unmarshal([]byte(text), target.Addr().Interface()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", target.Type())
+	}
+	return nil
+}
+
+func decodeInt(property interface{}) (int64, error) {
+	switch typed := property.(type) {
+	case int64:
+		return typed, nil
+	case int:
+		return int64(typed), nil
+	case float64:
+		if typed != math.Trunc(typed) || typed > math.MaxInt64 || typed < math.MinInt64 {
+			return 0, fmt.Errorf("%v isn't an integer", typed)
+		}
+		return int64(typed), nil
+	case string:
+		return strconv.ParseInt(typed, 10, 64)
+	}
+	return 0, fmt.Errorf("expected an integer but was %T", property)
+}
+
+// decodeTime accepts RFC3339 strings, empty for the zero time, which is how timestamps were kept before the codec
+func decodeTime(property interface{}) (time.Time, error) {
+	switch typed := property.(type) {
+	case time.Time:
+		return typed, nil
+	case string:
+		if typed == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339Nano, typed)
+	}
+	return time.Time{}, fmt.Errorf("expected a timestamp but was %T", property)
+}
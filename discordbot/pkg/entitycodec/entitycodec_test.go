@@ -0,0 +1,130 @@
+package entitycodec
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testPlacement struct {
+	Vmss string `json:"vmss"`
+	Sku  string `json:"sku"`
+}
+
+type testEntity struct {
+	Ip        string `table:"ip,required"`
+	Players   int
+	Running   bool          `table:"running"`
+	Uptime    time.Duration `table:"uptime"`
+	Since     time.Time     `table:"since"`
+	StoppedAt time.Time     `table:"stopped_at"`
+	Names     []string      `table:"names"`
+	Placement testPlacement `table:"placement"`
+	Previous  *testEntity   `table:"previous"`
+	Secret    string        `table:"-"`
+	internal  string
+}
+
+func TestEncodeDecode(t *testing.T) {
+	since := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	entity := testEntity{
+		Ip:        "192.168.0.1",
+		Players:   2,
+		Running:   true,
+		Uptime:    90 * time.Minute,
+		Since:     since,
+		Names:     []string{"player1", "player2"},
+		Placement: testPlacement{Vmss: "valheim-server-vmss", Sku: "Standard_D2s_v5"},
+		Secret:    "secret",
+		internal:  "internal",
+	}
+	properties, err := Encode(entity)
+	if err != nil {
+		t.Fatalf("error encoding entity: %v", err)
+	}
+	expected := map[string]interface{}{
+		"ip":        "192.168.0.1",
+		"players":   int64(2),
+		"running":   true,
+		"uptime":    int64(90 * time.Minute),
+		"since":     since,
+		"names":     `["player1","player2"]`,
+		"placement": `{"vmss":"valheim-server-vmss","sku":"Standard_D2s_v5"}`,
+		"previous":  "",
+	}
+	if !reflect.DeepEqual(properties, expected) {
+		t.Errorf("expected properties to be %v but were %v", expected, properties)
+	}
+	if required := Required(&entity); !reflect.DeepEqual(required, []string{"ip"}) {
+		t.Errorf("expected required columns to be [ip] but were %v", required)
+	}
+
+	// the properties as they come back from a json backend
+	propertiesBytes, err := json.Marshal(properties)
+	if err != nil {
+		t.Fatalf("error marshalling properties: %v", err)
+	}
+	stored := map[string]interface{}{}
+	if err := json.Unmarshal(propertiesBytes, &stored); err != nil {
+		t.Fatalf("error unmarshalling properties: %v", err)
+	}
+	for name, properties := range map[string]map[string]interface{}{"encoded": properties, "json": stored} {
+		decoded := testEntity{Secret: "kept", internal: "kept"}
+		if err := Decode(properties, &decoded); err != nil {
+			t.Fatalf("%s - error decoding entity: %v", name, err)
+		}
+		entity.Secret, entity.internal = "kept", "kept"
+		if !reflect.DeepEqual(decoded, entity) {
+			t.Errorf("%s - expected decoded entity to be %+v but was %+v", name, entity, decoded)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type testcase struct {
+		Name        string
+		Properties  map[string]interface{}
+		Expected    testEntity
+		ExpectedErr bool
+	}
+	testcases := []testcase{
+		{
+			Name:       "azure typed values",
+			Properties: map[string]interface{}{"ip": "", "players": "3", "since": "2024-10-05T22:00:00.0000000Z", "since@odata.type": "Edm.DateTime"},
+			Expected:   testEntity{Players: 3, Since: time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)},
+		},
+		{
+			Name:       "empty columns of earlier entities",
+			Properties: map[string]interface{}{"ip": "", "since": "", "names": "", "placement": ""},
+			Expected:   testEntity{},
+		},
+		{Name: "number as string", Properties: map[string]interface{}{"ip": 1.0}, ExpectedErr: true},
+		{Name: "fractional int", Properties: map[string]interface{}{"players": 1.5}, ExpectedErr: true},
+		{Name: "invalid bool", Properties: map[string]interface{}{"running": "maybe"}, ExpectedErr: true},
+		{Name: "invalid timestamp", Properties: map[string]interface{}{"since": "yesterday"}, ExpectedErr: true},
+		{Name: "invalid json", Properties: map[string]interface{}{"names": "player1,player2"}, ExpectedErr: true},
+	}
+	for _, tc := range testcases {
+		var decoded testEntity
+		err := Decode(tc.Properties, &decoded)
+		if (err != nil) != tc.ExpectedErr {
+			t.Errorf("%s - expected error %v but got %v", tc.Name, tc.ExpectedErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(decoded, tc.Expected) {
+			t.Errorf("%s - expected decoded entity to be %+v but was %+v", tc.Name, tc.Expected, decoded)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	if _, err := Encode(struct{ Done chan bool }{}); err == nil {
+		t.Errorf("expected an error encoding a channel")
+	}
+	if _, err := Encode("not a struct"); err == nil {
+		t.Errorf("expected an error encoding a string")
+	}
+	if err := Decode(map[string]interface{}{}, testEntity{}); err == nil {
+		t.Errorf("expected an error decoding into a struct value")
+	}
+}
@@ -0,0 +1,210 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"godin/pkg/utils"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Version of the envelope format, bump it on breaking changes so consumers can tell formats apart
+const Version = 1
+
+type Type string
+
+const (
+	Start        Type = "start"
+	Stop         Type = "stop"
+	PublicIp     Type = "public_ip"
+	Listening    Type = "listening"
+	PlayerJoined Type = "player_joined"
+	PlayerLeft   Type = "player_left"
+	Character    Type = "character"
+	Evicted      Type = "evicted"
+	Heartbeat    Type = "heartbeat"
+	Unknown      Type = "unknown"
+)
+
+// Event sources
+const (
+	SourceInteractions = "interactions"
+	SourceVm           = "vm"
+	// SourceScheduler marks events the timer-triggered functions enqueue
+	SourceScheduler = "scheduler"
+	// SourceLegacy marks plain string messages enqueued before the envelope existed
+	SourceLegacy = "legacy"
+)
+
+// Requester is the discord user that triggered the event, if any
+type Requester struct {
+	UserId           string `json:"user_id"`
+	Username         string `json:"username,omitempty"`
+	InteractionToken string `json:"interaction_token,omitempty"`
+}
+
+// Envelope is the message format of the events queue, shared by every producer and the reaction handler
+type Envelope struct {
+	Version       int             `json:"version"`
+	Type          Type            `json:"type"`
+	Source        string          `json:"source"`
+	Timestamp     time.Time       `json:"timestamp"`
+	CorrelationId string          `json:"correlation_id"`
+	Requester     *Requester      `json:"requester,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+}
+
+// PublicIpPayload is the payload of PublicIp events
+type PublicIpPayload struct {
+	Ip string `json:"ip"`
+}
+
+// PlayerPayload is the payload of PlayerJoined and PlayerLeft events
+type PlayerPayload struct {
+	SteamId string `json:"steam_id"`
+	Line    string `json:"line,omitempty"`
+}
+
+// CharacterPayload is the payload of Character events, sent when a player spawns with a character
+type CharacterPayload struct {
+	Name string `json:"name"`
+	Line string `json:"line,omitempty"`
+}
+
+// EvictedPayload is the payload of Evicted events, sent when azure is about to preempt the spot vm
+type EvictedPayload struct {
+	EventId   string `json:"event_id"`
+	NotBefore string `json:"not_before,omitempty"`
+}
+
+// LogPayload is the payload of events read from the game server logs that carry nothing else
+type LogPayload struct {
+	Line string `json:"line"`
+}
+
+// New creates an envelope with a fresh correlation id, payload can be nil
+func New(eventType Type, source string, requester *Requester, payload interface{}) (Envelope, error) {
+	envelope := Envelope{
+		Version:       Version,
+		Type:          eventType,
+		Source:        source,
+		Timestamp:     time.Now().UTC(),
+		CorrelationId: uuid.NewString(),
+		Requester:     requester,
+	}
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return Envelope{}, fmt.Errorf("error marshalling %s payload: %v", eventType, err)
+		}
+		envelope.Payload = payloadBytes
+	}
+	return envelope, nil
+}
+
+func Encode(envelope Envelope) (string, error) {
+	envelopeBytes, err := json.Marshal(envelope)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling envelope: %v", err)
+	}
+	return string(envelopeBytes), nil
+}
+
+// legacyAction is the json the interactions API enqueued before the envelope existed
+type legacyAction struct {
+	Action           string `json:"action"`
+	InteractionToken string `json:"interaction_token"`
+}
+
+// Decode reads an envelope from a queue message. Messages that aren't envelopes are still accepted
+// and classified the way the reaction handler used to, so nothing in flight is lost while producers migrate
+func Decode(message string) (Envelope, error) {
+	message = strings.TrimSpace(message)
+	if strings.HasPrefix(message, "{") {
+		var envelope Envelope
+		if err := json.Unmarshal([]byte(message), &envelope); err == nil && envelope.Version != 0 {
+			if envelope.Version > Version {
+				return Envelope{}, fmt.Errorf("unsupported envelope version %d, latest known is %d", envelope.Version, Version)
+			}
+			if envelope.Type == "" {
+				return Envelope{}, fmt.Errorf("envelope %s has no type", envelope.CorrelationId)
+			}
+			return envelope, nil
+		}
+		var action legacyAction
+		if err := json.Unmarshal([]byte(message), &action); err == nil && action.Action != "" {
+			envelope, err := fromLegacy(action.Action)
+			if err != nil {
+				return Envelope{}, err
+			}
+			if action.InteractionToken != "" {
+				envelope.Requester = &Requester{InteractionToken: action.InteractionToken}
+			}
+			return envelope, nil
+		}
+	}
+	return fromLegacy(message)
+}
+
+func fromLegacy(message string) (Envelope, error) {
+	eventType, payload := classify(message)
+	return New(eventType, SourceLegacy, nil, payload)
+}
+
+// FromLogLine builds the event a game server log line stands for, like the vm script does
+func FromLogLine(line string) (Envelope, error) {
+	eventType, payload := classify(line)
+	return New(eventType, SourceVm, nil, payload)
+}
+
+// characterRegexp matches spawns, dead characters are logged with the 0:0 ZDOID and don't match
+var characterRegexp = regexp.MustCompile(`Got character ZDOID from (.+) : -?[1-9]\d*:\d+`)
+
+// classify tells the event type of a plain string message and builds its payload
+func classify(message string) (eventType Type, payload interface{}) {
+	if message == "start" || message == "stop" {
+		eventType = Type(message)
+	} else if net.ParseIP(message) != nil {
+		eventType = PublicIp
+		payload = PublicIpPayload{Ip: message}
+	} else if strings.Contains(message, "listening") {
+		eventType = Listening
+		payload = LogPayload{Line: message}
+	} else if match := characterRegexp.FindStringSubmatch(message); match != nil {
+		eventType = Character
+		payload = CharacterPayload{Name: match[1], Line: message}
+	} else if steamid, err := utils.ExtractSteamId(message); err == nil {
+		eventType = PlayerJoined
+		if strings.Contains(message, "Closing socket") {
+			eventType = PlayerLeft
+		}
+		payload = PlayerPayload{SteamId: steamid, Line: message}
+	} else {
+		eventType = Unknown
+		payload = LogPayload{Line: message}
+	}
+	return eventType, payload
+}
+
+// DecodePayload unmarshals the envelope payload into out
+func (e Envelope) DecodePayload(out interface{}) error {
+	if len(e.Payload) == 0 {
+		return fmt.Errorf("%s event %s has no payload", e.Type, e.CorrelationId)
+	}
+	if err := json.Unmarshal(e.Payload, out); err != nil {
+		return fmt.Errorf("error unmarshalling %s payload: %v", e.Type, err)
+	}
+	return nil
+}
+
+// InteractionToken returns the token of the interaction that requested the event, if any
+func (e Envelope) InteractionToken() string {
+	if e.Requester == nil {
+		return ""
+	}
+	return e.Requester.InteractionToken
+}
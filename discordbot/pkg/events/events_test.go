@@ -0,0 +1,88 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestDecodeLegacy(t *testing.T) {
+	type testcase struct {
+		Message          string
+		ExpectedType     Type
+		ExpectedToken    string
+		ExpectedSteamId  string
+		ExpectedIp       string
+		ExpectedLogLines string
+	}
+	testcases := []testcase{
+		{Message: "start", ExpectedType: Start},
+		{Message: "stop", ExpectedType: Stop},
+		{Message: `{"action":"start","interaction_token":"token1"}`, ExpectedType: Start, ExpectedToken: "token1"},
+		{Message: "4.201.60.16", ExpectedType: PublicIp, ExpectedIp: "4.201.60.16"},
+		{Message: "Server is now listening", ExpectedType: Listening},
+		{Message: "Got connection SteamID 76561198073103840", ExpectedType: PlayerJoined, ExpectedSteamId: "76561198073103840"},
+		{Message: "Closing socket 76561198073103840", ExpectedType: PlayerLeft, ExpectedSteamId: "76561198073103840"},
+		{Message: "Got character ZDOID from Ragnar : -1043766231:1", ExpectedType: Character},
+		{Message: "Got character ZDOID from Ragnar : 0:0", ExpectedType: Unknown},
+		{Message: "something else", ExpectedType: Unknown},
+	}
+	for _, tc := range testcases {
+		event, err := Decode(tc.Message)
+		if err != nil {
+			t.Errorf("%s - error decoding: %v", tc.Message, err)
+			continue
+		}
+		if event.Type != tc.ExpectedType || event.Source != SourceLegacy || event.Version != Version {
+			t.Errorf("%s - expected a legacy %s event but got %s from %s (v%d)", tc.Message, tc.ExpectedType, event.Type, event.Source, event.Version)
+		}
+		if event.InteractionToken() != tc.ExpectedToken {
+			t.Errorf("%s - expected interaction token to be %q but was %q", tc.Message, tc.ExpectedToken, event.InteractionToken())
+		}
+		if tc.ExpectedSteamId != "" {
+			var payload PlayerPayload
+			if err := event.DecodePayload(&payload); err != nil || payload.SteamId != tc.ExpectedSteamId {
+				t.Errorf("%s - expected steam id %s but got %s (%v)", tc.Message, tc.ExpectedSteamId, payload.SteamId, err)
+			}
+		}
+		if tc.ExpectedIp != "" {
+			var payload PublicIpPayload
+			if err := event.DecodePayload(&payload); err != nil || payload.Ip != tc.ExpectedIp {
+				t.Errorf("%s - expected ip %s but got %s (%v)", tc.Message, tc.ExpectedIp, payload.Ip, err)
+			}
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	requester := &Requester{UserId: "100", Username: "viking", InteractionToken: "token1"}
+	event, err := New(PlayerJoined, SourceVm, requester, PlayerPayload{SteamId: "76561198073103840"})
+	if err != nil {
+		t.Fatalf("error creating event: %v", err)
+	}
+	message, err := Encode(event)
+	if err != nil {
+		t.Fatalf("error encoding event: %v", err)
+	}
+	decoded, err := Decode(message)
+	if err != nil {
+		t.Fatalf("error decoding event: %v", err)
+	}
+	if decoded.Type != PlayerJoined || decoded.Source != SourceVm || decoded.CorrelationId != event.CorrelationId || !decoded.Timestamp.Equal(event.Timestamp) {
+		t.Errorf("expected decoded event to match %+v but was %+v", event, decoded)
+	}
+	if decoded.Requester == nil || *decoded.Requester != *requester {
+		t.Errorf("expected requester to be %+v but was %+v", requester, decoded.Requester)
+	}
+	var payload PlayerPayload
+	if err := decoded.DecodePayload(&payload); err != nil || payload.SteamId != "76561198073103840" {
+		t.Errorf("expected steam id payload but got %+v (%v)", payload, err)
+	}
+}
+
+func TestDecodeUnsupportedVersion(t *testing.T) {
+	if _, err := Decode(`{"version":99,"type":"start"}`); err == nil {
+		t.Errorf("expected envelope from a newer version to be rejected")
+	}
+	if _, err := Decode(`{"version":1}`); err == nil {
+		t.Errorf("expected envelope without type to be rejected")
+	}
+}
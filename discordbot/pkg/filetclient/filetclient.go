@@ -0,0 +1,223 @@
+package filetclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"godin/pkg/aztclient"
+	"godin/pkg/entitycodec"
+	"godin/pkg/godinerrors"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/utils"
+)
+
+// every client of the process goes through the same lock, they usually share the file
+var fileLock sync.Mutex
+
+// entity is a table row, ETag is bumped on every write like azure tables do
+type entity struct {
+	ETag       int                    `json:"etag"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// TableClient keeps the entities of a table in a json file, keyed by "partitionKey/rowKey", for local runs
+type TableClient struct {
+	path         string
+	partitionKey string
+	rowKey       string
+	etag         int
+}
+
+// NewTableClient creates a TableClient for one entity of the table kept in path
+func NewTableClient(path, partitionKey, rowKey string) (aztclient.TableClientInterface, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating table directory: %v", err)
+	}
+	return &TableClient{
+		path:         path,
+		partitionKey: partitionKey,
+		rowKey:       rowKey,
+	}, nil
+}
+
+func (tc *TableClient) key() string {
+	return tc.partitionKey + "/" + tc.rowKey
+}
+
+func load(path string) (map[string]entity, error) {
+	contentBytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]entity{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading table file: %v", err)
+	}
+	entities := map[string]entity{}
+	if err := json.Unmarshal(contentBytes, &entities); err != nil {
+		return nil, fmt.Errorf("error unmarshalling table file %s: %v", path, err)
+	}
+	return entities, nil
+}
+
+func save(path string, entities map[string]entity) error {
+	contentBytes, err := json.MarshalIndent(entities, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling table: %v", err)
+	}
+	tmp := path + ".tmp" + strconv.Itoa(os.Getpid())
+	if err := os.WriteFile(tmp, contentBytes, 0644); err != nil {
+		return fmt.Errorf("error writing table file: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Read returns the entity properties, creating the entity when it doesn't exist yet
+func (tc *TableClient) Read(columns ...string) (map[string]interface{}, error) {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	entities, err := load(tc.path)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := entities[tc.key()]
+	if !ok {
+		e = entity{ETag: 1, Properties: map[string]interface{}{}}
+		entities[tc.key()] = e
+		if err := save(tc.path, entities); err != nil {
+			return nil, err
+		}
+	}
+	tc.etag = e.ETag
+	if err := utils.ValidateColumns(e.Properties, columns); err != nil {
+		return nil, godinerrors.ReadError{
+			Code:    godinerrors.MissingColumnError,
+			Message: err.Error(),
+		}
+	}
+	return e.Properties, nil
+}
+
+// Write replaces the entity properties, failing if it was written since it was read
+func (tc *TableClient) Write(state statestorageinterface.StateAttributes) error {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	entities, err := load(tc.path)
+	if err != nil {
+		return err
+	}
+	if current := entities[tc.key()].ETag; current != tc.etag {
+		return godinerrors.WriteError{
+			Code:    godinerrors.ConflictError,
+			Message: fmt.Sprintf("error writing entity %s: etag %d doesn't match %d", tc.key(), tc.etag, current),
+		}
+	}
+	properties, err := entitycodec.Encode(state)
+	if err != nil {
+		return err
+	}
+	stateBytes, err := json.Marshal(properties)
+	if err != nil {
+		return fmt.Errorf("error marshalling state: %v", err)
+	}
+	tc.etag++
+	entities[tc.key()] = entity{ETag: tc.etag, Properties: properties}
+	log.Printf("Updating entity %s with: %s", tc.key(), string(stateBytes))
+	return save(tc.path, entities)
+}
+
+// PartitionClient keeps the rows of a partition in the json file of a table
+type PartitionClient struct {
+	path         string
+	partitionKey string
+}
+
+// NewPartitionClient creates a PartitionClient for partitionKey in the table kept in path
+func NewPartitionClient(path, partitionKey string) (aztclient.PartitionClientInterface, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating table directory: %v", err)
+	}
+	return &PartitionClient{
+		path:         path,
+		partitionKey: partitionKey,
+	}, nil
+}
+
+func (pc *PartitionClient) key(rowKey string) string {
+	return pc.partitionKey + "/" + rowKey
+}
+
+func (pc *PartitionClient) Get(key string) (aztclient.Row, error) {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	entities, err := load(pc.path)
+	if err != nil {
+		return aztclient.Row{}, err
+	}
+	e, ok := entities[pc.key(key)]
+	if !ok {
+		return aztclient.Row{Key: key}, nil
+	}
+	return aztclient.Row{Key: key, ETag: strconv.Itoa(e.ETag), Properties: e.Properties}, nil
+}
+
+func (pc *PartitionClient) Put(row aztclient.Row) error {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	entities, err := load(pc.path)
+	if err != nil {
+		return err
+	}
+	e, ok := entities[pc.key(row.Key)]
+	if (row.ETag == "" && ok) || (row.ETag != "" && (!ok || row.ETag != strconv.Itoa(e.ETag))) {
+		return godinerrors.WriteError{
+			Code:    godinerrors.ConflictError,
+			Message: fmt.Sprintf("error writing row %s: it changed since etag %q was read", pc.key(row.Key), row.ETag),
+		}
+	}
+	entities[pc.key(row.Key)] = entity{ETag: e.ETag + 1, Properties: row.Properties}
+	return save(pc.path, entities)
+}
+
+func (pc *PartitionClient) List(after string, limit int) ([]aztclient.Row, error) {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	entities, err := load(pc.path)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for key := range entities {
+		if rowKey, found := strings.CutPrefix(key, pc.partitionKey+"/"); found && rowKey > after {
+			keys = append(keys, rowKey)
+		}
+	}
+	sort.Strings(keys)
+	rows := []aztclient.Row{}
+	for _, key := range keys[:min(limit, len(keys))] {
+		e := entities[pc.key(key)]
+		rows = append(rows, aztclient.Row{Key: key, ETag: strconv.Itoa(e.ETag), Properties: e.Properties})
+	}
+	return rows, nil
+}
+
+func (pc *PartitionClient) Delete(key string) error {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	entities, err := load(pc.path)
+	if err != nil {
+		return err
+	}
+	if _, ok := entities[pc.key(key)]; !ok {
+		return nil
+	}
+	delete(entities, pc.key(key))
+	return save(pc.path, entities)
+}
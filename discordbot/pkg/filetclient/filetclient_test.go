@@ -0,0 +1,57 @@
+package filetclient
+
+import (
+	"godin/pkg/aztclient"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/tclienttest"
+	"godin/pkg/utils"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	client, _ := NewTableClient(path, "valheim-vmss", "world")
+
+	if _, err := client.Read("ip"); !utils.IsMissingColumnError(err) {
+		t.Errorf("expected reading a new entity to fail with a missing column error but was %v", err)
+	}
+	if err := client.Write(statestorageinterface.StateAttributes{Ip: "127.0.0.1", Status: "started"}); err != nil {
+		t.Fatalf("error writing entity: %v", err)
+	}
+
+	other, _ := NewTableClient(path, "valheim-vmss", "world")
+	state, err := other.Read("ip", "status")
+	if err != nil {
+		t.Fatalf("error reading entity: %v", err)
+	}
+	if state["ip"] != "127.0.0.1" || state["status"] != "started" {
+		t.Errorf("expected entity written by the first client but was %v", state)
+	}
+
+	if err := client.Write(statestorageinterface.StateAttributes{Status: "stopped"}); err != nil {
+		t.Fatalf("error writing entity again: %v", err)
+	}
+	if err := other.Write(statestorageinterface.StateAttributes{Status: "listening"}); !utils.IsConflictError(err) {
+		t.Errorf("expected writing a stale entity to be a conflict but was %v", err)
+	}
+
+	permissions, _ := NewTableClient(path, "valheim-permissions", "world")
+	if _, err := permissions.Read("policy"); !utils.IsMissingColumnError(err) {
+		t.Errorf("expected entities of other partitions to be kept apart but was %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	tclienttest.Run(t, func(partitionKey, rowKey string) (aztclient.TableClientInterface, error) {
+		return NewTableClient(path, partitionKey, rowKey)
+	}, "")
+}
+
+func TestPartitionConformance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	tclienttest.RunPartition(t, func(partitionKey string) (aztclient.PartitionClientInterface, error) {
+		return NewPartitionClient(path, partitionKey)
+	}, "")
+}
@@ -1,9 +1,17 @@
 package godinerrors
 
+import "fmt"
+
 type ErrorCode string
 
 const (
 	MissingColumnError ErrorCode = "missingColumnError"
+	// ConflictError is a write of an entity that changed since it was read
+	ConflictError ErrorCode = "conflictError"
+	// IllegalTransitionError is a status change the server lifecycle doesn't allow
+	IllegalTransitionError ErrorCode = "illegalTransitionError"
+	// DuplicateTransitionError is a second event asking for the status the server is already in
+	DuplicateTransitionError ErrorCode = "duplicateTransitionError"
 )
 
 type ReadError struct {
@@ -14,3 +22,25 @@ type ReadError struct {
 func (re ReadError) Error() string {
 	return re.Message
 }
+
+type WriteError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (we WriteError) Error() string {
+	return we.Message
+}
+
+type TransitionError struct {
+	Code ErrorCode
+	From string
+	To   string
+}
+
+func (te TransitionError) Error() string {
+	if te.Code == DuplicateTransitionError {
+		return fmt.Sprintf("server is already %s", te.To)
+	}
+	return fmt.Sprintf("illegal transition from %s to %s", te.From, te.To)
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"fmt"
+	"godin/pkg/aztclient"
+	"godin/pkg/disclient"
+	"godin/pkg/history"
+	"godin/pkg/permissions"
+	"godin/pkg/playerstats"
+	"godin/pkg/sessions"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/steamapi"
+	"godin/pkg/valheimstate"
+	"godin/pkg/vmssclient"
+	"log"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Partition keys of the entities kept in the state table, their row key is the world name
+const (
+	statePartitionKey       = "valheim-vmss"
+	permissionsPartitionKey = "valheim-permissions"
+)
+
+// Partition keys of the records kept next to the state, see history.Store, sessions.Store and playerstats.Store
+const (
+	historyPartitionKey  = "valheim-history"
+	sessionsPartitionKey = "valheim-sessions"
+	playersPartitionKey  = "valheim-players"
+)
+
+// Backends creates the clients the handlers talk to, AzureBackends in the function app
+// and local fakes when running with --local
+type Backends struct {
+	// TableClient opens the entity of partitionKey for the configured world
+	TableClient func(partitionKey string) (aztclient.TableClientInterface, error)
+	// Partition opens the rows of partitionKey for the configured world, nil keeps no records
+	Partition func(partitionKey string) (aztclient.PartitionClientInterface, error)
+	Discord   func() (disclient.DiscordClientInterface, error)
+	// Vmss gets the last known ip of the game server, which ScaleDown runs its commands on
+	Vmss  func(ip string) (vmssclient.VmssClientInterface, error)
+	Steam func() steamapi.ClientInterface
+	// HistoryRetention is how long state changes are kept, 0 keeps them forever
+	HistoryRetention time.Duration
+	// DefaultPolicy guards the commands of a world without a stored policy
+	DefaultPolicy permissions.Policy
+}
+
+// AzureBackends creates the clients from the function app settings, the state table is in the backend
+// of StorageConfigFromEnv, azure tables unless STATE_STORAGE says otherwise
+func AzureBackends() (Backends, error) {
+	storage, err := StorageConfigFromEnv().Open()
+	if err != nil {
+		return Backends{}, err
+	}
+	historyRetention, err := history.RetentionFromEnv()
+	if err != nil {
+		return Backends{}, err
+	}
+	return Backends{
+		TableClient:      storage.TableClient,
+		Partition:        storage.Partition,
+		HistoryRetention: historyRetention,
+		Discord: func() (disclient.DiscordClientInterface, error) {
+			return disclient.NewDiscordClient(os.Getenv("DISCORD_BOT_TOKEN"), os.Getenv("DISCORD_CHANNEL_ID"), os.Getenv("DISCORD_ADMIN_CHANNEL_ID"), os.Getenv("DISCORD_APPLICATION_ID"))
+		},
+		Vmss: func(ip string) (vmssclient.VmssClientInterface, error) {
+			fallbacks, err := vmssclient.ParsePlacements(os.Getenv("VMSS_FALLBACKS"))
+			if err != nil {
+				return nil, fmt.Errorf("error reading VMSS_FALLBACKS: %v", err)
+			}
+			return vmssclient.NewVmssClient(os.Getenv("VMSS_RESOURCE_GROUP_NAME"), os.Getenv("VMSS_NAME"), os.Getenv("AZURE_SUBSCRIPTION_ID"), ip, fallbacks)
+		},
+		Steam: func() steamapi.ClientInterface {
+			return steamapi.NewClient(os.Getenv("STEAM_API_KEY"))
+		},
+	}, nil
+}
+
+func (b Backends) loadState() (statestorageinterface.StateInterface, error) {
+	storageclient, err := b.TableClient(statePartitionKey)
+	if err != nil {
+		return nil, fmt.Errorf("error creating storageclient: %v", err)
+	}
+	state := valheimstate.NewValheimState(storageclient)
+	if err := state.Load(); err != nil {
+		return nil, fmt.Errorf("error loading state: %v", err)
+	}
+	return state, nil
+}
+
+// loadPolicy reads the permissions policy, kept in the state table under its own partition
+func (b Backends) loadPolicy() (permissions.Policy, error) {
+	storageclient, err := b.TableClient(permissionsPartitionKey)
+	if err != nil {
+		return permissions.Policy{}, fmt.Errorf("error creating storageclient: %v", err)
+	}
+	policy, err := permissions.NewTablePolicyStore(storageclient).Load()
+	if err != nil {
+		return permissions.Policy{}, err
+	}
+	if policy.Commands == nil && policy.Default == nil {
+		return b.DefaultPolicy, nil
+	}
+	return policy, nil
+}
+
+// newActionHandler loads the state and creates the clients an event is handled with
+func (b Backends) newActionHandler(registry *eventRegistry) (*actionHandler, error) {
+	state, err := b.loadState()
+	if err != nil {
+		return nil, err
+	}
+	vmssclient, err := b.Vmss(state.GetIp())
+	if err != nil {
+		return nil, fmt.Errorf("error creating vmssclient: %v", err)
+	}
+	discordclient, err := b.Discord()
+	if err != nil {
+		return nil, fmt.Errorf("error creating discordclient: %v", err)
+	}
+	ah := newActionHandler(registry, discordclient, vmssclient, b.Steam(), state)
+	ah.records = b.records()
+	return ah, nil
+}
+
+// history opens the state change history, nil when there is no partition to keep it in
+func (b Backends) history() *history.Store {
+	if b.Partition == nil {
+		return nil
+	}
+	rows, err := b.Partition(historyPartitionKey)
+	if err != nil {
+		log.Printf("Error opening history, state changes won't be recorded: %v", err)
+		return nil
+	}
+	return history.NewStore(rows, b.HistoryRetention)
+}
+
+// sessions opens the ended sessions of the server, nil when there is no partition to keep them in
+func (b Backends) sessions() *sessions.Store {
+	if b.Partition == nil {
+		return nil
+	}
+	rows, err := b.Partition(sessionsPartitionKey)
+	if err != nil {
+		log.Printf("Error opening sessions, ended sessions won't be recorded: %v", err)
+		return nil
+	}
+	return sessions.NewStore(rows)
+}
+
+// playerStats opens the stats of the players, nil when there is no partition to keep them in
+func (b Backends) playerStats() *playerstats.Store {
+	if b.Partition == nil {
+		return nil
+	}
+	rows, err := b.Partition(playersPartitionKey)
+	if err != nil {
+		log.Printf("Error opening player stats, joins and leaves won't be recorded: %v", err)
+		return nil
+	}
+	return playerstats.NewStore(rows)
+}
+
+// records are where saved state changes are kept, nil stores keep nothing
+type records struct {
+	history  *history.Store
+	sessions *sessions.Store
+	players  *playerstats.Store
+}
+
+func (b Backends) records() records {
+	return records{history: b.history(), sessions: b.sessions(), players: b.playerStats()}
+}
+
+// recorder records the state changes made for cause, see valheimstate.UpdateRecorded
+func (b Backends) recorder(cause history.Cause) func(before, after statestorageinterface.StateAttributes) error {
+	records := b.records()
+	return func(before, after statestorageinterface.StateAttributes) error {
+		return records.record(cause, before, after)
+	}
+}
+
+// record appends a state change to the history, the session it ended, if any, and the stats of the players
+// that joined or left, a stop or an eviction clearing the players ends their play sessions too. It runs before
+// the change is saved, so an error fails the event and its retry makes and records the change again. Each
+// record is idempotent, recording the same change again leaves it recorded once
+func (r records) record(cause history.Cause, before, after statestorageinterface.StateAttributes) error {
+	if r.history != nil {
+		if err := r.history.Record(cause, before, after, time.Now()); err != nil {
+			return fmt.Errorf("error recording %s state change: %v", cause.EventType, err)
+		}
+	}
+	if session, ended := valheimstate.SessionEnded(before, after); ended && r.sessions != nil {
+		if err := r.sessions.Record(session); err != nil {
+			return fmt.Errorf("error recording session: %v", err)
+		}
+		log.Printf("Recorded session %s, %s up", session.Id, session.Uptime(time.Now()).Truncate(time.Second))
+	}
+	if r.players != nil && !reflect.DeepEqual(before.Players, after.Players) {
+		// players leave when the event that removed them happened, not when it was handled
+		at := cause.At
+		if at.IsZero() {
+			at = time.Now()
+		}
+		if err := r.players.Record(before, after, at); err != nil {
+			return fmt.Errorf("error recording player stats: %v", err)
+		}
+	}
+	return nil
+}
+
+// recordFailure keeps the error in state, from a fresh read since the failed attempt may have left the
+// state half changed, so the poison handler can report it if the event keeps failing
+func (b Backends) recordFailure(cause history.Cause, handlerErr error) {
+	state, err := b.loadState()
+	if err != nil {
+		log.Printf("error recording failure: %v", err)
+		return
+	}
+	lastError := fmt.Sprintf("%s: %v", time.Now().UTC().Format(time.RFC3339), handlerErr)
+	if err := valheimstate.UpdateRecorded(state, func(state statestorageinterface.StateInterface) error {
+		state.SetLastError(lastError)
+		return nil
+	}, b.recorder(cause)); err != nil {
+		log.Printf("error recording failure: %v", err)
+	}
+}
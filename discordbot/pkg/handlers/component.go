@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"fmt"
+	"godin/pkg/discinteraction"
+	"godin/pkg/events"
+	"godin/pkg/statestorageinterface"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// confirmationTTL is how long the confirm button of a destructive command can be clicked
+const confirmationTTL = 2 * time.Minute
+
+// stopConfirmation is the context carried in the custom_id of the stop confirmation buttons.
+// It ties the click to the user that ran /stop and to the state the server was in when they did,
+// so a click after the server changed state or after the ttl expired is rejected
+type stopConfirmation struct {
+	Confirm     bool
+	UserId      string
+	StatusSince int64
+	IssuedAt    int64
+}
+
+func (sc stopConfirmation) customId() string {
+	action := "cancel"
+	if sc.Confirm {
+		action = "confirm"
+	}
+	return fmt.Sprintf("stop:%s:%s:%d:%d", action, sc.UserId, sc.StatusSince, sc.IssuedAt)
+}
+
+func parseStopConfirmation(customId string) (stopConfirmation, error) {
+	parts := strings.Split(customId, ":")
+	if len(parts) != 5 || parts[0] != "stop" || (parts[1] != "confirm" && parts[1] != "cancel") {
+		return stopConfirmation{}, fmt.Errorf("unknown component custom_id: %s", customId)
+	}
+	statusSince, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return stopConfirmation{}, fmt.Errorf("error parsing status since from custom_id %s: %v", customId, err)
+	}
+	issuedAt, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		return stopConfirmation{}, fmt.Errorf("error parsing issued at from custom_id %s: %v", customId, err)
+	}
+	return stopConfirmation{
+		Confirm:     parts[1] == "confirm",
+		UserId:      parts[2],
+		StatusSince: statusSince,
+		IssuedAt:    issuedAt,
+	}, nil
+}
+
+// validate tells why a confirm click can't go through, an empty reason means it can
+func (sc stopConfirmation) validate(clickerId string, state statestorageinterface.StateInterface, now time.Time) string {
+	if clickerId != sc.UserId {
+		return fmt.Sprintf("Only <@%s> can answer this confirmation", sc.UserId)
+	}
+	if now.Sub(time.Unix(sc.IssuedAt, 0)) > confirmationTTL {
+		return "This confirmation expired, run `/stop` again"
+	}
+	if state.GetStatusSince().Unix() != sc.StatusSince {
+		return fmt.Sprintf("The server changed to `%s` since this confirmation was issued, run `/stop` again", state.GetStatus())
+	}
+	return ""
+}
+
+// onlinePlayers returns the names of the online players, with their character when it's known
+func onlinePlayers(state statestorageinterface.StateInterface) []string {
+	players := []string{}
+	for _, p := range state.GetPlayers() {
+		if p.Character != "" && p.Character != p.Name {
+			players = append(players, fmt.Sprintf("%s (%s)", p.Name, p.Character))
+		} else {
+			players = append(players, p.Name)
+		}
+	}
+	return players
+}
+
+// responseStopConfirmation asks the invoker to confirm stopping the server while players are online
+func responseStopConfirmation(state statestorageinterface.StateInterface, userId string, players []string, now time.Time) map[string]interface{} {
+	confirm := stopConfirmation{
+		Confirm:     true,
+		UserId:      userId,
+		StatusSince: state.GetStatusSince().Unix(),
+		IssuedAt:    now.Unix(),
+	}
+	cancel := confirm
+	cancel.Confirm = false
+	return map[string]interface{}{
+		"type": ResponseChannelMsg,
+		"data": map[string]interface{}{
+			"content": fmt.Sprintf("%d players are online: %s\nStop the Valheim server anyway?", len(players), strings.Join(players, ", ")),
+			"components": []map[string]interface{}{
+				{
+					"type": discinteraction.ComponentActionRow,
+					"components": []map[string]interface{}{
+						{
+							"type":      discinteraction.ComponentButton,
+							"style":     discinteraction.ButtonDanger,
+							"label":     "Stop server",
+							"custom_id": confirm.customId(),
+						},
+						{
+							"type":      discinteraction.ComponentButton,
+							"style":     discinteraction.ButtonSecondary,
+							"label":     "Cancel",
+							"custom_id": cancel.customId(),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// responseUpdateMsg replaces the message the clicked component is in, dropping its buttons
+func responseUpdateMsg(msg string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": ResponseUpdateMessage,
+		"data": map[string]interface{}{
+			"content":    msg,
+			"components": []interface{}{},
+		},
+	}
+}
+
+// handleStopConfirmation handles clicks on the buttons sent by responseStopConfirmation,
+// a confirm click only enqueues the stop after checking it isn't stale
+func (ih *InteractionHandler) handleStopConfirmation(interaction discinteraction.Interaction, now time.Time) map[string]interface{} {
+	confirmation, err := parseStopConfirmation(interaction.Data.CustomID)
+	if err != nil {
+		log.Printf("Error parsing component: %v", err)
+		return responseEphemeralMsg("Unknown button")
+	}
+	clicker := interaction.Invoker()
+	if !confirmation.Confirm {
+		if clicker.ID != confirmation.UserId {
+			return responseEphemeralMsg(fmt.Sprintf("Only <@%s> can answer this confirmation", confirmation.UserId))
+		}
+		return responseUpdateMsg("Stop cancelled, keep on playing!")
+	}
+	policy, err := ih.backends.loadPolicy()
+	if err != nil {
+		log.Printf("Error loading permissions policy: %v", err)
+		return responseEphemeralMsg("Failed to check your permissions, try again later")
+	}
+	if !policy.Allowed("stop", clicker.ID, interaction.Roles()) {
+		log.Printf("Denied stop confirmation for user %s (%s) with roles %v", clicker.ID, clicker.Username, interaction.Roles())
+		return responseEphemeralMsg("You are not allowed to run `/stop`, ask an admin for the required role")
+	}
+	state, err := ih.backends.loadState()
+	if err != nil {
+		log.Printf("Error loading state: %v", err)
+		return responseEphemeralMsg("Failed to read the Valheim server state")
+	}
+	if reason := confirmation.validate(clicker.ID, state, now); reason != "" {
+		log.Printf("Rejected stop confirmation from user %s: %s", clicker.ID, reason)
+		return responseEphemeralMsg(reason)
+	}
+	if err := ih.enqueueAction(events.Stop, interaction); err != nil {
+		log.Printf("Error enqueuing action: %v", err)
+		return responseEphemeralMsg("Failed to queue the action")
+	}
+	return responseUpdateMsg(fmt.Sprintf("Stop confirmed by <@%s>", clicker.ID))
+}
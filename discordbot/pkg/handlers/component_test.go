@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/valheimstate"
+	"testing"
+	"time"
+)
+
+func TestStopConfirmation(t *testing.T) {
+	now := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	state := &valheimstate.State{
+		Attributes: statestorageinterface.StateAttributes{
+			Ip:          "192.168.0.1",
+			Players:     testPlayers("player1,player2"),
+			Status:      "listening",
+			StatusSince: testTime("2024-10-05T20:00:00Z"),
+		},
+	}
+	response := responseStopConfirmation(state, "100", onlinePlayers(state), now)
+	row := response["data"].(map[string]interface{})["components"].([]map[string]interface{})[0]
+	buttons := row["components"].([]map[string]interface{})
+	confirmId := buttons[0]["custom_id"].(string)
+	cancelId := buttons[1]["custom_id"].(string)
+	if len(confirmId) > 100 || len(cancelId) > 100 {
+		t.Errorf("custom ids must be at most 100 characters but were %d and %d", len(confirmId), len(cancelId))
+	}
+	cancel, err := parseStopConfirmation(cancelId)
+	if err != nil || cancel.Confirm {
+		t.Errorf("expected %s to parse as a cancel but got %v, %v", cancelId, cancel, err)
+	}
+	confirmation, err := parseStopConfirmation(confirmId)
+	if err != nil || !confirmation.Confirm {
+		t.Fatalf("expected %s to parse as a confirm but got %v, %v", confirmId, confirmation, err)
+	}
+
+	type testcase struct {
+		Name      string
+		ClickerId string
+		Status    string
+		Since     time.Time
+		ClickedAt time.Time
+		Accepted  bool
+	}
+	testcases := []testcase{
+		{Name: "valid", ClickerId: "100", Status: "listening", Since: testTime("2024-10-05T20:00:00Z"), ClickedAt: now.Add(time.Minute), Accepted: true},
+		{Name: "someone else", ClickerId: "200", Status: "listening", Since: testTime("2024-10-05T20:00:00Z"), ClickedAt: now.Add(time.Minute)},
+		{Name: "expired", ClickerId: "100", Status: "listening", Since: testTime("2024-10-05T20:00:00Z"), ClickedAt: now.Add(5 * time.Minute)},
+		{Name: "replayed after stop", ClickerId: "100", Status: "stopped", Since: testTime("2024-10-05T22:00:30Z"), ClickedAt: now.Add(time.Minute)},
+	}
+	for _, tc := range testcases {
+		clickState := &valheimstate.State{
+			Attributes: statestorageinterface.StateAttributes{
+				Status:      tc.Status,
+				StatusSince: tc.Since,
+			},
+		}
+		reason := confirmation.validate(tc.ClickerId, clickState, tc.ClickedAt)
+		if (reason == "") != tc.Accepted {
+			t.Errorf("%s - expected accepted to be %t but rejection reason was %q", tc.Name, tc.Accepted, reason)
+		}
+	}
+
+	if _, err := parseStopConfirmation("stop:confirm:100"); err == nil {
+		t.Errorf("expected truncated custom id to be rejected")
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"godin/pkg/queue"
+	"log"
+	"time"
+)
+
+// Queue settings of host.json, the consumers reproduce what the functions host does with them
+const (
+	maxDequeueCount   = 5
+	visibilityTimeout = 2 * time.Second
+)
+
+// consume polls q until ctx is done, deleting the messages handle succeeds on,
+// failed ones come back after the visibility timeout
+func consume(ctx context.Context, q queue.Queue, pollInterval time.Duration, handle func(msg *queue.Message) error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		msg, err := q.Dequeue(visibilityTimeout)
+		if err != nil {
+			log.Printf("Error dequeuing message: %v", err)
+		}
+		if msg == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		if err := handle(msg); err != nil {
+			log.Printf("Error handling message %s (attempt %d): %v", msg.Id, msg.DequeueCount, err)
+			continue
+		}
+		if err := q.Delete(msg); err != nil {
+			log.Printf("Error deleting message %s: %v", msg.Id, err)
+		}
+	}
+}
+
+// Consume runs the reaction function in-process against events, for when there is no functions host.
+// Events that fail maxDequeueCount times are moved to poison
+func (rh *ReactionHandler) Consume(ctx context.Context, events, poison queue.Queue, pollInterval time.Duration) {
+	consume(ctx, events, pollInterval, func(msg *queue.Message) error {
+		return rh.process(msg, poison)
+	})
+}
+
+func (rh *ReactionHandler) process(msg *queue.Message, poison queue.Queue) error {
+	ah, err := rh.backends.newActionHandler(rh.registry)
+	if err == nil {
+		err = ah.handleAction(msg.Text)
+		if err != nil {
+			rh.backends.recordFailure(ah.cause, err)
+		}
+	}
+	if err != nil && msg.DequeueCount >= maxDequeueCount {
+		log.Printf("Moving message %s to the poison queue after %d attempts: %v", msg.Id, msg.DequeueCount, err)
+		return poison.Enqueue(msg.Text)
+	}
+	return err
+}
+
+// Consume runs the poison function in-process against poison, poisoned events that can't even be
+// reported after maxDequeueCount attempts are dropped
+func (ph *PoisonHandler) Consume(ctx context.Context, poison queue.Queue, pollInterval time.Duration) {
+	consume(ctx, poison, pollInterval, func(msg *queue.Message) error {
+		ah, err := ph.backends.newActionHandler(nil)
+		if err == nil {
+			err = ah.handlePoisoned(msg.Text)
+		}
+		if err != nil && msg.DequeueCount >= maxDequeueCount {
+			log.Printf("Dropping poisoned message %s after %d attempts: %v", msg.Id, msg.DequeueCount, err)
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"godin/pkg/aztclient"
+	"godin/pkg/disclient"
+	"godin/pkg/events"
+	"godin/pkg/filetclient"
+	"godin/pkg/queue"
+	"godin/pkg/steamapi"
+	"godin/pkg/vmplacement"
+	"godin/pkg/vmssclient"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type FailingVmssClient struct{}
+
+func (fvc FailingVmssClient) ScaleUp(report func(vmssclient.Attempt)) (vmplacement.Placement, error) {
+	return vmplacement.Placement{}, fmt.Errorf("authorization failed")
+}
+
+func (fvc FailingVmssClient) ScaleDown(placement vmplacement.Placement) error {
+	return fmt.Errorf("authorization failed")
+}
+
+func TestReactionConsumer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	discordclient := &TestDiscordClient{}
+	backends := Backends{
+		TableClient: func(partitionKey string) (aztclient.TableClientInterface, error) {
+			return filetclient.NewTableClient(path, partitionKey, "world")
+		},
+		Discord: func() (disclient.DiscordClientInterface, error) { return discordclient, nil },
+		Vmss:    func(ip string) (vmssclient.VmssClientInterface, error) { return FailingVmssClient{}, nil },
+		Steam:   func() steamapi.ClientInterface { return TestSteamClient{} },
+	}
+	reaction := NewReactionHandler(FallbackLog, backends)
+	eventsQueue := queue.NewMemoryQueue()
+	poisonQueue := queue.NewMemoryQueue()
+	event, _ := events.New(events.Start, events.SourceInteractions, nil, nil)
+	message, _ := events.Encode(event)
+	eventsQueue.Enqueue(message)
+
+	for attempt := 1; attempt <= maxDequeueCount; attempt++ {
+		msg, _ := eventsQueue.Dequeue(0)
+		if msg == nil {
+			t.Fatalf("expected the failed event to be retried on attempt %d", attempt)
+		}
+		err := reaction.process(msg, poisonQueue)
+		if attempt < maxDequeueCount && err == nil {
+			t.Errorf("expected attempt %d to fail", attempt)
+		}
+		if attempt == maxDequeueCount {
+			if err != nil {
+				t.Fatalf("expected the last attempt to move the event to the poison queue but was %v", err)
+			}
+			eventsQueue.Delete(msg)
+		}
+	}
+	if msg, _ := eventsQueue.Dequeue(0); msg != nil {
+		t.Errorf("expected the events queue to be empty but was %v", msg)
+	}
+	poisoned, _ := poisonQueue.Dequeue(time.Minute)
+	if poisoned == nil || poisoned.Text != message {
+		t.Fatalf("expected the event on the poison queue but was %v", poisoned)
+	}
+
+	state, err := backends.loadState()
+	if err != nil {
+		t.Fatalf("error loading state: %v", err)
+	}
+	if state.GetLastError() == "" {
+		t.Errorf("expected the failure to be recorded in state")
+	}
+
+	ah, _ := backends.newActionHandler(nil)
+	if err := ah.handlePoisoned(poisoned.Text); err != nil {
+		t.Fatalf("error handling poisoned event: %v", err)
+	}
+	if len(discordclient.adminMessagesSent) != 1 {
+		t.Errorf("expected the admins to be notified once but were %d times", len(discordclient.adminMessagesSent))
+	}
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"fmt"
+	"godin/pkg/events"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/valheimstate"
+	"godin/pkg/vmplacement"
+	"godin/pkg/vmssclient"
+	"log"
+	"strings"
+)
+
+// defaultEventHandlers are the handlers registered by NewReactionHandler
+func defaultEventHandlers() []eventHandler {
+	return []eventHandler{
+		startHandler{typeMatcher(events.Start)},
+		stopHandler{typeMatcher(events.Stop)},
+		publicIpHandler{typeMatcher(events.PublicIp)},
+		listeningHandler{typeMatcher(events.Listening)},
+		playerJoinedHandler{typeMatcher(events.PlayerJoined)},
+		playerLeftHandler{typeMatcher(events.PlayerLeft)},
+		characterHandler{typeMatcher(events.Character)},
+		evictedHandler{typeMatcher(events.Evicted)},
+		heartbeatHandler{typeMatcher(events.Heartbeat)},
+	}
+}
+
+type startHandler struct{ typeMatcher }
+
+func (startHandler) Mutate(ah *actionHandler, event events.Envelope) error {
+	if err := ah.state.Transition(valheimstate.Starting, event.CorrelationId); err != nil {
+		return err
+	}
+	ah.state.SetPendingInteraction(event.InteractionToken())
+	return nil
+}
+
+func (startHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	report := []string{"Starting Valheim server"}
+	if err := ah.notify(event.InteractionToken(), report[0]); err != nil {
+		return err
+	}
+	// failed attempts are added to the message, so the requester sees the fallbacks being tried
+	placement, err := ah.scaleUp(event.CorrelationId, func(failure string) {
+		report = append(report, failure)
+		if err := ah.notify(event.InteractionToken(), strings.Join(report, "\n")); err != nil {
+			log.Printf("error reporting scale up attempt: %v", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(report) == 1 {
+		return ah.notify(event.InteractionToken(), "Valheim server started")
+	}
+	report = append(report, fmt.Sprintf("Valheim server started on `%s`", placement))
+	return ah.notify(event.InteractionToken(), strings.Join(report, "\n"))
+}
+
+// scaleUp gets a vm, walking the fallback placements, and records the placement it got. reportFailure is
+// called with the description of every failed attempt
+func (ah *actionHandler) scaleUp(cause string, reportFailure func(failure string)) (vmplacement.Placement, error) {
+	placement, err := ah.vmssClient.ScaleUp(func(attempt vmssclient.Attempt) {
+		if attempt.Err != nil {
+			reportFailure(fmt.Sprintf("Could not get a `%s` vm: %s", attempt.Placement, vmssclient.ErrorCode(attempt.Err)))
+		}
+	})
+	if err != nil {
+		return vmplacement.Placement{}, err
+	}
+	return placement, ah.commit(func(state statestorageinterface.StateInterface) error {
+		state.SetPlacement(placement)
+		// the vm may already be listening, or a stop came in while scaling up, Transition logs it
+		state.Transition(valheimstate.Started, cause)
+		return nil
+	})
+}
+
+type stopHandler struct{ typeMatcher }
+
+func (stopHandler) Mutate(ah *actionHandler, event events.Envelope) error {
+	if err := ah.state.Transition(valheimstate.Stopping, event.CorrelationId); err != nil {
+		return err
+	}
+	ah.state.SetPendingInteraction("")
+	ah.state.ClearPlayers()
+	return nil
+}
+
+func (stopHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	if err := ah.notify(event.InteractionToken(), "Stopping Valheim server"); err != nil {
+		return err
+	}
+	// the server is stopped where it was last scaled up, the zero placement is the main scale set
+	if err := ah.vmssClient.ScaleDown(ah.state.GetPlacement()); err != nil {
+		return err
+	}
+	if err := ah.commit(func(state statestorageinterface.StateInterface) error {
+		return state.Transition(valheimstate.Stopped, event.CorrelationId)
+	}); err != nil {
+		return err
+	}
+	return ah.notify(event.InteractionToken(), "Valheim server stopped, hope you had a great time! :grin:")
+}
+
+type publicIpHandler struct{ typeMatcher }
+
+func (publicIpHandler) Mutate(ah *actionHandler, event events.Envelope) error {
+	var payload events.PublicIpPayload
+	if err := event.DecodePayload(&payload); err != nil {
+		return err
+	}
+	log.Printf("IP Address: %s", payload.Ip)
+	ah.state.SetIp(payload.Ip)
+	return nil
+}
+
+func (publicIpHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	return ah.discordClient.SendMessage(fmt.Sprintf("Public IP address: `%s`", ah.state.GetIp()))
+}
+
+type listeningHandler struct{ typeMatcher }
+
+func (listeningHandler) Mutate(ah *actionHandler, event events.Envelope) error {
+	if err := ah.state.Transition(valheimstate.Listening, event.CorrelationId); err != nil {
+		return err
+	}
+	resetIdle(ah.state, event.Timestamp)
+	return nil
+}
+
+func (listeningHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	// the start interaction, if there is one, is edited to tell its requester the server is ready
+	pending := ah.state.GetPendingInteraction()
+	if pending != "" {
+		if err := ah.commit(func(state statestorageinterface.StateInterface) error {
+			state.SetPendingInteraction("")
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return ah.notify(pending, "Valheim server is ready, enjoy!")
+}
+
+type playerJoinedHandler struct{ typeMatcher }
+
+func (playerJoinedHandler) Mutate(ah *actionHandler, event events.Envelope) error {
+	var payload events.PlayerPayload
+	if err := event.DecodePayload(&payload); err != nil {
+		return err
+	}
+	realname, err := ah.playerName(payload.SteamId)
+	if err != nil {
+		return err
+	}
+	ah.state.AddPlayer(statestorageinterface.Player{SteamId: payload.SteamId, Name: realname, JoinedAt: event.Timestamp})
+	resetIdle(ah.state, event.Timestamp)
+	return nil
+}
+
+func (playerJoinedHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	var payload events.PlayerPayload
+	if err := event.DecodePayload(&payload); err != nil {
+		return err
+	}
+	realname, err := ah.playerName(payload.SteamId)
+	if err != nil {
+		return err
+	}
+	return ah.discordClient.SendMessage(fmt.Sprintf("Greetings `%s`!", realname))
+}
+
+type playerLeftHandler struct{ typeMatcher }
+
+func (playerLeftHandler) Mutate(ah *actionHandler, event events.Envelope) error {
+	var payload events.PlayerPayload
+	if err := event.DecodePayload(&payload); err != nil {
+		return err
+	}
+	realname, err := ah.playerName(payload.SteamId)
+	if err != nil {
+		return err
+	}
+	ah.state.RemovePlayer(payload.SteamId, realname)
+	if len(onlinePlayers(ah.state)) == 0 {
+		resetIdle(ah.state, event.Timestamp)
+	}
+	return nil
+}
+
+func (playerLeftHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	var payload events.PlayerPayload
+	if err := event.DecodePayload(&payload); err != nil {
+		return err
+	}
+	realname, err := ah.playerName(payload.SteamId)
+	if err != nil {
+		return err
+	}
+	return ah.discordClient.SendMessage(fmt.Sprintf("Farewell `%s`...", realname))
+}
+
+// characterHandler records the character of the player that spawned. The server logs only the character
+// name, right after the connection of its player, so it goes to the last player that joined without one
+type characterHandler struct{ typeMatcher }
+
+func (characterHandler) Mutate(ah *actionHandler, event events.Envelope) error {
+	var payload events.CharacterPayload
+	if err := event.DecodePayload(&payload); err != nil {
+		return err
+	}
+	players := ah.state.GetPlayers()
+	for i := len(players) - 1; i >= 0; i-- {
+		if players[i].Character == "" && players[i].SteamId != "" {
+			ah.state.SetPlayerCharacter(players[i].SteamId, payload.Name)
+			return nil
+		}
+	}
+	log.Printf("No player without a character for %s", payload.Name)
+	return nil
+}
+
+func (characterHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	return nil
+}
+
+// evictedHandler handles the agent telling azure is preempting the spot vm, after it stopped the server
+type evictedHandler struct{ typeMatcher }
+
+func (evictedHandler) Mutate(ah *actionHandler, event events.Envelope) error {
+	if err := ah.state.Transition(valheimstate.Evicted, event.CorrelationId); err != nil {
+		return err
+	}
+	ah.state.SetPendingInteraction("")
+	ah.state.ClearPlayers()
+	return nil
+}
+
+// Notify brings the server back, on the placements after the evicted one when azure has no room for it again
+func (evictedHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	evicted := ah.state.GetPlacement()
+	restart := false
+	if err := ah.commit(func(state statestorageinterface.StateInterface) error {
+		// a stop that came in since leaves the server down, Transition logs it
+		restart = state.Transition(valheimstate.Starting, event.CorrelationId) == nil
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !restart {
+		return ah.discordClient.SendMessage(":warning: Azure evicted the Valheim server, the world was saved before it went down")
+	}
+	if err := ah.discordClient.SendMessage(":warning: Azure evicted the Valheim server, the world was saved before it went down. Bringing it back..."); err != nil {
+		return err
+	}
+	// the vm being evicted still counts in its scale set, ScaleUp would take it for a running one
+	if err := ah.vmssClient.ScaleDown(evicted); err != nil {
+		return err
+	}
+	placement, err := ah.scaleUp(event.CorrelationId, func(failure string) {
+		if err := ah.discordClient.SendMessage(failure); err != nil {
+			log.Printf("error reporting scale up attempt: %v", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return ah.discordClient.SendMessage(fmt.Sprintf("Valheim server started again on `%s`", placement))
+}
+
+// heartbeatHandler records the heartbeats of the vm agent, the watchdog flags the server when they stop.
+// An unhealthy server stays so until it reports listening again, or is stopped
+type heartbeatHandler struct{ typeMatcher }
+
+func (heartbeatHandler) Mutate(ah *actionHandler, event events.Envelope) error {
+	ah.state.SetLastHeartbeat(event.Timestamp)
+	return nil
+}
+
+func (heartbeatHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	return nil
+}
+
+// logFallbackHandler only logs events no other handler matched
+type logFallbackHandler struct{}
+
+func (logFallbackHandler) Match(event events.Envelope) bool {
+	return true
+}
+
+func (logFallbackHandler) Mutate(ah *actionHandler, event events.Envelope) error {
+	return nil
+}
+
+func (logFallbackHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	log.Printf("Ignoring unknown %s event %s from %s: %s", event.Type, event.CorrelationId, event.Source, event.Payload)
+	return nil
+}
+
+// channelFallbackHandler posts events no other handler matched to the players channel
+type channelFallbackHandler struct{ logFallbackHandler }
+
+func (channelFallbackHandler) Notify(ah *actionHandler, event events.Envelope) error {
+	var payload events.LogPayload
+	if err := event.DecodePayload(&payload); err != nil || payload.Line == "" {
+		return ah.discordClient.SendMessage(fmt.Sprintf("Received unknown %s event", event.Type))
+	}
+	return ah.discordClient.SendMessage(fmt.Sprintf("Received unknown action: %s", payload.Line))
+}
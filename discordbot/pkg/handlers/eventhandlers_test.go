@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"godin/pkg/events"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/valheimstate"
+	"godin/pkg/vmplacement"
+	"godin/pkg/vmssclient"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+func TestPlayerJoinedHandler(t *testing.T) {
+	disclient := TestDiscordClient{}
+	state := &valheimstate.State{
+		Attributes: statestorageinterface.StateAttributes{Players: testPlayers("player2"), Status: "listening"},
+	}
+	ah := newActionHandler(nil, &disclient, &TestVmssClient{}, TestSteamClient{}, state)
+	event, err := events.New(events.PlayerJoined, events.SourceVm, nil, events.PlayerPayload{SteamId: "76561198073103840"})
+	if err != nil {
+		t.Fatalf("error creating event: %v", err)
+	}
+	handler := playerJoinedHandler{typeMatcher(events.PlayerJoined)}
+	if !handler.Match(event) {
+		t.Fatalf("expected handler to match %s events", event.Type)
+	}
+	if err := handler.Mutate(ah, event); err != nil {
+		t.Fatalf("error mutating state: %v", err)
+	}
+	if playerNames(state.Attributes) != "player2,player1" {
+		t.Errorf("expected online players to be player2,player1 but were %s", playerNames(state.Attributes))
+	}
+	if len(disclient.messagesSent) != 0 {
+		t.Errorf("expected mutate not to send messages but sent %v", disclient.messagesSent)
+	}
+	if err := handler.Notify(ah, event); err != nil {
+		t.Fatalf("error notifying: %v", err)
+	}
+	if !reflect.DeepEqual(disclient.messagesSent, []string{"Greetings `player1`!"}) {
+		t.Errorf("expected greeting to be sent but sent %v", disclient.messagesSent)
+	}
+}
+
+func TestEvictedHandler(t *testing.T) {
+	disclient := TestDiscordClient{}
+	state := newTestState(statestorageinterface.StateAttributes{
+		Players:            testPlayers("player1,player2"),
+		Status:             "listening",
+		PendingInteraction: "token1",
+		Placement:          vmplacement.Placement{Vmss: "valheim-server-vmss"},
+	}, newTestStorage(""))
+	vmss := &FallbackVmssClient{}
+	ah := newActionHandler(NewReactionHandler(FallbackLog, Backends{}).registry, &disclient, vmss, TestSteamClient{}, state)
+	event, err := events.New(events.Evicted, events.SourceVm, nil, events.EvictedPayload{EventId: "preempt-1"})
+	if err != nil {
+		t.Fatalf("error creating event: %v", err)
+	}
+	message, _ := events.Encode(event)
+	if err := ah.handleAction(message); err != nil {
+		t.Fatalf("error handling evicted event: %v", err)
+	}
+	// the evicted vm is removed and a new one scaled up, on the fallback when the spot sku has no room again
+	if !reflect.DeepEqual(vmss.scaledDown, []vmplacement.Placement{{Vmss: "valheim-server-vmss"}}) || vmss.scaleUps != 1 {
+		t.Errorf("expected the evicted placement to be scaled down and a new vm up but scaled down %v and up %d times", vmss.scaledDown, vmss.scaleUps)
+	}
+	expectedPlacement := vmplacement.Placement{Vmss: "valheim-server-vmss", Sku: "Standard_D2s_v5"}
+	if state.Attributes.Status != "started" || state.Attributes.Placement != expectedPlacement || playerNames(state.Attributes) != "" || state.Attributes.PendingInteraction != "" {
+		t.Errorf("expected state to be started on the fallback without players nor pending interaction but was %+v", state.Attributes)
+	}
+	expectedMessages := []string{
+		":warning: Azure evicted the Valheim server, the world was saved before it went down. Bringing it back...",
+		"Could not get a `default sku spot on valheim-server-vmss` vm: AllocationFailed",
+		"Valheim server started again on `Standard_D2s_v5 spot on valheim-server-vmss`",
+	}
+	if !reflect.DeepEqual(disclient.messagesSent, expectedMessages) {
+		t.Errorf("expected the eviction and the recovery to be reported but sent %q", disclient.messagesSent)
+	}
+}
+
+func TestCharacterHandler(t *testing.T) {
+	disclient := TestDiscordClient{}
+	state := &valheimstate.State{
+		Attributes: statestorageinterface.StateAttributes{Players: testPlayers("player1,player2"), Status: "listening"},
+	}
+	state.SetPlayerCharacter("76561198073103840", "Ragnar")
+	ah := newActionHandler(nil, &disclient, &TestVmssClient{}, TestSteamClient{}, state)
+	event, err := events.FromLogLine("Got character ZDOID from Lagertha : -1043766231:1")
+	if err != nil {
+		t.Fatalf("error creating event: %v", err)
+	}
+	handler := characterHandler{typeMatcher(events.Character)}
+	if !handler.Match(event) {
+		t.Fatalf("expected handler to match %s events", event.Type)
+	}
+	if err := handler.Mutate(ah, event); err != nil {
+		t.Fatalf("error mutating state: %v", err)
+	}
+	if players := onlinePlayers(state); !reflect.DeepEqual(players, []string{"player1 (Ragnar)", "player2 (Lagertha)"}) {
+		t.Errorf("expected the character to go to player2 but players were %v", players)
+	}
+}
+
+func TestTransitionRejected(t *testing.T) {
+	type testcase struct {
+		Name           string
+		Status         string
+		Event          events.Type
+		Requester      *events.Requester
+		ExpectedStatus string
+		ExpectedEdits  []string
+	}
+	testcases := []testcase{
+		{
+			Name:           "late listening",
+			Status:         "stopped",
+			Event:          events.Listening,
+			ExpectedStatus: "stopped",
+		},
+		{
+			Name:           "second start",
+			Status:         "starting",
+			Event:          events.Start,
+			Requester:      &events.Requester{UserId: "100", InteractionToken: "token2"},
+			ExpectedStatus: "starting",
+			ExpectedEdits:  []string{"token2: Valheim server is already `starting`"},
+		},
+		{
+			Name:           "start while stopping",
+			Status:         "stopping",
+			Event:          events.Start,
+			Requester:      &events.Requester{UserId: "100", InteractionToken: "token2"},
+			ExpectedStatus: "stopping",
+			ExpectedEdits:  []string{"token2: Can't start the Valheim server while it is `stopping`"},
+		},
+	}
+	for _, tc := range testcases {
+		disclient := TestDiscordClient{}
+		vmssclient := TestVmssClient{}
+		state := newTestState(statestorageinterface.StateAttributes{Status: tc.Status, StatusEvent: "event1"}, newTestStorage(""))
+		ah := newActionHandler(NewReactionHandler(FallbackLog, Backends{}).registry, &disclient, &vmssclient, TestSteamClient{}, state)
+		event, err := events.New(tc.Event, events.SourceInteractions, tc.Requester, events.LogPayload{Line: "Server is now listening"})
+		if err != nil {
+			t.Fatalf("%s - error creating event: %v", tc.Name, err)
+		}
+		message, _ := events.Encode(event)
+		if err := ah.handleAction(message); err != nil {
+			t.Errorf("%s - expected the event to be dropped without retries but got %v", tc.Name, err)
+		}
+		if state.Attributes.Status != tc.ExpectedStatus {
+			t.Errorf("%s - expected status %s but was %s", tc.Name, tc.ExpectedStatus, state.Attributes.Status)
+		}
+		if !reflect.DeepEqual(disclient.interactionEdits, tc.ExpectedEdits) {
+			t.Errorf("%s - expected edits %q but were %q", tc.Name, tc.ExpectedEdits, disclient.interactionEdits)
+		}
+		if len(disclient.messagesSent) != 0 {
+			t.Errorf("%s - expected no channel messages but sent %v", tc.Name, disclient.messagesSent)
+		}
+	}
+}
+
+// FallbackVmssClient gets a vm on its second placement
+type FallbackVmssClient struct {
+	scaleUps   int
+	scaledDown []vmplacement.Placement
+}
+
+func (fvc *FallbackVmssClient) ScaleUp(report func(vmssclient.Attempt)) (vmplacement.Placement, error) {
+	fvc.scaleUps++
+	placements := []vmplacement.Placement{
+		{Vmss: "valheim-server-vmss"},
+		{Vmss: "valheim-server-vmss", Sku: "Standard_D2s_v5"},
+	}
+	return vmssclient.WalkPlacements(placements, func(placement vmplacement.Placement) error {
+		if placement.Sku == "" {
+			return &azcore.ResponseError{ErrorCode: "AllocationFailed"}
+		}
+		return nil
+	}, report)
+}
+
+func (fvc *FallbackVmssClient) ScaleDown(placement vmplacement.Placement) error {
+	fvc.scaledDown = append(fvc.scaledDown, placement)
+	return nil
+}
+
+func TestStartHandlerFallback(t *testing.T) {
+	disclient := TestDiscordClient{}
+	state := newTestState(statestorageinterface.StateAttributes{}, newTestStorage(""))
+	ah := newActionHandler(nil, &disclient, &FallbackVmssClient{}, TestSteamClient{}, state)
+	event, err := events.New(events.Start, events.SourceInteractions, &events.Requester{UserId: "100", InteractionToken: "token1"}, nil)
+	if err != nil {
+		t.Fatalf("error creating event: %v", err)
+	}
+	if err := (startHandler{}).Notify(ah, event); err != nil {
+		t.Fatalf("error notifying: %v", err)
+	}
+	expectedEdits := []string{
+		"token1: Starting Valheim server",
+		"token1: Starting Valheim server\nCould not get a `default sku spot on valheim-server-vmss` vm: AllocationFailed",
+		"token1: Starting Valheim server\nCould not get a `default sku spot on valheim-server-vmss` vm: AllocationFailed\nValheim server started on `Standard_D2s_v5 spot on valheim-server-vmss`",
+	}
+	if !reflect.DeepEqual(disclient.interactionEdits, expectedEdits) {
+		t.Errorf("expected edits to be %q but were %q", expectedEdits, disclient.interactionEdits)
+	}
+	if expected := (vmplacement.Placement{Vmss: "valheim-server-vmss", Sku: "Standard_D2s_v5"}); state.Attributes.Placement != expected {
+		t.Errorf("expected the fallback placement to be recorded but was %+v", state.Attributes.Placement)
+	}
+}
+
+func TestUnknownEventFallback(t *testing.T) {
+	type testcase struct {
+		Fallback         string
+		ExpectedMessages []string
+	}
+	testcases := []testcase{
+		{Fallback: "", ExpectedMessages: nil},
+		{Fallback: FallbackLog, ExpectedMessages: nil},
+		{Fallback: FallbackChannel, ExpectedMessages: []string{"Received unknown action: Loading world"}},
+		{Fallback: "unsupported", ExpectedMessages: nil},
+	}
+	for _, tc := range testcases {
+		disclient := TestDiscordClient{}
+		state := newTestState(statestorageinterface.StateAttributes{}, newTestStorage(`{"ip":"192.168.0.1", "online_players": "", "status":"listening"}`))
+		ah := newActionHandler(NewReactionHandler(tc.Fallback, Backends{}).registry, &disclient, &TestVmssClient{}, TestSteamClient{}, state)
+		if err := ah.handleAction("Loading world"); err != nil {
+			t.Errorf("%s - error handling unknown event: %v", tc.Fallback, err)
+		}
+		if !reflect.DeepEqual(disclient.messagesSent, tc.ExpectedMessages) {
+			t.Errorf("%s - expected sent messages to be %v but were %v", tc.Fallback, tc.ExpectedMessages, disclient.messagesSent)
+		}
+	}
+}
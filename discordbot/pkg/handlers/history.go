@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"godin/pkg/discinteraction"
+	"godin/pkg/history"
+	"godin/pkg/statestorageinterface"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// historyPageSize is how many entries a /history page lists, fewer when they don't fit in a message
+	historyPageSize = 10
+	// historyMaxLength keeps a page under the 2000 characters of a discord message
+	historyMaxLength = 1800
+	// historyValueLength is where long values, like poisoned events, are cut
+	historyValueLength = 40
+)
+
+// historyResponse lists the entries older than the entry with key after, with a button to the next page
+// when there are more. Clicks on that button update the message with the next page instead of sending another
+func (ih *InteractionHandler) historyResponse(after string, update bool) map[string]interface{} {
+	fail := responseEphemeralMsg
+	if update {
+		fail = responseUpdateMsg
+	}
+	store := ih.backends.history()
+	if store == nil {
+		return fail("The state history isn't kept on this server")
+	}
+	entries, more, err := store.Page(after, historyPageSize)
+	if err != nil {
+		log.Printf("Error reading history: %v", err)
+		return fail("Failed to read the state history")
+	}
+	content, next := historyMessage(entries, more)
+	components := []map[string]interface{}{}
+	if next != "" {
+		components = append(components, map[string]interface{}{
+			"type": discinteraction.ComponentActionRow,
+			"components": []map[string]interface{}{
+				{
+					"type":      discinteraction.ComponentButton,
+					"style":     discinteraction.ButtonSecondary,
+					"label":     "Older",
+					"custom_id": historyCustomId(next),
+				},
+			},
+		})
+	}
+	responseType := ResponseChannelMsg
+	if update {
+		responseType = ResponseUpdateMessage
+	}
+	return map[string]interface{}{
+		"type": responseType,
+		"data": map[string]interface{}{
+			"content":    content,
+			"flags":      MessageFlagEphemeral,
+			"components": components,
+		},
+	}
+}
+
+// historyCustomId carries the key of the last entry shown, the next page starts after it
+func historyCustomId(after string) string {
+	return "history:" + after
+}
+
+// handleHistoryPage handles clicks on the older button of /history
+func (ih *InteractionHandler) handleHistoryPage(interaction discinteraction.Interaction) map[string]interface{} {
+	after, found := strings.CutPrefix(interaction.Data.CustomID, "history:")
+	if !found || after == "" {
+		return responseEphemeralMsg("Unknown button")
+	}
+	return ih.historyResponse(after, true)
+}
+
+// historyMessage lists entries newest first, as many as fit in a message. next is the key of the last one
+// listed when there are older entries
+func historyMessage(entries []history.Entry, more bool) (content string, next string) {
+	if len(entries) == 0 {
+		return "No state changes recorded", ""
+	}
+	lines := []string{}
+	length := 0
+	for i, entry := range entries {
+		entryLines := historyLines(entry)
+		entryLength := len(strings.Join(entryLines, "\n")) + 1
+		if i != 0 && length+entryLength > historyMaxLength {
+			return strings.Join(lines, "\n"), entries[i-1].Key
+		}
+		lines = append(lines, entryLines...)
+		length += entryLength
+	}
+	if more {
+		next = entries[len(entries)-1].Key
+	}
+	return strings.Join(lines, "\n"), next
+}
+
+func historyLines(entry history.Entry) []string {
+	cause := fmt.Sprintf("`%s`", entry.EventType)
+	if entry.RequesterId != "" {
+		cause += fmt.Sprintf(" by <@%s>", entry.RequesterId)
+	}
+	if entry.Source != "" {
+		cause += fmt.Sprintf(" from %s", entry.Source)
+	}
+	lines := []string{fmt.Sprintf("<t:%d:f> %s", entry.At.Unix(), cause)}
+	for _, change := range entry.Changes {
+		lines = append(lines, fmt.Sprintf("- %s: %s → %s", change.Column, historyValue(change.Column, change.Previous), historyValue(change.Column, change.Value)))
+	}
+	return lines
+}
+
+// historyValue shows a value of a change, players by name and long values cut
+func historyValue(column, value string) string {
+	if value == "" {
+		return "_none_"
+	}
+	if column == "players" {
+		var players statestorageinterface.Players
+		if err := json.Unmarshal([]byte(value), &players); err == nil {
+			names := []string{}
+			for _, player := range players.Sorted() {
+				names = append(names, player.Name)
+			}
+			value = strings.Join(names, ", ")
+		}
+	}
+	if utf8.RuneCountInString(value) > historyValueLength {
+		value = string([]rune(value)[:historyValueLength]) + "…"
+	}
+	return "`" + strings.ReplaceAll(value, "`", "'") + "`"
+}
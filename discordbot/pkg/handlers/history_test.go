@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"godin/pkg/aztclient"
+	"godin/pkg/discinteraction"
+	"godin/pkg/history"
+	"godin/pkg/memtclient"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/valheimstate"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	table := memtclient.NewTable()
+	backends := Backends{
+		Partition: func(partitionKey string) (aztclient.PartitionClientInterface, error) {
+			return memtclient.NewPartitionClient(table, partitionKey), nil
+		},
+	}
+	state := valheimstate.NewValheimState(newTestStorage(`{"ip":"", "status":"stopped"}`))
+	state.Load()
+	ah := newActionHandler(NewReactionHandler(FallbackLog, Backends{}).registry, &TestDiscordClient{}, &TestVmssClient{}, TestSteamClient{}, state)
+	ah.records = backends.records()
+	message := `{"version":1,"type":"start","source":"interactions","correlation_id":"id1","requester":{"user_id":"100","username":"viking","interaction_token":"token1"}}`
+	if err := ah.handleAction(message); err != nil {
+		t.Fatalf("error handling start: %v", err)
+	}
+
+	entries, _, err := backends.history().Page("", 10)
+	if err != nil {
+		t.Fatalf("error reading history: %v", err)
+	}
+	statuses := []string{}
+	for _, entry := range entries {
+		if entry.EventType != "start" || entry.RequesterId != "100" || entry.CorrelationId != "id1" {
+			t.Errorf("expected entries caused by the start of 100 but got %+v", entry)
+		}
+		for _, change := range entry.Changes {
+			if change.Column == "status" {
+				statuses = append(statuses, change.Previous+"->"+change.Value)
+			}
+			if change.Column == "pending_interaction" {
+				t.Errorf("expected interaction tokens to be left out of the history but got %+v", change)
+			}
+		}
+	}
+	if strings.Join(statuses, ",") != "starting->started,stopped->starting" {
+		t.Errorf("expected the start transitions newest first but were %v", statuses)
+	}
+
+	handler := NewInteractionHandler(nil, nil, backends)
+	response := handler.historyResponse("", false)
+	content := response["data"].(map[string]interface{})["content"].(string)
+	if !strings.Contains(content, "`start` by <@100> from interactions") || !strings.Contains(content, "- status: `stopped` → `starting`") {
+		t.Errorf("expected the start to be listed but was %q", content)
+	}
+	if response := NewInteractionHandler(nil, nil, Backends{}).historyResponse("", false); !strings.Contains(fmt.Sprint(response), "isn't kept") {
+		t.Errorf("expected a message saying there is no history but got %v", response)
+	}
+}
+
+func TestHistoryMessage(t *testing.T) {
+	at := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	entries := []history.Entry{}
+	for i := 0; i < 10; i++ {
+		entries = append(entries, history.Entry{
+			Key:       fmt.Sprintf("key%d", i),
+			At:        at,
+			EventType: "poisoned start",
+			Changes: []history.Change{
+				{Column: "poisoned_event", Value: strings.Repeat("x", 300)},
+				{Column: "last_error", Previous: strings.Repeat("y", 300)},
+				{Column: "players", Previous: playersColumn(testPlayers("player1,player2")), Value: playersColumn(testPlayers("player1"))},
+				{Column: "status", Previous: "starting", Value: "failed"},
+			},
+		})
+	}
+	content, next := historyMessage(entries, false)
+	if len(content) > historyMaxLength || next == "" || next == "key9" {
+		t.Errorf("expected a page cut before the message limit but was %d characters up to %q", len(content), next)
+	}
+	if !strings.Contains(content, "- players: `player1, player2` → `player1`") || !strings.Contains(content, "- last_error: `"+strings.Repeat("y", historyValueLength)+"…` → _none_") {
+		t.Errorf("expected players by name and long values cut but was %q", content)
+	}
+	if _, next := historyMessage(entries[:2], false); next != "" {
+		t.Errorf("expected no next page for the last entries but got %q", next)
+	}
+	if _, next := historyMessage(entries[:2], true); next != "key1" {
+		t.Errorf("expected the next page after the last entry but got %q", next)
+	}
+
+	interaction := discinteraction.Interaction{Data: discinteraction.Data{CustomID: historyCustomId("key1")}}
+	response := NewInteractionHandler(nil, nil, Backends{}).handleHistoryPage(interaction)
+	if response["type"] != ResponseUpdateMessage {
+		t.Errorf("expected a click on older to update the message but got %v", response)
+	}
+}
+
+// playersColumn is the players column of a history change, as entitycodec stores it
+func playersColumn(players statestorageinterface.Players) string {
+	playersBytes, _ := json.Marshal(players)
+	return string(playersBytes)
+}
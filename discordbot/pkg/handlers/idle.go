@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"godin/pkg/discinteraction"
+	"godin/pkg/events"
+	"godin/pkg/history"
+	"godin/pkg/queue"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/valheimstate"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IdleConfig is when an empty server is stopped and how long before that players are warned, a zero Timeout disables it
+type IdleConfig struct {
+	Timeout time.Duration
+	Warning time.Duration
+}
+
+// DefaultIdleConfig is used by worlds that don't override it in their state entity
+var DefaultIdleConfig = IdleConfig{
+	Timeout: 30 * time.Minute,
+	Warning: 5 * time.Minute,
+}
+
+// IdleConfigFromEnv reads the IDLE_TIMEOUT and IDLE_WARNING go durations, defaulting to DefaultIdleConfig
+func IdleConfigFromEnv() (IdleConfig, error) {
+	config := DefaultIdleConfig
+	if value := os.Getenv("IDLE_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return config, fmt.Errorf("error parsing IDLE_TIMEOUT: %v", err)
+		}
+		config.Timeout = timeout
+	}
+	if value := os.Getenv("IDLE_WARNING"); value != "" {
+		warning, err := time.ParseDuration(value)
+		if err != nil {
+			return config, fmt.Errorf("error parsing IDLE_WARNING: %v", err)
+		}
+		config.Warning = warning
+	}
+	return config, nil
+}
+
+// forWorld applies the overrides kept in the state of the world, invalid ones are logged and ignored
+func (ic IdleConfig) forWorld(state statestorageinterface.StateInterface) IdleConfig {
+	attributes := state.GetAttributes()
+	if attributes.IdleTimeout != "" {
+		if timeout, err := time.ParseDuration(attributes.IdleTimeout); err == nil {
+			ic.Timeout = timeout
+		} else {
+			log.Printf("Ignoring invalid idle_timeout %q: %v", attributes.IdleTimeout, err)
+		}
+	}
+	if attributes.IdleWarning != "" {
+		if warning, err := time.ParseDuration(attributes.IdleWarning); err == nil {
+			ic.Warning = warning
+		} else {
+			log.Printf("Ignoring invalid idle_warning %q: %v", attributes.IdleWarning, err)
+		}
+	}
+	return ic
+}
+
+// idleStatuses are the statuses of a running vm that is stopped when nobody plays on it, an unhealthy server
+// has no players left, so it is stopped once the idle timeout passes unless it recovers
+var idleStatuses = []string{valheimstate.Listening, valheimstate.Unhealthy}
+
+// IdleHandler is the timer-triggered function that stops listening servers nobody played on for a while
+type IdleHandler struct {
+	backends Backends
+	events   queue.Queue
+	defaults IdleConfig
+}
+
+func NewIdleHandler(backends Backends, events queue.Queue, defaults IdleConfig) *IdleHandler {
+	return &IdleHandler{
+		backends: backends,
+		events:   events,
+		defaults: defaults,
+	}
+}
+
+func (ih *IdleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if err := ih.check(time.Now()); err != nil {
+		setInternalServerErrorWithLogs(w, fmt.Errorf("cought error: %v", err))
+		return
+	}
+
+	invokeResponse := invokeResponse{Logs: []string{}}
+	js, err := json.Marshal(invokeResponse)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// Watch runs the check every interval until ctx is done, for when there is no functions host
+func (ih *IdleHandler) Watch(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-time.After(interval):
+			if err := ih.check(now); err != nil {
+				log.Printf("Error checking idle server: %v", err)
+			}
+		}
+	}
+}
+
+func (ih *IdleHandler) check(now time.Time) error {
+	ah, err := ih.backends.newActionHandler(nil)
+	if err != nil {
+		return err
+	}
+	ah.cause = history.Cause{EventType: "idle", Source: events.SourceScheduler, At: now}
+	return ah.checkIdle(now, ih.defaults, func() error {
+		event, err := events.New(events.Stop, events.SourceScheduler, nil, nil)
+		if err != nil {
+			return err
+		}
+		message, err := events.Encode(event)
+		if err != nil {
+			return err
+		}
+		log.Printf("Enqueuing idle stop event %s", event.CorrelationId)
+		return ih.events.Enqueue(message)
+	})
+}
+
+// checkIdle warns the channel when a listening server without players is about to be stopped, with a button
+// to keep it up, and calls enqueueStop once it was idle for the whole timeout. The stop is enqueued once, the
+// checks running until it is handled leave it be
+func (ah *actionHandler) checkIdle(now time.Time, defaults IdleConfig, enqueueStop func() error) error {
+	var emptySince time.Time
+	var config IdleConfig
+	warn, stop := false, false
+	if err := ah.commit(func(state statestorageinterface.StateInterface) error {
+		warn, stop = false, false
+		if !slices.Contains(idleStatuses, state.GetStatus()) || len(onlinePlayers(state)) != 0 {
+			return nil
+		}
+		config = defaults.forWorld(state)
+		if config.Timeout <= 0 {
+			return nil
+		}
+		emptySince = state.GetEmptySince()
+		if emptySince.IsZero() {
+			// servers that were listening before empty_since existed start counting now
+			state.SetEmptySince(now)
+			return nil
+		}
+		idle := now.Sub(emptySince)
+		if idle >= config.Timeout {
+			if !state.GetIdleStoppedAt().IsZero() {
+				return nil
+			}
+			state.SetIdleStoppedAt(now)
+			stop = true
+			return nil
+		}
+		if idle < config.Timeout-config.Warning || !state.GetIdleWarnedAt().IsZero() {
+			return nil
+		}
+		state.SetIdleWarnedAt(now)
+		warn = true
+		return nil
+	}); err != nil {
+		return err
+	}
+	if stop {
+		if err := enqueueStop(); err != nil {
+			// the next check enqueues it again
+			if clearErr := ah.commit(func(state statestorageinterface.StateInterface) error {
+				state.SetIdleStoppedAt(time.Time{})
+				return nil
+			}); clearErr != nil {
+				log.Printf("Error clearing idle_stopped_at: %v", clearErr)
+			}
+			return err
+		}
+		return ah.discordClient.SendMessage(fmt.Sprintf("Nobody played for %s, stopping the Valheim server", now.Sub(emptySince).Truncate(time.Minute)))
+	}
+	if !warn {
+		return nil
+	}
+	stopAt := emptySince.Add(config.Timeout)
+	return ah.discordClient.SendButtonMessage(
+		fmt.Sprintf("Nobody is playing, the Valheim server stops <t:%d:R> unless someone joins", stopAt.Unix()),
+		"Keep it running",
+		idleCancelCustomId(emptySince),
+	)
+}
+
+// idleCancelCustomId ties the keep running button to the idle period it was sent for
+func idleCancelCustomId(emptySince time.Time) string {
+	return fmt.Sprintf("idle:cancel:%d", emptySince.Unix())
+}
+
+// resetIdle starts counting again, players joining or keeping the server up cancel the pending shutdown
+func resetIdle(state statestorageinterface.StateInterface, now time.Time) {
+	if len(onlinePlayers(state)) == 0 {
+		state.SetEmptySince(now)
+	} else {
+		state.SetEmptySince(time.Time{})
+	}
+	state.SetIdleWarnedAt(time.Time{})
+	state.SetIdleStoppedAt(time.Time{})
+}
+
+// handleIdleCancel handles clicks on the keep running button of the idle shutdown warning
+func (ih *InteractionHandler) handleIdleCancel(interaction discinteraction.Interaction, now time.Time) map[string]interface{} {
+	parts := strings.Split(interaction.Data.CustomID, ":")
+	if len(parts) != 3 || parts[1] != "cancel" {
+		return responseEphemeralMsg("Unknown button")
+	}
+	emptySince, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return responseEphemeralMsg("Unknown button")
+	}
+	state, err := ih.backends.loadState()
+	if err != nil {
+		log.Printf("Error loading state: %v", err)
+		return responseEphemeralMsg("Failed to read the Valheim server state")
+	}
+	current := true
+	if err := valheimstate.UpdateRecorded(state, func(state statestorageinterface.StateInterface) error {
+		current = slices.Contains(idleStatuses, state.GetStatus()) && state.GetEmptySince().Unix() == emptySince
+		if current {
+			resetIdle(state, now)
+		}
+		return nil
+	}, ih.backends.recorder(interactionCause("idle_cancel", interaction))); err != nil {
+		log.Printf("Error saving state: %v", err)
+		return responseEphemeralMsg("Failed to keep the server running, try again")
+	}
+	if !current {
+		return responseUpdateMsg("This shutdown warning is no longer current")
+	}
+	return responseUpdateMsg(fmt.Sprintf("<@%s> kept the Valheim server running", interaction.Invoker().ID))
+}
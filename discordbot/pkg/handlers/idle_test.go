@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"fmt"
+	"godin/pkg/statestorageinterface"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckIdle(t *testing.T) {
+	type testcase struct {
+		Name               string
+		Attributes         statestorageinterface.StateAttributes
+		ExpectedEmptySince time.Time
+		ExpectedWarnedAt   time.Time
+		ExpectedButtons    []string
+		ExpectedStops      int
+	}
+	now := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	testcases := []testcase{
+		{
+			Name:       "players online",
+			Attributes: statestorageinterface.StateAttributes{Players: testPlayers("player1"), Status: "listening"},
+		},
+		{
+			Name:               "empty without empty since",
+			Attributes:         statestorageinterface.StateAttributes{Status: "listening"},
+			ExpectedEmptySince: testTime("2024-10-05T22:00:00Z"),
+		},
+		{
+			Name:               "empty for a while",
+			Attributes:         statestorageinterface.StateAttributes{Status: "listening", EmptySince: testTime("2024-10-05T21:50:00Z")},
+			ExpectedEmptySince: testTime("2024-10-05T21:50:00Z"),
+		},
+		{
+			Name:               "about to stop",
+			Attributes:         statestorageinterface.StateAttributes{Status: "listening", EmptySince: testTime("2024-10-05T21:34:00Z")},
+			ExpectedEmptySince: testTime("2024-10-05T21:34:00Z"),
+			ExpectedWarnedAt:   testTime("2024-10-05T22:00:00Z"),
+			ExpectedButtons:    []string{"idle:cancel:1728164040"},
+		},
+		{
+			Name:               "already warned",
+			Attributes:         statestorageinterface.StateAttributes{Status: "listening", EmptySince: testTime("2024-10-05T21:34:00Z"), IdleWarnedAt: testTime("2024-10-05T21:59:00Z")},
+			ExpectedEmptySince: testTime("2024-10-05T21:34:00Z"),
+			ExpectedWarnedAt:   testTime("2024-10-05T21:59:00Z"),
+		},
+		{
+			Name:               "idle timeout reached",
+			Attributes:         statestorageinterface.StateAttributes{Status: "listening", EmptySince: testTime("2024-10-05T21:30:00Z"), IdleWarnedAt: testTime("2024-10-05T21:55:00Z")},
+			ExpectedEmptySince: testTime("2024-10-05T21:30:00Z"),
+			ExpectedWarnedAt:   testTime("2024-10-05T21:55:00Z"),
+			ExpectedStops:      1,
+		},
+		{
+			Name:               "world with a longer timeout",
+			Attributes:         statestorageinterface.StateAttributes{Status: "listening", EmptySince: testTime("2024-10-05T21:30:00Z"), IdleTimeout: "2h"},
+			ExpectedEmptySince: testTime("2024-10-05T21:30:00Z"),
+		},
+		{
+			Name:               "world with idle stop disabled",
+			Attributes:         statestorageinterface.StateAttributes{Status: "listening", EmptySince: testTime("2024-10-05T12:00:00Z"), IdleTimeout: "0"},
+			ExpectedEmptySince: testTime("2024-10-05T12:00:00Z"),
+		},
+		{
+			Name:               "unhealthy without empty since",
+			Attributes:         statestorageinterface.StateAttributes{Status: "unhealthy"},
+			ExpectedEmptySince: testTime("2024-10-05T22:00:00Z"),
+		},
+		{
+			Name:               "unhealthy for the whole timeout",
+			Attributes:         statestorageinterface.StateAttributes{Status: "unhealthy", EmptySince: testTime("2024-10-05T21:30:00Z"), IdleWarnedAt: testTime("2024-10-05T21:55:00Z")},
+			ExpectedEmptySince: testTime("2024-10-05T21:30:00Z"),
+			ExpectedWarnedAt:   testTime("2024-10-05T21:55:00Z"),
+			ExpectedStops:      1,
+		},
+		{
+			Name:       "not listening",
+			Attributes: statestorageinterface.StateAttributes{Status: "stopped"},
+		},
+	}
+	for _, tc := range testcases {
+		disclient := TestDiscordClient{}
+		state := newTestState(tc.Attributes, newTestStorage(""))
+		ah := newActionHandler(nil, &disclient, &TestVmssClient{}, TestSteamClient{}, state)
+		stops := 0
+		if err := ah.checkIdle(now, DefaultIdleConfig, func() error { stops++; return nil }); err != nil {
+			t.Errorf("%s - error checking idle: %v", tc.Name, err)
+		}
+		if !state.Attributes.EmptySince.Equal(tc.ExpectedEmptySince) || !state.Attributes.IdleWarnedAt.Equal(tc.ExpectedWarnedAt) {
+			t.Errorf("%s - expected empty since %v warned at %v but was %v warned at %v", tc.Name, tc.ExpectedEmptySince, tc.ExpectedWarnedAt, state.Attributes.EmptySince, state.Attributes.IdleWarnedAt)
+		}
+		if !reflect.DeepEqual(disclient.buttonsSent, tc.ExpectedButtons) {
+			t.Errorf("%s - expected buttons %v but sent %v", tc.Name, tc.ExpectedButtons, disclient.buttonsSent)
+		}
+		if stops != tc.ExpectedStops {
+			t.Errorf("%s - expected %d stops but enqueued %d", tc.Name, tc.ExpectedStops, stops)
+		}
+	}
+}
+
+func TestCheckIdleStopsOnce(t *testing.T) {
+	disclient := TestDiscordClient{}
+	state := newTestState(
+		statestorageinterface.StateAttributes{Status: "listening", EmptySince: testTime("2024-10-05T21:30:00Z"), IdleWarnedAt: testTime("2024-10-05T21:55:00Z")},
+		newTestStorage(""),
+	)
+	ah := newActionHandler(nil, &disclient, &TestVmssClient{}, TestSteamClient{}, state)
+	stops := 0
+	// the stop event is still queued when the next ticks come
+	for _, now := range []time.Time{testTime("2024-10-05T22:00:00Z"), testTime("2024-10-05T22:01:00Z"), testTime("2024-10-05T22:02:00Z")} {
+		if err := ah.checkIdle(now, DefaultIdleConfig, func() error { stops++; return nil }); err != nil {
+			t.Errorf("%s - error checking idle: %v", now, err)
+		}
+	}
+	if stops != 1 || len(disclient.messagesSent) != 1 {
+		t.Errorf("expected a single stop and message but enqueued %d and sent %v", stops, disclient.messagesSent)
+	}
+	if !state.Attributes.IdleStoppedAt.Equal(testTime("2024-10-05T22:00:00Z")) {
+		t.Errorf("expected idle stopped at the first tick but was %v", state.Attributes.IdleStoppedAt)
+	}
+
+	// a failed enqueue is retried on the next tick
+	state.Attributes.IdleStoppedAt = time.Time{}
+	failed := ah.checkIdle(testTime("2024-10-05T22:03:00Z"), DefaultIdleConfig, func() error { return fmt.Errorf("queue unavailable") })
+	if failed == nil || !state.Attributes.IdleStoppedAt.IsZero() {
+		t.Errorf("expected the failed enqueue to be returned and cleared but got %v with idle stopped at %v", failed, state.Attributes.IdleStoppedAt)
+	}
+	if err := ah.checkIdle(testTime("2024-10-05T22:04:00Z"), DefaultIdleConfig, func() error { stops++; return nil }); err != nil || stops != 2 {
+		t.Errorf("expected the stop to be enqueued again but got %d stops (%v)", stops, err)
+	}
+
+	// players joining start counting again
+	resetIdle(state, testTime("2024-10-05T22:05:00Z"))
+	if !state.Attributes.IdleStoppedAt.IsZero() {
+		t.Errorf("expected idle stopped at to be cleared but was %v", state.Attributes.IdleStoppedAt)
+	}
+}
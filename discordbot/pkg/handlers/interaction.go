@@ -1,85 +1,68 @@
 package handlers
 
 import (
-	"bytes"
-	"crypto/ed25519"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"godin/pkg/azqclient"
-	"io"
+	"godin/pkg/discinteraction"
+	"godin/pkg/events"
+	"godin/pkg/godinerrors"
+	"godin/pkg/history"
+	"godin/pkg/queue"
+	"godin/pkg/signature"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/valheimstate"
 	"log"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 )
 
-// Interaction types from Discord API
+// valheimPort is the game port players connect to
+const valheimPort = "2456"
+
+// Interaction response types
 const (
-	InteractionPing    = 1
-	InteractionCommand = 2
+	ResponsePong               = 1
+	ResponseChannelMsg         = 4
+	ResponseDeferredChannelMsg = 5
+	ResponseUpdateMessage      = 7
 )
 
-// Interaction response types
+// Message flags
 const (
-	ResponsePong       = 1
-	ResponseChannelMsg = 4
+	MessageFlagEphemeral = 1 << 6
 )
 
 type InteractionOutput struct {
 	Outputs map[string]interface{} `json:"outputs,omitempty"`
 }
 
-// Interaction structure to parse JSON payload from Discord
-type Interaction struct {
-	Type int `json:"type"`
-	Data struct {
-		Name string `json:"name"`
-	} `json:"data"`
+// InteractionHandler is the interactions API discord sends slash commands to
+type InteractionHandler struct {
+	verifier signature.Verifier
+	// events is the queue actions are handed over to the reaction function through
+	events   queue.Queue
+	backends Backends
 }
 
-// PublicKey to verify the request signature (stored as an environment variable)
-var discordPublicKey = os.Getenv("DISCORD_PUBLIC_KEY")
-
-// Verify request signature function
-func verifyRequest(r *http.Request) bool {
-	signature := r.Header.Get("X-Signature-Ed25519")
-	timestamp := r.Header.Get("X-Signature-Timestamp")
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		return false
-	}
-
-	// Re-attach the body for reading later
-	r.Body = io.NopCloser(bytes.NewBuffer(body))
-
-	message := append([]byte(timestamp), body...)
-	decodedSig, err := hexDecodeString(signature)
-	if err != nil {
-		return false
+func NewInteractionHandler(verifier signature.Verifier, events queue.Queue, backends Backends) *InteractionHandler {
+	return &InteractionHandler{
+		verifier: verifier,
+		events:   events,
+		backends: backends,
 	}
-
-	decodedPubKey, err := hexDecodeString(discordPublicKey)
-	if err != nil {
-		return false
-	}
-
-	return ed25519.Verify(decodedPubKey, message, decodedSig)
-}
-
-// Hex decoding utility
-func hexDecodeString(s string) ([]byte, error) {
-	return hex.DecodeString(s)
 }
 
-func InteractionHandler(w http.ResponseWriter, r *http.Request) {
+func (ih *InteractionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Verify request
-	if os.Getenv("COMPUTERNAME") != "RODSPC" && !verifyRequest(r) {
+	if err := ih.verifier.Verify(r); err != nil {
+		log.Printf("Rejected interaction request: %v", err)
 		http.Error(w, "Invalid request signature", http.StatusUnauthorized)
 		return
 	}
 
 	// Parse the interaction
-	var interaction Interaction
+	var interaction discinteraction.Interaction
 	err := json.NewDecoder(r.Body).Decode(&interaction)
 	if err != nil {
 		log.Printf("Error decoding JSON: %v", err)
@@ -91,32 +74,86 @@ func InteractionHandler(w http.ResponseWriter, r *http.Request) {
 	// Handle the interaction based on type
 	response := map[string]interface{}{}
 	switch interaction.Type {
-	case InteractionPing:
+	case discinteraction.InteractionPing:
 		log.Println("Received ping validation")
 		response = map[string]interface{}{
 			"type": ResponsePong,
 		}
-	case InteractionCommand:
-		log.Printf("Received command: %s", interaction.Data.Name)
-		switch interaction.Data.Name {
+	case discinteraction.InteractionCommand:
+		command := interaction.Command()
+		invoker := interaction.Invoker()
+		log.Printf("Received command: %s from user %s", command.Path(), invoker.ID)
+		policy, err := ih.backends.loadPolicy()
+		if err != nil {
+			log.Printf("Error loading permissions policy: %v", err)
+			response = responseEphemeralMsg("Failed to check your permissions, try again later")
+			break
+		}
+		if !policy.Allowed(command.Path(), invoker.ID, interaction.Roles()) {
+			log.Printf("Denied command %s for user %s (%s) with roles %v", command.Path(), invoker.ID, invoker.Username, interaction.Roles())
+			response = responseEphemeralMsg(fmt.Sprintf("You are not allowed to run `/%s`, ask an admin for the required role", command.Path()))
+			break
+		}
+		switch command.Name {
 		case "ping":
 			response = responseChannelMsg("Pong!")
 		case "start", "stop":
-			azqclient, err := azqclient.NewQueueClient("events")
+			state, err := ih.backends.loadState()
 			if err != nil {
-				log.Printf("Error creating queue client: %v", err)
-				http.Error(w, fmt.Sprintf("Error creating queue client: %v", err), http.StatusInternalServerError)
-				return
+				log.Printf("Error loading state: %v", err)
+				response = responseChannelMsg("Failed to read the Valheim server state")
+				break
+			}
+			// the reaction handler checks the transition again, the status can change while the event is queued
+			if reason := transitionRejection(state.GetStatus(), command.Name); reason != "" {
+				response = responseEphemeralMsg(reason)
+				break
+			}
+			// stopping while people are playing needs a confirmation click, see component.go
+			if players := onlinePlayers(state); command.Name == "stop" && len(players) != 0 {
+				response = responseStopConfirmation(state, invoker.ID, players, time.Now())
+				break
 			}
-			if err = azqclient.EnqueueMessage(interaction.Data.Name); err != nil {
-				log.Printf("Error enqueuing message: %v", err)
-				http.Error(w, fmt.Sprintf("Error enqueuing message: %v", err), http.StatusInternalServerError)
+			if err := ih.enqueueAction(events.Type(command.Name), interaction); err != nil {
+				log.Printf("Error enqueuing action: %v", err)
 				response = responseChannelMsg("Failed to queue the action")
 				break
 			}
-			response = responseChannelMsg(fmt.Sprintf("Will %s the Valheim server", interaction.Data.Name))
+			// the reaction function edits this response as the action progresses
+			response = map[string]interface{}{
+				"type": ResponseDeferredChannelMsg,
+			}
+		case "status":
+			// status is answered straight from the state table, going through the events queue
+			// would never make it within discord's 3 seconds response window
+			state, err := ih.backends.loadState()
+			if err != nil {
+				log.Printf("Error loading state: %v", err)
+				response = responseChannelMsg("Failed to read the Valheim server state")
+				break
+			}
+			response = responseChannelMsg(statusMessage(state, time.Now()))
+		case "history":
+			response = ih.historyResponse("", false)
+		case "sessions":
+			response = ih.sessionsResponse(time.Now())
+		case "leaderboard":
+			response = ih.leaderboardResponse()
+		case "whois":
+			response = ih.whoisResponse(command)
+		case "replay":
+			response = ih.replayPoisonedEvent(interaction)
 		default:
-			response = responseChannelMsg(fmt.Sprintf("Unknown command: %s", interaction.Data.Name))
+			response = responseChannelMsg(fmt.Sprintf("Unknown command: %s", command.Path()))
+		}
+	case discinteraction.InteractionMessageComponent:
+		log.Printf("Received component click: %s from user %s", interaction.Data.CustomID, interaction.Invoker().ID)
+		if strings.HasPrefix(interaction.Data.CustomID, "idle:") {
+			response = ih.handleIdleCancel(interaction, time.Now())
+		} else if strings.HasPrefix(interaction.Data.CustomID, "history:") {
+			response = ih.handleHistoryPage(interaction)
+		} else {
+			response = ih.handleStopConfirmation(interaction, time.Now())
 		}
 	}
 
@@ -136,6 +173,17 @@ type InteractionResponse struct {
 	ReturnValue string                 `json:"returnValue"`
 }
 
+// responseEphemeralMsg responds with a message only the invoker can see
+func responseEphemeralMsg(msg string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": ResponseChannelMsg,
+		"data": map[string]interface{}{
+			"content": msg,
+			"flags":   MessageFlagEphemeral,
+		},
+	}
+}
+
 func responseChannelMsg(msg string) map[string]interface{} {
 	return map[string]interface{}{
 		"type": ResponseChannelMsg,
@@ -144,3 +192,77 @@ func responseChannelMsg(msg string) map[string]interface{} {
 		},
 	}
 }
+
+// enqueueAction puts an action on the events queue along with who requested it and the token of their interaction
+func (ih *InteractionHandler) enqueueAction(eventType events.Type, interaction discinteraction.Interaction) error {
+	invoker := interaction.Invoker()
+	event, err := events.New(eventType, events.SourceInteractions, &events.Requester{
+		UserId:           invoker.ID,
+		Username:         invoker.Username,
+		InteractionToken: interaction.Token,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	message, err := events.Encode(event)
+	if err != nil {
+		return err
+	}
+	log.Printf("Enqueuing %s event %s", event.Type, event.CorrelationId)
+	return ih.events.Enqueue(message)
+}
+
+// interactionCause is the cause of the state changes an interaction makes without going through the events queue
+func interactionCause(eventType string, interaction discinteraction.Interaction) history.Cause {
+	invoker := interaction.Invoker()
+	return history.Cause{
+		EventType:   eventType,
+		Source:      events.SourceInteractions,
+		RequesterId: invoker.ID,
+		Requester:   invoker.Username,
+	}
+}
+
+// transitionRejection tells why the start or stop command can't run in the current status, empty if it can
+func transitionRejection(status, command string) string {
+	target := valheimstate.Starting
+	if command == "stop" {
+		target = valheimstate.Stopping
+	}
+	if err := valheimstate.CheckTransition(status, target); err != nil {
+		return transitionMessage(command, err.(godinerrors.TransitionError))
+	}
+	return ""
+}
+
+// transitionMessage explains a rejected start or stop to its requester
+func transitionMessage(command string, err godinerrors.TransitionError) string {
+	if err.Code == godinerrors.DuplicateTransitionError {
+		return fmt.Sprintf("Valheim server is already `%s`", err.To)
+	}
+	return fmt.Sprintf("Can't %s the Valheim server while it is `%s`", command, err.From)
+}
+
+func statusMessage(state statestorageinterface.StateInterface, now time.Time) string {
+	status := state.GetStatus()
+	if status == "" {
+		status = "stopped"
+	}
+	lines := []string{}
+	since := state.GetStatusSince()
+	if since.IsZero() {
+		lines = append(lines, fmt.Sprintf("Valheim server is `%s`", status))
+	} else {
+		lines = append(lines, fmt.Sprintf("Valheim server is `%s` for %s", status, now.Sub(since).Truncate(time.Second)))
+	}
+	if ip := state.GetIp(); ip != "" && status != "stopped" {
+		lines = append(lines, fmt.Sprintf("Connect address: `%s:%s`", ip, valheimPort))
+	}
+	players := onlinePlayers(state)
+	if len(players) == 0 {
+		lines = append(lines, "No players online")
+	} else {
+		lines = append(lines, fmt.Sprintf("Online players (%d): %s", len(players), strings.Join(players, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
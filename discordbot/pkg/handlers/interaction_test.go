@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"godin/pkg/aztclient"
+	"godin/pkg/discinteraction"
+	"godin/pkg/events"
+	"godin/pkg/memtclient"
+	"godin/pkg/queue"
+	"godin/pkg/signature"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/valheimstate"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusMessage(t *testing.T) {
+	type testcase struct {
+		Name            string
+		Attributes      statestorageinterface.StateAttributes
+		ExpectedMessage string
+	}
+	now := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	testcases := []testcase{
+		{
+			Name:            "never started",
+			Attributes:      statestorageinterface.StateAttributes{},
+			ExpectedMessage: "Valheim server is `stopped`\nNo players online",
+		},
+		{
+			Name: "stopped keeps last ip hidden",
+			Attributes: statestorageinterface.StateAttributes{
+				Ip:          "192.168.0.1",
+				Status:      "stopped",
+				StatusSince: testTime("2024-10-05T21:30:00Z"),
+			},
+			ExpectedMessage: "Valheim server is `stopped` for 30m0s\nNo players online",
+		},
+		{
+			Name: "listening with players",
+			Attributes: statestorageinterface.StateAttributes{
+				Ip:          "192.168.0.1",
+				Players:     testPlayers("player1,player2"),
+				Status:      "listening",
+				StatusSince: testTime("2024-10-05T19:45:10Z"),
+			},
+			ExpectedMessage: "Valheim server is `listening` for 2h14m50s\nConnect address: `192.168.0.1:2456`\nOnline players (2): player1, player2",
+		},
+	}
+	for _, tc := range testcases {
+		state := &valheimstate.State{Attributes: tc.Attributes}
+		msg := statusMessage(state, now)
+		if msg != tc.ExpectedMessage {
+			t.Errorf("%s - expected message to be %q but was %q", tc.Name, tc.ExpectedMessage, msg)
+		}
+	}
+}
+
+func TestInteractionSignature(t *testing.T) {
+	signer, err := signature.NewSigner()
+	if err != nil {
+		t.Fatalf("error creating signer: %v", err)
+	}
+	handler := NewInteractionHandler(signer.Verifier(signature.DefaultMaxAge), queue.NewMemoryQueue(), Backends{})
+	body := []byte(`{"type":1}`)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/interactions", bytes.NewReader(body))
+	signer.Sign(req, body)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"type":1}` {
+		t.Errorf("expected signed ping to be answered with pong but got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/interactions", bytes.NewReader(body))
+	signer.SignAt(req, body, time.Now().Add(-time.Hour))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected replayed ping to be rejected but got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/interactions", bytes.NewReader(body))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected unsigned ping to be rejected but got %d", rec.Code)
+	}
+}
+
+func TestEnqueueAction(t *testing.T) {
+	eventsQueue := queue.NewMemoryQueue()
+	handler := NewInteractionHandler(nil, eventsQueue, Backends{})
+	interaction := discinteraction.Interaction{
+		Token: "token1",
+		User:  &discinteraction.User{ID: "100", Username: "viking"},
+	}
+	if err := handler.enqueueAction(events.Start, interaction); err != nil {
+		t.Fatalf("error enqueuing action: %v", err)
+	}
+	msg, err := eventsQueue.Dequeue(time.Minute)
+	if err != nil || msg == nil {
+		t.Fatalf("expected an event on the queue but got %v (%v)", msg, err)
+	}
+	event, err := events.Decode(msg.Text)
+	if err != nil {
+		t.Fatalf("error decoding queued event: %v", err)
+	}
+	if event.Type != events.Start || event.InteractionToken() != "token1" || event.Requester.UserId != "100" {
+		t.Errorf("expected start event requested by 100 with token1 but was %+v", event)
+	}
+}
+
+func TestTransitionRejection(t *testing.T) {
+	type testcase struct {
+		Status         string
+		Command        string
+		ExpectedReason string
+	}
+	testcases := []testcase{
+		{Status: "", Command: "start", ExpectedReason: ""},
+		{Status: "listening", Command: "stop", ExpectedReason: ""},
+		{Status: "listening", Command: "start", ExpectedReason: "Can't start the Valheim server while it is `listening`"},
+		{Status: "starting", Command: "start", ExpectedReason: "Valheim server is already `starting`"},
+		{Status: "", Command: "stop", ExpectedReason: "Can't stop the Valheim server while it is `stopped`"},
+	}
+	for _, tc := range testcases {
+		if reason := transitionRejection(tc.Status, tc.Command); reason != tc.ExpectedReason {
+			t.Errorf("%s %s - expected reason %q but was %q", tc.Status, tc.Command, tc.ExpectedReason, reason)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	type testcase struct {
+		Name            string
+		PolicyJson      string
+		ExpectedAllowed bool
+	}
+	testcases := []testcase{
+		// worlds upgraded from before policies existed have none stored
+		{Name: "no stored policy", PolicyJson: "", ExpectedAllowed: true},
+		{Name: "stored policy without start rule", PolicyJson: `{"commands":{"status":{"users":["100"]}}}`, ExpectedAllowed: false},
+		{Name: "stored policy with start rule", PolicyJson: `{"commands":{"start":{"users":["1"]}}}`, ExpectedAllowed: true},
+	}
+	for _, tc := range testcases {
+		table := memtclient.NewTable()
+		if tc.PolicyJson != "" {
+			table.Put(permissionsPartitionKey, "world", map[string]interface{}{"policy": tc.PolicyJson})
+		}
+		backends := Backends{
+			TableClient: func(partitionKey string) (aztclient.TableClientInterface, error) {
+				return memtclient.NewTableClient(table, partitionKey, "world"), nil
+			},
+		}
+		policy, err := backends.loadPolicy()
+		if err != nil {
+			t.Fatalf("%s - error loading policy: %v", tc.Name, err)
+		}
+		if allowed := policy.Allowed("start", "1", nil); allowed != tc.ExpectedAllowed {
+			t.Errorf("%s - expected start to be allowed %t but was %t", tc.Name, tc.ExpectedAllowed, allowed)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fmt"
+	"godin/pkg/discinteraction"
+	"godin/pkg/playerstats"
+	"log"
+	"strings"
+	"time"
+)
+
+// leaderboardSize is how many players /leaderboard lists
+const leaderboardSize = 10
+
+// leaderboardResponse lists the players with the most playtime
+func (ih *InteractionHandler) leaderboardResponse() map[string]interface{} {
+	store := ih.backends.playerStats()
+	if store == nil {
+		return responseChannelMsg("Player stats aren't recorded on this server")
+	}
+	leaders, err := store.Leaderboard(leaderboardSize)
+	if err != nil {
+		log.Printf("Error reading player stats: %v", err)
+		return responseChannelMsg("Failed to read the player stats")
+	}
+	return responseChannelMsg(leaderboardMessage(leaders))
+}
+
+func leaderboardMessage(leaders []playerstats.Stats) string {
+	if len(leaders) == 0 {
+		return "No playtime recorded yet"
+	}
+	lines := []string{"**Leaderboard**"}
+	for i, stats := range leaders {
+		lines = append(lines, fmt.Sprintf("%d. %s: %s in %d sessions, longest %s", i+1, stats.Name, stats.Playtime.Truncate(time.Second), stats.Sessions, stats.LongestSession.Truncate(time.Second)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// whoisResponse shows the stats of the player option, a steam name or id
+func (ih *InteractionHandler) whoisResponse(command discinteraction.Command) map[string]interface{} {
+	player, ok := command.Options.String("player")
+	if !ok || player == "" {
+		return responseEphemeralMsg("Which player? Give a steam name or id")
+	}
+	store := ih.backends.playerStats()
+	if store == nil {
+		return responseChannelMsg("Player stats aren't recorded on this server")
+	}
+	stats, found, err := store.Find(player)
+	if err != nil {
+		log.Printf("Error reading player stats: %v", err)
+		return responseChannelMsg("Failed to read the player stats")
+	}
+	if !found {
+		return responseEphemeralMsg(fmt.Sprintf("No player `%s` joined the server yet", player))
+	}
+	// a play session is only counted when it ends, the state tells whether one is going on
+	var onlineSince time.Time
+	if state, err := ih.backends.loadState(); err != nil {
+		log.Printf("Error loading state: %v", err)
+	} else {
+		for _, online := range state.GetPlayers() {
+			if online.SteamId == stats.SteamId {
+				onlineSince = online.JoinedAt
+			}
+		}
+	}
+	return responseChannelMsg(whoisMessage(stats, onlineSince))
+}
+
+func whoisMessage(stats playerstats.Stats, onlineSince time.Time) string {
+	lines := []string{
+		fmt.Sprintf("**%s** (`%s`)", stats.Name, stats.SteamId),
+		fmt.Sprintf("Playtime: %s in %d sessions, longest %s", stats.Playtime.Truncate(time.Second), stats.Sessions, stats.LongestSession.Truncate(time.Second)),
+		fmt.Sprintf("First seen <t:%d:f>, last seen <t:%d:R>", stats.FirstSeen.Unix(), stats.LastSeen.Unix()),
+	}
+	if !onlineSince.IsZero() {
+		lines = append(lines, fmt.Sprintf("Online since <t:%d:R>", onlineSince.Unix()))
+	}
+	return strings.Join(lines, "\n")
+}
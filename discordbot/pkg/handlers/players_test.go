@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"godin/pkg/aztclient"
+	"godin/pkg/discinteraction"
+	"godin/pkg/disclient"
+	"godin/pkg/steamapi"
+	"godin/pkg/vmssclient"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlayerStats(t *testing.T) {
+	storage, err := StorageConfig{Backend: StorageMemory, RowKey: "world"}.Open()
+	if err != nil {
+		t.Fatalf("error opening storage: %v", err)
+	}
+	backends := Backends{
+		TableClient: storage.TableClient,
+		Partition:   storage.Partition,
+		Discord:     func() (disclient.DiscordClientInterface, error) { return &TestDiscordClient{}, nil },
+		Vmss:        func(ip string) (vmssclient.VmssClientInterface, error) { return &TestVmssClient{}, nil },
+		Steam:       func() steamapi.ClientInterface { return TestSteamClient{} },
+	}
+	registry := NewReactionHandler(FallbackLog, backends).registry
+	actions := []string{
+		"start",
+		"Server is now listening",
+		"Got connection SteamID 76561198073103840",
+		"Got connection SteamID 76561198073103841",
+		"Closing socket 76561198073103840",
+		"Got connection SteamID 76561198073103840",
+		// the evicted vm never sends the leaves, the eviction ends the play sessions
+		`{"version":1,"type":"evicted","source":"vm","correlation_id":"id1"}`,
+	}
+	for _, action := range actions {
+		ah, err := backends.newActionHandler(registry)
+		if err != nil {
+			t.Fatalf("%s - error creating action handler: %v", action, err)
+		}
+		if err := ah.handleAction(action); err != nil {
+			t.Fatalf("%s - error handling action: %v", action, err)
+		}
+	}
+
+	leaders, err := backends.playerStats().Leaderboard(leaderboardSize)
+	if err != nil {
+		t.Fatalf("error reading the leaderboard: %v", err)
+	}
+	sessions := map[string]int{}
+	for _, stats := range leaders {
+		sessions[stats.Name] = stats.Sessions
+		if stats.FirstSeen.IsZero() || stats.LastSeen.Before(stats.FirstSeen) {
+			t.Errorf("expected %s to have been seen but was %+v", stats.Name, stats)
+		}
+	}
+	if sessions["player1"] != 2 || sessions["player2"] != 1 || len(sessions) != 2 {
+		t.Errorf("expected the two sessions of player1 and the one of player2 to be counted but were %v", sessions)
+	}
+
+	handler := NewInteractionHandler(nil, nil, backends)
+	content := handler.leaderboardResponse()["data"].(map[string]string)["content"]
+	if !strings.HasPrefix(content, "**Leaderboard**\n1. ") || !strings.Contains(content, "player2: ") {
+		t.Errorf("expected the players to be listed but was %q", content)
+	}
+	var interaction discinteraction.Interaction
+	json.Unmarshal([]byte(`{"type":2,"data":{"name":"whois","options":[{"type":3,"name":"player","value":"Player2"}]}}`), &interaction)
+	content = handler.whoisResponse(interaction.Command())["data"].(map[string]string)["content"]
+	if !strings.HasPrefix(content, "**player2** (`76561198073103841`)\nPlaytime: ") || !strings.Contains(content, " in 1 sessions, longest ") || strings.Contains(content, "Online since") {
+		t.Errorf("expected the stats of player2 but was %q", content)
+	}
+	json.Unmarshal([]byte(`{"type":2,"data":{"name":"whois","options":[{"type":3,"name":"player","value":"player3"}]}}`), &interaction)
+	content = handler.whoisResponse(interaction.Command())["data"].(map[string]interface{})["content"].(string)
+	if content != "No player `player3` joined the server yet" {
+		t.Errorf("expected player3 not to be found but was %q", content)
+	}
+}
+
+func TestPlayerLeftAt(t *testing.T) {
+	storage, err := StorageConfig{Backend: StorageMemory, RowKey: "world"}.Open()
+	if err != nil {
+		t.Fatalf("error opening storage: %v", err)
+	}
+	backends := Backends{
+		TableClient: storage.TableClient,
+		Partition:   storage.Partition,
+		Discord:     func() (disclient.DiscordClientInterface, error) { return &TestDiscordClient{}, nil },
+		Vmss:        func(ip string) (vmssclient.VmssClientInterface, error) { return &TestVmssClient{}, nil },
+		Steam:       func() steamapi.ClientInterface { return TestSteamClient{} },
+	}
+	registry := NewReactionHandler(FallbackLog, backends).registry
+	// the leave is handled long after it happened, the playtime ends with the event
+	actions := []string{
+		"start",
+		"Server is now listening",
+		`{"version":1,"type":"player_joined","source":"vm","timestamp":"2024-10-05T22:00:00Z","correlation_id":"id1","payload":{"steam_id":"76561198073103840"}}`,
+		`{"version":1,"type":"player_left","source":"vm","timestamp":"2024-10-05T23:30:00Z","correlation_id":"id2","payload":{"steam_id":"76561198073103840"}}`,
+	}
+	for _, action := range actions {
+		ah, err := backends.newActionHandler(registry)
+		if err != nil {
+			t.Fatalf("%s - error creating action handler: %v", action, err)
+		}
+		if err := ah.handleAction(action); err != nil {
+			t.Fatalf("%s - error handling action: %v", action, err)
+		}
+	}
+	stats, found, err := backends.playerStats().Find("player1")
+	if err != nil || !found {
+		t.Fatalf("expected to find player1 but got %v, %v", found, err)
+	}
+	if stats.Playtime != 90*time.Minute || !stats.LastSeen.Equal(testTime("2024-10-05T23:30:00Z")) {
+		t.Errorf("expected 1h30m played until the leave event but was %+v", stats)
+	}
+}
+
+// FlakyPartitionClient fails the first failures writes
+type FlakyPartitionClient struct {
+	aztclient.PartitionClientInterface
+	failures int
+}
+
+func (fpc *FlakyPartitionClient) Put(row aztclient.Row) error {
+	if fpc.failures > 0 {
+		fpc.failures--
+		return fmt.Errorf("storage unavailable")
+	}
+	return fpc.PartitionClientInterface.Put(row)
+}
+
+func TestPlayerLeftRetried(t *testing.T) {
+	storage, err := StorageConfig{Backend: StorageMemory, RowKey: "world"}.Open()
+	if err != nil {
+		t.Fatalf("error opening storage: %v", err)
+	}
+	players, err := storage.Partition(playersPartitionKey)
+	if err != nil {
+		t.Fatalf("error opening player stats: %v", err)
+	}
+	flaky := &FlakyPartitionClient{PartitionClientInterface: players}
+	backends := Backends{
+		TableClient: storage.TableClient,
+		Partition: func(partitionKey string) (aztclient.PartitionClientInterface, error) {
+			if partitionKey == playersPartitionKey {
+				return flaky, nil
+			}
+			return storage.Partition(partitionKey)
+		},
+		Discord: func() (disclient.DiscordClientInterface, error) { return &TestDiscordClient{}, nil },
+		Vmss:    func(ip string) (vmssclient.VmssClientInterface, error) { return &TestVmssClient{}, nil },
+		Steam:   func() steamapi.ClientInterface { return TestSteamClient{} },
+	}
+	registry := NewReactionHandler(FallbackLog, backends).registry
+	left := `{"version":1,"type":"player_left","source":"vm","timestamp":"2024-10-05T23:30:00Z","correlation_id":"id2","payload":{"steam_id":"76561198073103840"}}`
+	actions := []string{
+		"start",
+		"Server is now listening",
+		`{"version":1,"type":"player_joined","source":"vm","timestamp":"2024-10-05T22:00:00Z","correlation_id":"id1","payload":{"steam_id":"76561198073103840"}}`,
+	}
+	for _, action := range actions {
+		ah, err := backends.newActionHandler(registry)
+		if err != nil {
+			t.Fatalf("%s - error creating action handler: %v", action, err)
+		}
+		if err := ah.handleAction(action); err != nil {
+			t.Fatalf("%s - error handling action: %v", action, err)
+		}
+	}
+	// the stats can't be written, the leave fails and stays unsaved until the queue delivers it again
+	flaky.failures = 1
+	ah, err := backends.newActionHandler(registry)
+	if err != nil {
+		t.Fatalf("error creating action handler: %v", err)
+	}
+	if err := ah.handleAction(left); err == nil {
+		t.Fatalf("expected the leave to fail while its stats can't be written")
+	}
+	if state, err := backends.loadState(); err != nil || len(state.GetPlayers()) != 1 {
+		t.Fatalf("expected the player to stay online until the leave is recorded but got %v (%v)", state, err)
+	}
+	ah, err = backends.newActionHandler(registry)
+	if err != nil {
+		t.Fatalf("error creating action handler: %v", err)
+	}
+	if err := ah.handleAction(left); err != nil {
+		t.Fatalf("error handling retried leave: %v", err)
+	}
+	stats, found, err := backends.playerStats().Find("player1")
+	if err != nil || !found {
+		t.Fatalf("expected to find player1 but got %v, %v", found, err)
+	}
+	if stats.Playtime != 90*time.Minute || stats.Sessions != 1 {
+		t.Errorf("expected the retried leave to count 1h30m once but was %+v", stats)
+	}
+}
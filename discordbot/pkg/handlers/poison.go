@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"godin/pkg/discinteraction"
+	"godin/pkg/events"
+	"godin/pkg/history"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/valheimstate"
+	"log"
+	"net/http"
+	"time"
+)
+
+// PoisonHandler is the queue-triggered function of the events-poison queue, where the functions host moves
+// events that failed maxDequeueCount times. It reports them to the admins and keeps them for /replay
+type PoisonHandler struct {
+	backends Backends
+}
+
+func NewPoisonHandler(backends Backends) *PoisonHandler {
+	return &PoisonHandler{
+		backends: backends,
+	}
+}
+
+func (ph *PoisonHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var triggerData triggerData
+	if err := json.NewDecoder(r.Body).Decode(&triggerData); err != nil {
+		setInternalServerErrorWithLogs(w, fmt.Errorf("error decoding request body: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	// poisoned events are reported, not dispatched, so there is no registry
+	ah, err := ph.backends.newActionHandler(nil)
+	if err != nil {
+		setInternalServerErrorWithLogs(w, err)
+		return
+	}
+	if err := ah.handlePoisoned(unquoteTriggerData(triggerData.Data.Action)); err != nil {
+		setInternalServerErrorWithLogs(w, fmt.Errorf("cought error: %v", err))
+		return
+	}
+
+	invokeResponse := invokeResponse{Logs: []string{}}
+	js, err := json.Marshal(invokeResponse)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// handlePoisoned marks start and stop requests that never went through as failed, tells their requester
+// and posts the event with the last recorded error to the admin channel
+func (ah *actionHandler) handlePoisoned(message string) error {
+	lastError := ah.state.GetLastError()
+	if lastError == "" {
+		lastError = "none recorded"
+	}
+	description := "undecodable event"
+	event, decodeErr := events.Decode(message)
+	if decodeErr == nil {
+		description = fmt.Sprintf("`%s` event %s from %s", event.Type, event.CorrelationId, event.Source)
+	}
+	log.Printf("Poisoned %s: %s", description, message)
+	ah.cause = history.Cause{EventType: "poisoned"}
+	if decodeErr == nil {
+		ah.cause = history.CauseOf(event)
+		ah.cause.EventType = "poisoned " + ah.cause.EventType
+		// the event failed for a while, its changes are made now
+		ah.cause.At = time.Time{}
+	}
+	if err := ah.commit(func(state statestorageinterface.StateInterface) error {
+		if decodeErr == nil && (event.Type == events.Start || event.Type == events.Stop) {
+			// a start or stop that gave up before changing the status leaves it as it was, Transition logs it
+			if err := state.Transition(valheimstate.Failed, event.CorrelationId); err == nil {
+				state.SetPendingInteraction("")
+			}
+		}
+		state.SetPoisonedEvent(message)
+		state.SetLastError("")
+		return nil
+	}); err != nil {
+		return err
+	}
+	adminMsg := fmt.Sprintf(":warning: Gave up on %s after all retries\nLast error: %s\n```json\n%s\n```\nRun `/replay` to enqueue it again", description, lastError, message)
+	if err := ah.discordClient.SendAdminMessage(adminMsg); err != nil {
+		return err
+	}
+	if decodeErr == nil && event.InteractionToken() != "" {
+		return ah.notify(event.InteractionToken(), fmt.Sprintf("Failed to %s the Valheim server, the admins were notified", event.Type))
+	}
+	return nil
+}
+
+// replayPoisonedEvent puts the last poisoned event back on the events queue
+func (ih *InteractionHandler) replayPoisonedEvent(interaction discinteraction.Interaction) map[string]interface{} {
+	state, err := ih.backends.loadState()
+	if err != nil {
+		log.Printf("Error loading state: %v", err)
+		return responseEphemeralMsg("Failed to read the Valheim server state")
+	}
+	message := state.GetPoisonedEvent()
+	if message == "" {
+		return responseEphemeralMsg("There is no failed event to replay")
+	}
+	if err := ih.events.Enqueue(message); err != nil {
+		log.Printf("Error enqueuing message: %v", err)
+		return responseEphemeralMsg("Failed to queue the event")
+	}
+	if err := valheimstate.UpdateRecorded(state, func(state statestorageinterface.StateInterface) error {
+		// a newer poisoned event may have replaced the one replayed
+		if state.GetPoisonedEvent() == message {
+			state.SetPoisonedEvent("")
+		}
+		return nil
+	}, ih.backends.recorder(interactionCause("replay", interaction))); err != nil {
+		// the event is already queued again, worst case /replay offers it a second time
+		log.Printf("Error clearing poisoned event: %v", err)
+	}
+	log.Printf("Replayed poisoned event: %s", message)
+	return responseEphemeralMsg("Replaying the failed event")
+}
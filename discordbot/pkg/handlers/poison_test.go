@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/valheimstate"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlePoisoned(t *testing.T) {
+	message := `{"version":1,"type":"start","source":"interactions","correlation_id":"id1","requester":{"user_id":"100","interaction_token":"token1"}}`
+	disclient := TestDiscordClient{}
+	storage := newTestStorage(`{"ip":"", "online_players": "", "status":"starting", "pending_interaction":"token1", "last_error":"2024-10-05T22:00:00Z: quota exceeded"}`)
+	state := valheimstate.NewValheimState(storage)
+	state.Load()
+	ah := newActionHandler(nil, &disclient, &TestVmssClient{}, TestSteamClient{}, state)
+	if err := ah.handlePoisoned(message); err != nil {
+		t.Fatalf("error handling poisoned event: %v", err)
+	}
+
+	validationState := valheimstate.NewValheimState(storage)
+	validationState.Load()
+	attributes := validationState.GetAttributes()
+	if attributes.StatusSince.IsZero() {
+		t.Errorf("expected the failed status to be timed")
+	}
+	attributes.StatusSince = time.Time{}
+	expected := statestorageinterface.StateAttributes{
+		Status:        "failed",
+		StatusEvent:   "id1",
+		PoisonedEvent: message,
+	}
+	if !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("expected state attributes to be %v but was %v", expected, attributes)
+	}
+	if len(disclient.adminMessagesSent) != 1 || !strings.Contains(disclient.adminMessagesSent[0], "quota exceeded") || !strings.Contains(disclient.adminMessagesSent[0], "id1") {
+		t.Errorf("expected an admin message with the event and its last error but sent %v", disclient.adminMessagesSent)
+	}
+	expectedEdits := []string{"token1: Failed to start the Valheim server, the admins were notified"}
+	if !reflect.DeepEqual(disclient.interactionEdits, expectedEdits) {
+		t.Errorf("expected interaction edits to be %v but were %v", expectedEdits, disclient.interactionEdits)
+	}
+	if len(disclient.messagesSent) != 0 {
+		t.Errorf("expected no channel messages but sent %v", disclient.messagesSent)
+	}
+}
@@ -3,16 +3,15 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"godin/pkg/aztclient"
 	"godin/pkg/disclient"
+	"godin/pkg/events"
+	"godin/pkg/history"
 	"godin/pkg/statestorageinterface"
 	"godin/pkg/steamapi"
 	"godin/pkg/valheimstate"
 	"godin/pkg/vmssclient"
 	"log"
-	"net"
 	"net/http"
-	"os"
 	"strings"
 )
 
@@ -22,7 +21,7 @@ type invokeResponse struct {
 
 type triggerData struct {
 	Data struct {
-		Action string `json:"action"`
+		Action json.RawMessage `json:"action"`
 	} `json:"Data"`
 }
 
@@ -38,7 +37,26 @@ func setInternalServerErrorWithLogs(w http.ResponseWriter, handlerErr error) {
 	w.Write(js)
 }
 
-func ReactionHandler(w http.ResponseWriter, r *http.Request) {
+// ReactionHandler is the queue-triggered function that reacts to events on the events queue
+type ReactionHandler struct {
+	registry *eventRegistry
+	backends Backends
+}
+
+// NewReactionHandler registers the handler of every event kind, events no handler matches go to
+// unknownEventFallback, see fallbackHandler
+func NewReactionHandler(unknownEventFallback string, backends Backends) *ReactionHandler {
+	registry := newEventRegistry(fallbackHandler(unknownEventFallback))
+	for _, handler := range defaultEventHandlers() {
+		registry.register(handler)
+	}
+	return &ReactionHandler{
+		registry: registry,
+		backends: backends,
+	}
+}
+
+func (rh *ReactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var triggerData triggerData
 	if err := json.NewDecoder(r.Body).Decode(&triggerData); err != nil {
 		setInternalServerErrorWithLogs(w, fmt.Errorf("error decoding request body: %v", err))
@@ -46,31 +64,15 @@ func ReactionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	storageclient, err := aztclient.NewTableClient(os.Getenv("STATE_STORAGE_NAME"), "valheim-vmss", os.Getenv("WORLD_NAME"))
+	ah, err := rh.backends.newActionHandler(rh.registry)
 	if err != nil {
-		setInternalServerErrorWithLogs(w, fmt.Errorf("error creating storageclient: %v", err))
+		setInternalServerErrorWithLogs(w, err)
 		return
 	}
-	state := valheimstate.NewValheimState(storageclient)
-	if err := state.Load(); err != nil {
-		setInternalServerErrorWithLogs(w, fmt.Errorf("error loading state: %v", err))
-		return
-	}
-	vmssclient, err := vmssclient.NewVmssClient(os.Getenv("VMSS_RESOURCE_GROUP_NAME"), os.Getenv("VMSS_NAME"), os.Getenv("AZURE_SUBSCRIPTION_ID"), state.GetIp())
-	if err != nil {
-		setInternalServerErrorWithLogs(w, fmt.Errorf("error creating vmssclient: %v", err))
-		return
-	}
-	discordclient, err := disclient.NewDiscordClient(os.Getenv("DISCORD_BOT_TOKEN"), os.Getenv("DISCORD_CHANNEL_ID"))
-	if err != nil {
-		setInternalServerErrorWithLogs(w, fmt.Errorf("error creating discordclient: %v", err))
-		return
-	}
-	steamclient := steamapi.NewClient(os.Getenv("STEAM_API_KEY"))
-
-	ah := newActionHandler(discordclient, vmssclient, steamclient, state)
 
-	if err := ah.handleAction(strings.Trim(triggerData.Data.Action, "\"")); err != nil {
+	message := unquoteTriggerData(triggerData.Data.Action)
+	if err := ah.handleAction(message); err != nil {
+		rh.backends.recordFailure(ah.cause, err)
 		setInternalServerErrorWithLogs(w, fmt.Errorf("cought error: %v", err))
 		return
 	}
@@ -85,109 +87,86 @@ func ReactionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(js)
 }
 
+// unquoteTriggerData undoes the json string encoding the functions host applies to queue messages,
+// json object messages may also be handed over as-is
+func unquoteTriggerData(data json.RawMessage) string {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		return strings.Trim(message, "\"")
+	}
+	return string(data)
+}
+
+// actionHandler holds what event handlers need to react to a single event
 type actionHandler struct {
+	registry      *eventRegistry
 	discordClient disclient.DiscordClientInterface
 	vmssClient    vmssclient.VmssClientInterface
 	steamClient   steamapi.ClientInterface
 	state         statestorageinterface.StateInterface
+	// steam names looked up while handling the event, so mutate and notify don't look them up twice
+	playerNames map[string]string
+	// records keep the state changes commit saves along with their cause
+	records records
+	cause   history.Cause
 }
 
 func newActionHandler(
+	registry *eventRegistry,
 	discordclient disclient.DiscordClientInterface,
 	vmssclient vmssclient.VmssClientInterface,
 	steamclient steamapi.ClientInterface,
 	state statestorageinterface.StateInterface,
 ) *actionHandler {
 	return &actionHandler{
+		registry:      registry,
 		discordClient: discordclient,
 		vmssClient:    vmssclient,
 		steamClient:   steamclient,
 		state:         state,
+		playerNames:   make(map[string]string),
 	}
 }
 
-func (ah *actionHandler) handleAction(action string) error {
-	if action == "start" {
-		ah.state.SetStatus("starting")
-		if err := ah.state.Save(); err != nil {
-			return err
-		}
-		if err := ah.discordClient.SendMessage("Starting Valheim server"); err != nil {
-			return err
-		}
-		if err := ah.vmssClient.ScaleUp(); err != nil {
-			return err
-		}
-		ah.state.SetStatus("started")
-		if err := ah.state.Save(); err != nil {
-			return err
-		}
-		if err := ah.discordClient.SendMessage("Valheim server started"); err != nil {
-			return err
-		}
-	} else if action == "stop" {
-		ah.state.SetStatus("stopping")
-		if err := ah.state.Save(); err != nil {
-			return err
-		}
-		if err := ah.discordClient.SendMessage("Stopping Valheim server"); err != nil {
-			return err
-		}
-		if err := ah.vmssClient.ScaleDown(); err != nil {
-			return err
-		}
-		ah.state.SetStatus("stopped")
-		if err := ah.state.Save(); err != nil {
-			return err
-		}
-		if err := ah.discordClient.SendMessage("Valheim server stopped, hope you had a great time! :grin:"); err != nil {
-			return err
-		}
-	} else if net.ParseIP(action) != nil {
-		log.Printf("IP Address: %s", action)
-		if err := ah.discordClient.SendMessage(fmt.Sprintf("Public IP address: `%s`", action)); err != nil {
-			return err
-		}
-		ah.state.SetIp(action)
-		if err := ah.state.Save(); err != nil {
-			return err
-		}
-	} else if strings.Contains(action, "listening") {
-		ah.state.SetStatus("listening")
-		if err := ah.state.Save(); err != nil {
-			return err
-		}
-		if err := ah.discordClient.SendMessage("Valheim server is ready, enjoy!"); err != nil {
-			return err
-		}
-	} else if strings.Contains(action, "Got connection SteamID") {
-		realname, err := ah.steamClient.GetUserRealName(action)
-		if err != nil {
-			return err
-		}
-		ah.state.AddOnlinePlayer(realname)
-		if err := ah.state.Save(); err != nil {
-			return err
-		}
-		if err := ah.discordClient.SendMessage(fmt.Sprintf("Greetings `%s`!", realname)); err != nil {
-			return err
-		}
-	} else if strings.Contains(action, "Closing socket") {
-		realname, err := ah.steamClient.GetUserRealName(action)
-		if err != nil {
-			return err
-		}
-		ah.state.RemoveOnlinePlayer(realname)
-		if err := ah.state.Save(); err != nil {
-			return err
-		}
-		if err := ah.discordClient.SendMessage(fmt.Sprintf("Farewell `%s`...", realname)); err != nil {
-			return err
-		}
-	} else {
-		if err := ah.discordClient.SendMessage(fmt.Sprintf("Received unknown action: %s", action)); err != nil {
-			return err
-		}
+// notify edits the response of the interaction that triggered the action when there is one,
+// otherwise, or if the interaction token already expired, it posts to the channel
+func (ah *actionHandler) notify(interactionToken, msg string) error {
+	if interactionToken != "" {
+		err := ah.discordClient.EditInteractionResponse(interactionToken, msg)
+		if err == nil {
+			return nil
+		}
+		log.Printf("error editing interaction response, falling back to channel message: %v", err)
+	}
+	return ah.discordClient.SendMessage(msg)
+}
+
+// commit applies a state change, records it and saves it, reapplying it on a fresh read when the state changed
+// meanwhile, see valheimstate.UpdateRecorded. Side effects go after it, so a conflict never repeats them
+func (ah *actionHandler) commit(mutate func(state statestorageinterface.StateInterface) error) error {
+	return valheimstate.UpdateRecorded(ah.state, mutate, func(before, after statestorageinterface.StateAttributes) error {
+		return ah.records.record(ah.cause, before, after)
+	})
+}
+
+func (ah *actionHandler) playerName(steamid string) (string, error) {
+	if name, ok := ah.playerNames[steamid]; ok {
+		return name, nil
+	}
+	name, err := ah.steamClient.GetUserRealName(steamid)
+	if err != nil {
+		return "", err
+	}
+	ah.playerNames[steamid] = name
+	return name, nil
+}
+
+func (ah *actionHandler) handleAction(message string) error {
+	event, err := events.Decode(message)
+	if err != nil {
+		return fmt.Errorf("error decoding event: %v", err)
 	}
-	return nil
+	log.Printf("Handling %s event %s from %s", event.Type, event.CorrelationId, event.Source)
+	ah.cause = history.CauseOf(event)
+	return ah.registry.dispatch(ah, event)
 }
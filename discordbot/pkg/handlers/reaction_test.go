@@ -2,166 +2,143 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"godin/pkg/aztclient"
-	"godin/pkg/godinerrors"
+	"godin/pkg/memtclient"
 	"godin/pkg/statestorageinterface"
-	"godin/pkg/utils"
+	"godin/pkg/valheimstate"
+	"godin/pkg/vmplacement"
+	"godin/pkg/vmssclient"
 	"log"
-	"os"
 	"reflect"
-	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
-type TestState struct {
-	Attributes statestorageinterface.StateAttributes
-	storage    aztclient.TableClientInterface
+// newTestState is a state holding attributes and saved to storage
+func newTestState(attributes statestorageinterface.StateAttributes, storage aztclient.TableClientInterface) *valheimstate.State {
+	state := valheimstate.NewValheimState(storage).(*valheimstate.State)
+	state.Attributes = attributes
+	return state
 }
 
-func NewTestState(stgclient aztclient.TableClientInterface) statestorageinterface.StateInterface {
-	return &TestState{
-		storage: stgclient,
+// testPlayers builds the players of the comma delimited names, with the steam ids TestSteamClient knows them by
+func testPlayers(names string) statestorageinterface.Players {
+	steamIds := map[string]string{
+		"player1": "76561198073103840",
+		"player2": "76561198073103841",
 	}
-}
-
-func (ts *TestState) GetAttributes() statestorageinterface.StateAttributes {
-	return ts.Attributes
-}
-
-func (ts *TestState) GetStatus() string {
-	return ts.Attributes.Status
-}
-
-func (ts *TestState) SetStatus(status string) {
-	ts.Attributes.Status = status
-}
-
-func (ts *TestState) GetOnlinePlayers() []string {
-	return []string{}
-}
-
-func (ts *TestState) AddOnlinePlayer(player string) {
-	if len(ts.Attributes.OnlinePlayers) == 0 {
-		ts.Attributes.OnlinePlayers = player
-	} else {
-		players := strings.Split(ts.Attributes.OnlinePlayers, ",")
-		players = append(players, player)
-		ts.Attributes.OnlinePlayers = strings.Join(players, ",")
+	players := statestorageinterface.Players{}
+	for _, name := range strings.Split(names, ",") {
+		if name != "" {
+			players.Add(statestorageinterface.Player{SteamId: steamIds[name], Name: name})
+		}
 	}
+	if len(players) == 0 {
+		return nil
+	}
+	return players
 }
 
-func (ts *TestState) RemoveOnlinePlayer(player string) {
-	players := strings.Split(ts.Attributes.OnlinePlayers, ",")
-	players = slices.DeleteFunc(players, func(s string) bool { return s == player })
-	ts.Attributes.OnlinePlayers = strings.Join(players, ",")
-}
-
-func (ts *TestState) SetIp(ip string) {
-	ts.Attributes.Ip = ip
-}
-
-func (ts *TestState) GetIp() string {
-	return ts.Attributes.Ip
+// testTime parses the RFC3339 timestamp of a test state
+func testTime(value string) time.Time {
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return at
 }
 
-func (ts *TestState) Load() error {
-	state, err := ts.storage.Read("ip", "online_players", "status")
-	if err != nil {
-		if utils.IsMissingColumnError(err) {
-			return ts.Save()
-		}
-		return err
+// playerNames returns the comma delimited names of the players, in the order they joined
+func playerNames(attributes statestorageinterface.StateAttributes) string {
+	names := []string{}
+	for _, player := range attributes.Players.Sorted() {
+		names = append(names, player.Name)
 	}
-	ts.Attributes.Ip = state["ip"].(string)
-	ts.Attributes.OnlinePlayers = state["online_players"].(string)
-	ts.Attributes.Status = state["status"].(string)
-	return nil
+	return strings.Join(names, ",")
 }
 
 type TestSteamClient struct{}
 
-func (tsc TestSteamClient) GetUserRealName(action string) (string, error) {
+func (tsc TestSteamClient) GetUserRealName(steamid string) (string, error) {
 	idusermap := map[string]string{
 		"76561198073103840": "player1",
 		"76561198073103841": "player2",
 	}
-	id, err := utils.ExtractSteamId(action)
-	if err != nil {
-		return "", err
+	realname, ok := idusermap[steamid]
+	if !ok {
+		return "", fmt.Errorf("unknown steam id %s", steamid)
 	}
-	return idusermap[id], nil
+	return realname, nil
 }
 
 type TestDiscordClient struct {
-	messagesSent []string
+	messagesSent      []string
+	adminMessagesSent []string
+	interactionEdits  []string
+	buttonsSent       []string
 }
 
-func (tdc *TestDiscordClient) SendMessage(msg string) error {
+func (tdc *TestDiscordClient) SendButtonMessage(msg, label, customId string) error {
 	tdc.messagesSent = append(tdc.messagesSent, msg)
-	log.Println(msg)
+	tdc.buttonsSent = append(tdc.buttonsSent, customId)
 	return nil
 }
 
-type TestVmssClient struct{}
+func (tdc *TestDiscordClient) SendAdminMessage(msg string) error {
+	tdc.adminMessagesSent = append(tdc.adminMessagesSent, msg)
+	log.Println(msg)
+	return nil
+}
 
-func (tvc *TestVmssClient) ScaleUp() error {
+func (tdc *TestDiscordClient) SendMessage(msg string) error {
+	tdc.messagesSent = append(tdc.messagesSent, msg)
+	log.Println(msg)
 	return nil
 }
 
-func (tvc *TestVmssClient) ScaleDown() error {
+func (tdc *TestDiscordClient) EditInteractionResponse(token, msg string) error {
+	tdc.interactionEdits = append(tdc.interactionEdits, token+": "+msg)
+	log.Printf("%s: %s", token, msg)
 	return nil
 }
 
-type TestTableClient struct{}
+type TestVmssClient struct{}
 
-func (ttc TestTableClient) Read(columns ...string) (map[string]interface{}, error) {
-	contentBytes, err := os.ReadFile("testvalheimstate.json")
-	if err != nil {
-		return nil, err
-	}
-	stateMap := make(map[string]interface{})
-	if err := json.Unmarshal(contentBytes, &stateMap); err != nil {
-		return nil, err
-	}
-	if err := utils.ValidateColumns(stateMap, columns); err != nil {
-		return nil, godinerrors.ReadError{
-			Code:    godinerrors.MissingColumnError,
-			Message: err.Error(),
-		}
-	}
-	return stateMap, nil
+func (tvc *TestVmssClient) ScaleUp(report func(vmssclient.Attempt)) (vmplacement.Placement, error) {
+	placement := vmplacement.Placement{Vmss: "valheim-server-vmss"}
+	report(vmssclient.Attempt{Placement: placement})
+	return placement, nil
 }
 
-func (ttc TestTableClient) Write(state statestorageinterface.StateAttributes) error {
-	statefile := "testvalheimstate.json"
-	statebytes, err := json.Marshal(&state)
-	if err != nil {
-		return err
-	}
-	log.Printf("saving state: %s", statebytes)
-	err = os.WriteFile(statefile, statebytes, 0777)
-	if err != nil {
-		return err
-	}
-	log.Printf("saved state to: %s", statefile)
+func (tvc *TestVmssClient) ScaleDown(placement vmplacement.Placement) error {
 	return nil
 }
 
-func (ts *TestState) Save() error {
-	if err := ts.storage.Write(ts.GetAttributes()); err != nil {
-		return err
+// newTestStorage creates the state entity of a test in a fresh memory table, seeded with statejson unless it's empty
+func newTestStorage(statejson string) aztclient.TableClientInterface {
+	table := memtclient.NewTable()
+	if statejson != "" {
+		properties := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(statejson), &properties); err != nil {
+			panic(err)
+		}
+		table.Put(statePartitionKey, "world", properties)
 	}
-	return nil
+	return memtclient.NewTableClient(table, statePartitionKey, "world")
 }
 
 func TestHandleAction(t *testing.T) {
 	type testcase struct {
 		Action                  string
 		ExpectedMessages        []string
+		ExpectedEdits           []string
 		InitialStateJson        string
-		ExpectedState           *TestState
+		ExpectedState           *valheimstate.State
 		ExpectedStateProperties []string
+		// the server became empty, when is the timestamp of the event
+		ExpectedEmpty bool
 	}
 	testcases := []testcase{
 		{
@@ -169,11 +146,67 @@ func TestHandleAction(t *testing.T) {
 			ExpectedMessages:        []string{"Starting Valheim server", "Valheim server started"},
 			ExpectedStateProperties: []string{"ip", "online_players", "status"},
 			InitialStateJson:        `{}`,
-			ExpectedState: &TestState{
+			ExpectedState: &valheimstate.State{
+				Attributes: statestorageinterface.StateAttributes{
+					Ip:        "",
+					Players:   testPlayers(""),
+					Status:    "started",
+					Placement: vmplacement.Placement{Vmss: "valheim-server-vmss"},
+				},
+			},
+		},
+		{
+			Action:                  `{"action":"start","interaction_token":"token1"}`,
+			ExpectedEdits:           []string{"token1: Starting Valheim server", "token1: Valheim server started"},
+			ExpectedStateProperties: []string{"ip", "online_players", "status"},
+			InitialStateJson:        `{"ip":"", "online_players": "", "status":"stopped"}`,
+			ExpectedState: &valheimstate.State{
+				Attributes: statestorageinterface.StateAttributes{
+					Ip:                 "",
+					Players:            testPlayers(""),
+					Status:             "started",
+					PendingInteraction: "token1",
+					Placement:          vmplacement.Placement{Vmss: "valheim-server-vmss"},
+				},
+			},
+		},
+		{
+			Action:                  `{"version":1,"type":"stop","source":"interactions","correlation_id":"id1","requester":{"user_id":"100","interaction_token":"token2"}}`,
+			ExpectedEdits:           []string{"token2: Stopping Valheim server", "token2: Valheim server stopped, hope you had a great time! :grin:"},
+			ExpectedStateProperties: []string{"ip", "online_players", "status"},
+			InitialStateJson:        `{"ip":"192.168.0.1", "online_players": "", "status":"listening"}`,
+			ExpectedState: &valheimstate.State{
+				Attributes: statestorageinterface.StateAttributes{
+					Ip:      "192.168.0.1",
+					Players: testPlayers(""),
+					Status:  "stopped",
+				},
+			},
+		},
+		{
+			Action:                  `{"version":1,"type":"public_ip","source":"vm","correlation_id":"id2","payload":{"ip":"192.168.0.2"}}`,
+			ExpectedMessages:        []string{"Public IP address: `192.168.0.2`"},
+			ExpectedStateProperties: []string{"ip", "online_players", "status"},
+			InitialStateJson:        `{"ip":"192.168.0.1", "online_players": "", "status":"started"}`,
+			ExpectedState: &valheimstate.State{
 				Attributes: statestorageinterface.StateAttributes{
-					Ip:            "",
-					OnlinePlayers: "",
-					Status:        "started",
+					Ip:      "192.168.0.2",
+					Players: testPlayers(""),
+					Status:  "started",
+				},
+			},
+		},
+		{
+			Action:                  "Server is now listening",
+			ExpectedEmpty:           true,
+			ExpectedEdits:           []string{"token1: Valheim server is ready, enjoy!"},
+			ExpectedStateProperties: []string{"ip", "online_players", "status"},
+			InitialStateJson:        `{"ip":"192.168.0.1", "online_players": "", "status":"started", "pending_interaction":"token1"}`,
+			ExpectedState: &valheimstate.State{
+				Attributes: statestorageinterface.StateAttributes{
+					Ip:      "192.168.0.1",
+					Players: testPlayers(""),
+					Status:  "listening",
 				},
 			},
 		},
@@ -182,24 +215,25 @@ func TestHandleAction(t *testing.T) {
 			ExpectedMessages:        []string{"Stopping Valheim server", "Valheim server stopped, hope you had a great time! :grin:"},
 			ExpectedStateProperties: []string{"ip", "online_players", "status"},
 			InitialStateJson:        `{"ip":"192.168.0.1", "online_players": "", "status":"listening"}`,
-			ExpectedState: &TestState{
+			ExpectedState: &valheimstate.State{
 				Attributes: statestorageinterface.StateAttributes{
-					Ip:            "192.168.0.1",
-					OnlinePlayers: "",
-					Status:        "stopped",
+					Ip:      "192.168.0.1",
+					Players: testPlayers(""),
+					Status:  "stopped",
 				},
 			},
 		},
 		{
 			Action:                  "Server is now listening",
+			ExpectedEmpty:           true,
 			ExpectedMessages:        []string{"Valheim server is ready, enjoy!"},
 			ExpectedStateProperties: []string{"ip", "online_players", "status"},
 			InitialStateJson:        `{"ip":"192.168.0.1", "online_players": "", "status":"started"}`,
-			ExpectedState: &TestState{
+			ExpectedState: &valheimstate.State{
 				Attributes: statestorageinterface.StateAttributes{
-					Ip:            "192.168.0.1",
-					OnlinePlayers: "",
-					Status:        "listening",
+					Ip:      "192.168.0.1",
+					Players: testPlayers(""),
+					Status:  "listening",
 				},
 			},
 		},
@@ -208,11 +242,11 @@ func TestHandleAction(t *testing.T) {
 			ExpectedMessages:        []string{"Greetings `player1`!"},
 			ExpectedStateProperties: []string{"ip", "online_players", "status"},
 			InitialStateJson:        `{"ip":"192.168.0.1", "online_players": "", "status":"listening"}`,
-			ExpectedState: &TestState{
+			ExpectedState: &valheimstate.State{
 				Attributes: statestorageinterface.StateAttributes{
-					Ip:            "192.168.0.1",
-					OnlinePlayers: "player1",
-					Status:        "listening",
+					Ip:      "192.168.0.1",
+					Players: testPlayers("player1"),
+					Status:  "listening",
 				},
 			},
 		},
@@ -221,24 +255,25 @@ func TestHandleAction(t *testing.T) {
 			ExpectedMessages:        []string{"Greetings `player2`!"},
 			ExpectedStateProperties: []string{"ip", "online_players", "status"},
 			InitialStateJson:        `{"ip":"192.168.0.1", "online_players": "player1", "status":"listening"}`,
-			ExpectedState: &TestState{
+			ExpectedState: &valheimstate.State{
 				Attributes: statestorageinterface.StateAttributes{
-					Ip:            "192.168.0.1",
-					OnlinePlayers: "player1,player2",
-					Status:        "listening",
+					Ip:      "192.168.0.1",
+					Players: testPlayers("player1,player2"),
+					Status:  "listening",
 				},
 			},
 		},
 		{
 			Action:                  "Closing socket 76561198073103840",
+			ExpectedEmpty:           true,
 			ExpectedMessages:        []string{"Farewell `player1`..."},
 			ExpectedStateProperties: []string{"ip", "online_players", "status"},
 			InitialStateJson:        `{"ip":"192.168.0.1", "online_players": "player1", "status":"listening"}`,
-			ExpectedState: &TestState{
+			ExpectedState: &valheimstate.State{
 				Attributes: statestorageinterface.StateAttributes{
-					Ip:            "192.168.0.1",
-					OnlinePlayers: "",
-					Status:        "listening",
+					Ip:      "192.168.0.1",
+					Players: testPlayers(""),
+					Status:  "listening",
 				},
 			},
 		},
@@ -247,34 +282,46 @@ func TestHandleAction(t *testing.T) {
 			ExpectedMessages:        []string{"Farewell `player2`..."},
 			ExpectedStateProperties: []string{"ip", "online_players", "status"},
 			InitialStateJson:        `{"ip":"192.168.0.1", "online_players": "player1,player2", "status":"listening"}`,
-			ExpectedState: &TestState{
+			ExpectedState: &valheimstate.State{
 				Attributes: statestorageinterface.StateAttributes{
-					Ip:            "192.168.0.1",
-					OnlinePlayers: "player1",
-					Status:        "listening",
+					Ip:      "192.168.0.1",
+					Players: testPlayers("player1"),
+					Status:  "listening",
 				},
 			},
 		},
 	}
-	storage := TestTableClient{}
 	vmssclient := TestVmssClient{}
 	steamclient := TestSteamClient{}
+	registry := NewReactionHandler(FallbackLog, Backends{}).registry
 	for _, tc := range testcases {
 		disclient := TestDiscordClient{}
-		setState(tc.InitialStateJson)
-		testState := NewTestState(storage)
+		storage := newTestStorage(tc.InitialStateJson)
+		testState := valheimstate.NewValheimState(storage)
 		testState.Load()
-		validationState := NewTestState(storage)
-		ah := newActionHandler(&disclient, &vmssclient, steamclient, testState)
+		validationState := valheimstate.NewValheimState(storage)
+		ah := newActionHandler(registry, &disclient, &vmssclient, steamclient, testState)
 		err := ah.handleAction(tc.Action)
 		if err != nil {
 			t.Errorf("%s - error handling action: %v", tc.Action, err)
 		}
 		validationState.Load()
 		validationAttributes := validationState.GetAttributes()
-		if err != nil {
-			t.Errorf("%s - error reading state: %v", tc.Action, err)
+		if validationAttributes.EmptySince.IsZero() == tc.ExpectedEmpty {
+			t.Errorf("%s - expected empty since to be set %v but was %v", tc.Action, tc.ExpectedEmpty, validationAttributes.EmptySince)
+		}
+		validationAttributes.EmptySince = time.Time{}
+		// statuses are timed with the clock
+		validationAttributes.StatusSince = time.Time{}
+		// legacy string events get a random correlation id
+		validationAttributes.StatusEvent = ""
+		// sessions are timed with the clock, TestSessions checks them
+		validationAttributes.Session = statestorageinterface.Session{}
+		if playerNames(validationAttributes) != playerNames(tc.ExpectedState.Attributes) {
+			t.Errorf("%s - expected players to be %q but were %q", tc.Action, playerNames(tc.ExpectedState.Attributes), playerNames(validationAttributes))
 		}
+		// join times come from the events, only the names are compared
+		validationAttributes.Players = tc.ExpectedState.Attributes.Players
 		if !reflect.DeepEqual(tc.ExpectedState.Attributes, validationAttributes) {
 			t.Errorf("%s - expected state attributes to be %v but was %v", tc.Action, tc.ExpectedState, validationAttributes)
 		}
@@ -282,9 +329,22 @@ func TestHandleAction(t *testing.T) {
 		if !reflect.DeepEqual(sentMessages, tc.ExpectedMessages) {
 			t.Errorf("%s - expected sent messages to be %v but were %v", tc.Action, tc.ExpectedMessages, sentMessages)
 		}
+		if !reflect.DeepEqual(disclient.interactionEdits, tc.ExpectedEdits) {
+			t.Errorf("%s - expected interaction edits to be %v but were %v", tc.Action, tc.ExpectedEdits, disclient.interactionEdits)
+		}
 	}
 }
 
-func setState(statejson string) {
-	os.WriteFile("testvalheimstate.json", []byte(statejson), 0777)
+func TestUnquoteTriggerData(t *testing.T) {
+	testcases := map[string]string{
+		`"start"`:                 "start",
+		`"\"start\""`:             "start",
+		`"{\"action\":\"stop\"}"`: `{"action":"stop"}`,
+		`{"action":"stop","interaction_token":"token"}`: `{"action":"stop","interaction_token":"token"}`,
+	}
+	for data, expected := range testcases {
+		if message := unquoteTriggerData([]byte(data)); message != expected {
+			t.Errorf("expected %s to be unquoted to %s but was %s", data, expected, message)
+		}
+	}
 }
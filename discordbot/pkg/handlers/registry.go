@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"godin/pkg/events"
+	"godin/pkg/godinerrors"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/utils"
+	"log"
+)
+
+// eventHandler reacts to one kind of event
+type eventHandler interface {
+	// Match tells whether the handler handles the event
+	Match(event events.Envelope) bool
+	// Mutate applies the event to the state, the registry saves the state right after
+	Mutate(ah *actionHandler, event events.Envelope) error
+	// Notify runs once the mutation is saved, it reports the event and runs its side effects like scaling the vmss
+	Notify(ah *actionHandler, event events.Envelope) error
+}
+
+// eventRegistry dispatches events to the first registered handler that matches them
+type eventRegistry struct {
+	handlers []eventHandler
+	fallback eventHandler
+}
+
+func newEventRegistry(fallback eventHandler) *eventRegistry {
+	return &eventRegistry{
+		fallback: fallback,
+	}
+}
+
+func (er *eventRegistry) register(handler eventHandler) {
+	er.handlers = append(er.handlers, handler)
+}
+
+func (er *eventRegistry) handlerFor(event events.Envelope) eventHandler {
+	for _, handler := range er.handlers {
+		if handler.Match(event) {
+			return handler
+		}
+	}
+	return er.fallback
+}
+
+func (er *eventRegistry) dispatch(ah *actionHandler, event events.Envelope) error {
+	handler := er.handlerFor(event)
+	// Mutate is applied again on a fresh read if the state was saved by someone else meanwhile
+	if err := ah.commit(func(state statestorageinterface.StateInterface) error { return handler.Mutate(ah, event) }); err != nil {
+		if utils.IsTransitionError(err) {
+			return ah.rejectTransition(event, err.(godinerrors.TransitionError))
+		}
+		return err
+	}
+	return handler.Notify(ah, event)
+}
+
+// rejectTransition drops an event the server lifecycle doesn't allow, retrying it wouldn't change that.
+// Its requester, if any, is told why instead of waiting forever on the deferred response
+func (ah *actionHandler) rejectTransition(event events.Envelope, err godinerrors.TransitionError) error {
+	log.Printf("Dropping %s event %s from %s: %v", event.Type, event.CorrelationId, event.Source, err)
+	if event.InteractionToken() == "" {
+		return nil
+	}
+	return ah.notify(event.InteractionToken(), transitionMessage(string(event.Type), err))
+}
+
+// typeMatcher matches events by their envelope type, embedded by the handlers of a single type
+type typeMatcher events.Type
+
+func (tm typeMatcher) Match(event events.Envelope) bool {
+	return event.Type == events.Type(tm)
+}
+
+// Fallbacks for events no handler matches
+const (
+	// FallbackLog only logs unknown events, this is the default
+	FallbackLog = "log"
+	// FallbackChannel also posts unknown events to the players channel
+	FallbackChannel = "channel"
+)
+
+func fallbackHandler(fallback string) eventHandler {
+	switch fallback {
+	case FallbackChannel:
+		return channelFallbackHandler{}
+	case FallbackLog, "":
+		return logFallbackHandler{}
+	default:
+		log.Printf("Unknown event fallback %s, falling back to %s", fallback, FallbackLog)
+		return logFallbackHandler{}
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+	"godin/pkg/sessions"
+	"godin/pkg/statestorageinterface"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// recentSessions is how many ended sessions /sessions lists
+	recentSessions = 5
+	// sessionMonths is how many months /sessions sums up, the current one included
+	sessionMonths = 3
+	// sessionPlayerNames is how many player names a session lists before summing the rest up
+	sessionPlayerNames = 8
+)
+
+// sessionsResponse summarizes the open session, the recent ones and the monthly totals
+func (ih *InteractionHandler) sessionsResponse(now time.Time) map[string]interface{} {
+	store := ih.backends.sessions()
+	if store == nil {
+		return responseChannelMsg("Sessions aren't recorded on this server")
+	}
+	state, err := ih.backends.loadState()
+	if err != nil {
+		log.Printf("Error loading state: %v", err)
+		return responseChannelMsg("Failed to read the Valheim server state")
+	}
+	recent, err := store.Recent(recentSessions)
+	if err != nil {
+		log.Printf("Error reading sessions: %v", err)
+		return responseChannelMsg("Failed to read the sessions")
+	}
+	firstMonth := time.Date(now.UTC().Year(), now.UTC().Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1-sessionMonths, 0)
+	months, err := store.Since(firstMonth)
+	if err != nil {
+		log.Printf("Error reading sessions: %v", err)
+		return responseChannelMsg("Failed to read the sessions")
+	}
+	return responseChannelMsg(sessionsMessage(state.GetSession(), recent, months, now))
+}
+
+// sessionsMessage lists the open session, the recent ended ones and the totals of the months sessions,
+// the open session included
+func sessionsMessage(current statestorageinterface.Session, recent, months []statestorageinterface.Session, now time.Time) string {
+	lines := []string{}
+	counted := append([]statestorageinterface.Session{}, months...)
+	if current.IsOpen() {
+		counted = append(counted, current)
+		lines = append(lines, "**Current session**", sessionLine(current, now))
+	}
+	lines = append(lines, "**Recent sessions**")
+	if len(recent) == 0 {
+		lines = append(lines, "No sessions recorded")
+	}
+	for _, session := range recent {
+		lines = append(lines, sessionLine(session, now))
+	}
+	lines = append(lines, "**Monthly totals**")
+	monthly := sessions.MonthlyTotals(counted, sessionMonths, now)
+	if len(monthly) == 0 {
+		lines = append(lines, fmt.Sprintf("No sessions in the last %d months", sessionMonths))
+	}
+	for _, totals := range monthly {
+		lines = append(lines, fmt.Sprintf("%s: %d sessions, %s up, %d players", totals.Month.Format("January 2006"), totals.Sessions, totals.Uptime.Truncate(time.Second), totals.Players))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sessionLine(session statestorageinterface.Session, now time.Time) string {
+	line := fmt.Sprintf("<t:%d:f> up %s", session.RequestedAt.Unix(), session.Uptime(now).Truncate(time.Second))
+	if latency := session.ScaleUpLatency(); latency != 0 {
+		line += fmt.Sprintf(", scaled up in %s", latency.Truncate(time.Second))
+	}
+	if ready := session.TimeToListening(); ready != 0 {
+		line += fmt.Sprintf(", listening after %s", ready.Truncate(time.Second))
+	} else if session.IsOpen() {
+		line += ", not listening yet"
+	} else {
+		line += ", never listened"
+	}
+	line += fmt.Sprintf(", peak %d", session.PeakPlayers)
+	if len(session.Players) != 0 {
+		line += fmt.Sprintf(", players: %s", sessionPlayers(session))
+	}
+	if session.EndStatus != "" {
+		line += fmt.Sprintf(" (%s)", session.EndStatus)
+	}
+	return line
+}
+
+// sessionPlayers lists the names of the players of a session, sorted
+func sessionPlayers(session statestorageinterface.Session) string {
+	names := []string{}
+	for _, name := range session.Players {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > sessionPlayerNames {
+		return fmt.Sprintf("%s and %d more", strings.Join(names[:sessionPlayerNames], ", "), len(names)-sessionPlayerNames)
+	}
+	return strings.Join(names, ", ")
+}
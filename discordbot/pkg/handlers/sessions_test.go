@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"godin/pkg/disclient"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/steamapi"
+	"godin/pkg/vmssclient"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	storage, err := StorageConfig{Backend: StorageMemory, RowKey: "world"}.Open()
+	if err != nil {
+		t.Fatalf("error opening storage: %v", err)
+	}
+	backends := Backends{
+		TableClient: storage.TableClient,
+		Partition:   storage.Partition,
+		Discord:     func() (disclient.DiscordClientInterface, error) { return &TestDiscordClient{}, nil },
+		Vmss:        func(ip string) (vmssclient.VmssClientInterface, error) { return &TestVmssClient{}, nil },
+		Steam:       func() steamapi.ClientInterface { return TestSteamClient{} },
+	}
+	registry := NewReactionHandler(FallbackLog, backends).registry
+	actions := []string{
+		"start",
+		"Server is now listening",
+		"Got connection SteamID 76561198073103840",
+		"Got connection SteamID 76561198073103841",
+		"Closing socket 76561198073103840",
+		"stop",
+	}
+	for _, action := range actions {
+		ah, err := backends.newActionHandler(registry)
+		if err != nil {
+			t.Fatalf("%s - error creating action handler: %v", action, err)
+		}
+		if err := ah.handleAction(action); err != nil {
+			t.Fatalf("%s - error handling action: %v", action, err)
+		}
+	}
+
+	recorded, err := backends.sessions().Recent(recentSessions)
+	if err != nil {
+		t.Fatalf("error reading sessions: %v", err)
+	}
+	if len(recorded) != 1 {
+		t.Fatalf("expected one session to be recorded but were %v", recorded)
+	}
+	session := recorded[0]
+	if session.EndStatus != "stopped" || session.PeakPlayers != 2 || sessionPlayers(session) != "player1, player2" || session.ListeningAt.IsZero() {
+		t.Errorf("expected a stopped session with a peak of 2 players but was %+v", session)
+	}
+
+	content := NewInteractionHandler(nil, nil, backends).sessionsResponse(time.Now())["data"].(map[string]string)["content"]
+	if strings.Contains(content, "Current session") || !strings.Contains(content, "peak 2, players: player1, player2 (stopped)") || !strings.Contains(content, ": 1 sessions, ") {
+		t.Errorf("expected the stopped session to be listed and counted but was %q", content)
+	}
+}
+
+func TestSessionsMessage(t *testing.T) {
+	now := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	current := statestorageinterface.Session{Id: "start2", RequestedAt: now.Add(-time.Minute), StartedAt: now.Add(-30 * time.Second)}
+	ended := statestorageinterface.Session{
+		Id:          "start1",
+		RequestedAt: now.Add(-3 * time.Hour),
+		ListeningAt: now.Add(-3*time.Hour + 4*time.Minute),
+		EndedAt:     now.Add(-time.Hour),
+		EndStatus:   "evicted",
+		PeakPlayers: 1,
+		Players:     map[string]string{"1": "player1"},
+	}
+	expected := strings.Join([]string{
+		"**Current session**",
+		"<t:1728165540:f> up 1m0s, scaled up in 30s, not listening yet, peak 0",
+		"**Recent sessions**",
+		"<t:1728154800:f> up 2h0m0s, listening after 4m0s, peak 1, players: player1 (evicted)",
+		"**Monthly totals**",
+		"October 2024: 2 sessions, 2h1m0s up, 1 players",
+	}, "\n")
+	if content := sessionsMessage(current, []statestorageinterface.Session{ended}, []statestorageinterface.Session{ended}, now); content != expected {
+		t.Errorf("expected the sessions message to be %q but was %q", expected, content)
+	}
+	if content := sessionsMessage(statestorageinterface.Session{}, nil, nil, now); content != "**Recent sessions**\nNo sessions recorded\n**Monthly totals**\nNo sessions in the last 3 months" {
+		t.Errorf("expected no sessions to be listed but was %q", content)
+	}
+}
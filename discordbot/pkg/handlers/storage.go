@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"godin/pkg/aztclient"
+	"godin/pkg/filetclient"
+	"godin/pkg/memtclient"
+	"godin/pkg/sqltclient"
+	"os"
+)
+
+// Storage backends of the state table
+const (
+	StorageAzure  = "azure"
+	StorageFile   = "file"
+	StorageSql    = "sql"
+	StorageMemory = "memory"
+)
+
+// StorageConfig selects the backend the state table is kept in
+type StorageConfig struct {
+	Backend string
+	// TableName is the azure table
+	TableName string
+	// Path is the json file, or the data source name of the sql database
+	Path string
+	// Driver is the database/sql driver, it has to be built in, see sqltclient.Open
+	Driver string
+	// RowKey is the world name, every entity of the world is in the same row
+	RowKey string
+}
+
+// StorageConfigFromEnv reads STATE_STORAGE, STATE_STORAGE_NAME, STATE_STORAGE_PATH, STATE_STORAGE_DRIVER and WORLD_NAME
+func StorageConfigFromEnv() StorageConfig {
+	return StorageConfig{
+		Backend:   os.Getenv("STATE_STORAGE"),
+		TableName: os.Getenv("STATE_STORAGE_NAME"),
+		Path:      os.Getenv("STATE_STORAGE_PATH"),
+		Driver:    os.Getenv("STATE_STORAGE_DRIVER"),
+		RowKey:    os.Getenv("WORLD_NAME"),
+	}
+}
+
+// StorageClients are the factories of Backends.TableClient and Backends.Partition, over the same table
+type StorageClients struct {
+	TableClient func(partitionKey string) (aztclient.TableClientInterface, error)
+	Partition   func(partitionKey string) (aztclient.PartitionClientInterface, error)
+}
+
+// partitionKey keeps the rows of each world apart, the state entities keep them apart with their row key
+func (sc StorageConfig) partitionKey(partitionKey string) string {
+	return partitionKey + "-" + sc.RowKey
+}
+
+// Open opens the backend and returns the factories of its clients, an empty backend is azure
+func (sc StorageConfig) Open() (StorageClients, error) {
+	switch sc.Backend {
+	case StorageAzure, "":
+		return StorageClients{
+			TableClient: func(partitionKey string) (aztclient.TableClientInterface, error) {
+				return aztclient.NewTableClient(sc.TableName, partitionKey, sc.RowKey)
+			},
+			Partition: func(partitionKey string) (aztclient.PartitionClientInterface, error) {
+				return aztclient.NewPartitionClient(sc.TableName, sc.partitionKey(partitionKey))
+			},
+		}, nil
+	case StorageFile:
+		if sc.Path == "" {
+			return StorageClients{}, fmt.Errorf("error opening %s storage: no path set", sc.Backend)
+		}
+		return StorageClients{
+			TableClient: func(partitionKey string) (aztclient.TableClientInterface, error) {
+				return filetclient.NewTableClient(sc.Path, partitionKey, sc.RowKey)
+			},
+			Partition: func(partitionKey string) (aztclient.PartitionClientInterface, error) {
+				return filetclient.NewPartitionClient(sc.Path, sc.partitionKey(partitionKey))
+			},
+		}, nil
+	case StorageSql:
+		driver := sc.Driver
+		if driver == "" {
+			driver = "sqlite"
+		}
+		db, err := sqltclient.Open(driver, sc.Path)
+		if err != nil {
+			return StorageClients{}, fmt.Errorf("error opening %s storage: %v", sc.Backend, err)
+		}
+		return StorageClients{
+			TableClient: func(partitionKey string) (aztclient.TableClientInterface, error) {
+				return sqltclient.NewTableClient(db, partitionKey, sc.RowKey), nil
+			},
+			Partition: func(partitionKey string) (aztclient.PartitionClientInterface, error) {
+				return sqltclient.NewPartitionClient(db, sc.partitionKey(partitionKey)), nil
+			},
+		}, nil
+	case StorageMemory:
+		table := memtclient.NewTable()
+		return StorageClients{
+			TableClient: func(partitionKey string) (aztclient.TableClientInterface, error) {
+				return memtclient.NewTableClient(table, partitionKey, sc.RowKey), nil
+			},
+			Partition: func(partitionKey string) (aztclient.PartitionClientInterface, error) {
+				return memtclient.NewPartitionClient(table, sc.partitionKey(partitionKey)), nil
+			},
+		}, nil
+	default:
+		return StorageClients{}, fmt.Errorf("unknown storage backend %s", sc.Backend)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"godin/pkg/events"
+	"godin/pkg/history"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/valheimstate"
+	"log"
+	"net/http"
+	"time"
+)
+
+// DefaultHeartbeatMaxGap is how long the server can go without heartbeats before it's flagged unhealthy,
+// the agent sends one every 30 seconds
+const DefaultHeartbeatMaxGap = 3 * time.Minute
+
+// WatchdogHandler is the timer-triggered function that flags a listening server as unhealthy
+// when the vm agent stops sending heartbeats, like when the container crashed or the vm is gone
+type WatchdogHandler struct {
+	backends Backends
+	maxGap   time.Duration
+}
+
+func NewWatchdogHandler(backends Backends, maxGap time.Duration) *WatchdogHandler {
+	return &WatchdogHandler{
+		backends: backends,
+		maxGap:   maxGap,
+	}
+}
+
+func (wh *WatchdogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if err := wh.check(time.Now()); err != nil {
+		setInternalServerErrorWithLogs(w, fmt.Errorf("cought error: %v", err))
+		return
+	}
+
+	invokeResponse := invokeResponse{Logs: []string{}}
+	js, err := json.Marshal(invokeResponse)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// Watch runs the check every interval until ctx is done, for when there is no functions host
+func (wh *WatchdogHandler) Watch(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-time.After(interval):
+			if err := wh.check(now); err != nil {
+				log.Printf("Error checking heartbeats: %v", err)
+			}
+		}
+	}
+}
+
+func (wh *WatchdogHandler) check(now time.Time) error {
+	ah, err := wh.backends.newActionHandler(nil)
+	if err != nil {
+		return err
+	}
+	ah.cause = history.Cause{EventType: "watchdog", Source: events.SourceScheduler, At: now}
+	return ah.checkHeartbeat(now, wh.maxGap)
+}
+
+// checkHeartbeat flags the server unhealthy and clears its players when its last heartbeat is older than maxGap.
+// Servers that never sent one are given maxGap from when they started listening
+func (ah *actionHandler) checkHeartbeat(now time.Time, maxGap time.Duration) error {
+	var gap time.Duration
+	flagged := false
+	if err := ah.commit(func(state statestorageinterface.StateInterface) error {
+		flagged = false
+		if state.GetStatus() != valheimstate.Listening {
+			return nil
+		}
+		last := state.GetLastHeartbeat()
+		if since := state.GetStatusSince(); since.After(last) {
+			last = since
+		}
+		gap = now.Sub(last)
+		if gap <= maxGap {
+			return nil
+		}
+		log.Printf("No heartbeat since %s, flagging the server unhealthy", last)
+		if err := state.Transition(valheimstate.Unhealthy, "watchdog"); err != nil {
+			return err
+		}
+		state.ClearPlayers()
+		flagged = true
+		return nil
+	}); err != nil || !flagged {
+		return err
+	}
+	return ah.discordClient.SendMessage(fmt.Sprintf(":warning: No news from the Valheim server for %s, it may have crashed. Run `/stop` and `/start` to bring it back", gap.Truncate(time.Second)))
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"godin/pkg/statestorageinterface"
+	"testing"
+	"time"
+)
+
+func TestCheckHeartbeat(t *testing.T) {
+	type testcase struct {
+		Name             string
+		Attributes       statestorageinterface.StateAttributes
+		ExpectedStatus   string
+		ExpectedPlayers  string
+		ExpectedMessages int
+	}
+	now := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	testcases := []testcase{
+		{
+			Name: "recent heartbeat",
+			Attributes: statestorageinterface.StateAttributes{
+				Players: testPlayers("player1"), Status: "listening", StatusSince: testTime("2024-10-05T20:00:00Z"), LastHeartbeat: testTime("2024-10-05T21:59:30Z"),
+			},
+			ExpectedStatus:  "listening",
+			ExpectedPlayers: "player1",
+		},
+		{
+			Name: "heartbeats stopped",
+			Attributes: statestorageinterface.StateAttributes{
+				Players: testPlayers("player1,player2"), Status: "listening", StatusSince: testTime("2024-10-05T20:00:00Z"), LastHeartbeat: testTime("2024-10-05T21:50:00Z"),
+			},
+			ExpectedStatus:   "unhealthy",
+			ExpectedPlayers:  "",
+			ExpectedMessages: 1,
+		},
+		{
+			Name: "just started listening without heartbeats yet",
+			Attributes: statestorageinterface.StateAttributes{
+				Status: "listening", StatusSince: testTime("2024-10-05T21:59:00Z"),
+			},
+			ExpectedStatus: "listening",
+		},
+		{
+			Name: "not listening",
+			Attributes: statestorageinterface.StateAttributes{
+				Status: "stopped", StatusSince: testTime("2024-10-05T12:00:00Z"), LastHeartbeat: testTime("2024-10-05T11:00:00Z"),
+			},
+			ExpectedStatus: "stopped",
+		},
+	}
+	for _, tc := range testcases {
+		disclient := TestDiscordClient{}
+		state := newTestState(tc.Attributes, newTestStorage(""))
+		ah := newActionHandler(nil, &disclient, &TestVmssClient{}, TestSteamClient{}, state)
+		if err := ah.checkHeartbeat(now, DefaultHeartbeatMaxGap); err != nil {
+			t.Errorf("%s - error checking heartbeat: %v", tc.Name, err)
+		}
+		if state.Attributes.Status != tc.ExpectedStatus || playerNames(state.Attributes) != tc.ExpectedPlayers {
+			t.Errorf("%s - expected status %s with players %q but was %s with %q", tc.Name, tc.ExpectedStatus, tc.ExpectedPlayers, state.Attributes.Status, playerNames(state.Attributes))
+		}
+		if len(disclient.messagesSent) != tc.ExpectedMessages {
+			t.Errorf("%s - expected %d messages but sent %v", tc.Name, tc.ExpectedMessages, disclient.messagesSent)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package history keeps every change of the state entity as an immutable row, newest first
+package history
+
+import (
+	"fmt"
+	"godin/pkg/aztclient"
+	"godin/pkg/entitycodec"
+	"godin/pkg/events"
+	"godin/pkg/statestorageinterface"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultRetention is how long entries are kept when HISTORY_RETENTION isn't set
+const DefaultRetention = 30 * 24 * time.Hour
+
+// pruneBatch is how many expired entries a Record deletes at most, the next ones go with the next records
+const pruneBatch = 20
+
+// untracked are the columns left out of the history, heartbeats change every 30 seconds, interaction
+// tokens let anyone holding them edit the response of the interaction and sessions are kept by sessions.Store
+var untracked = map[string]bool{
+	"last_heartbeat":      true,
+	"pending_interaction": true,
+	"session":             true,
+}
+
+// Cause is what a state change was made for
+type Cause struct {
+	EventType     string
+	Source        string
+	CorrelationId string
+	RequesterId   string
+	Requester     string
+	// At is when the event happened, the zero time when the changes are made as they are saved
+	At time.Time
+}
+
+// CauseOf is the cause of the changes an event makes
+func CauseOf(event events.Envelope) Cause {
+	cause := Cause{
+		EventType:     string(event.Type),
+		Source:        event.Source,
+		CorrelationId: event.CorrelationId,
+		At:            event.Timestamp,
+	}
+	if event.Requester != nil {
+		cause.RequesterId = event.Requester.UserId
+		cause.Requester = event.Requester.Username
+	}
+	return cause
+}
+
+// Change is a column of the state entity that changed
+type Change struct {
+	Column   string `json:"column"`
+	Previous string `json:"previous"`
+	Value    string `json:"value"`
+}
+
+// Entry is a saved state change, Key orders entries newest first
+type Entry struct {
+	Key           string    `table:"-"`
+	At            time.Time `table:"at"`
+	EventType     string    `table:"event_type"`
+	Source        string    `table:"source"`
+	CorrelationId string    `table:"correlation_id"`
+	RequesterId   string    `table:"requester_id"`
+	Requester     string    `table:"requester"`
+	Changes       []Change  `table:"changes"`
+}
+
+// RetentionFromEnv reads the HISTORY_RETENTION go duration, 0 keeps entries forever
+func RetentionFromEnv() (time.Duration, error) {
+	value := os.Getenv("HISTORY_RETENTION")
+	if value == "" {
+		return DefaultRetention, nil
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing HISTORY_RETENTION: %v", err)
+	}
+	return retention, nil
+}
+
+// Store keeps the history of a world in its own partition
+type Store struct {
+	rows      aztclient.PartitionClientInterface
+	retention time.Duration
+}
+
+func NewStore(rows aztclient.PartitionClientInterface, retention time.Duration) *Store {
+	return &Store{
+		rows:      rows,
+		retention: retention,
+	}
+}
+
+// Diff lists the tracked columns that changed between two states, in column order
+func Diff(before, after statestorageinterface.StateAttributes) ([]Change, error) {
+	previous, err := entitycodec.Encode(before)
+	if err != nil {
+		return nil, err
+	}
+	current, err := entitycodec.Encode(after)
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]bool{}
+	for column := range previous {
+		columns[column] = true
+	}
+	for column := range current {
+		columns[column] = true
+	}
+	changes := []Change{}
+	for column := range columns {
+		from, to := formatValue(previous[column]), formatValue(current[column])
+		if from != to && !untracked[column] {
+			changes = append(changes, Change{Column: column, Previous: from, Value: to})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Column < changes[j].Column })
+	return changes, nil
+}
+
+func formatValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return value.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+// Record appends the change between two states, when a tracked column changed, and prunes expired entries. The
+// entry is keyed by the event time and correlation id of the cause, recording the change of an event again
+// replaces its entry, causes without them get a new entry every time
+func (s *Store) Record(cause Cause, before, after statestorageinterface.StateAttributes, at time.Time) error {
+	changes, err := Diff(before, after)
+	if err != nil {
+		return fmt.Errorf("error recording state change: %v", err)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	id := cause.CorrelationId
+	if id == "" {
+		id = uuid.NewString()
+	}
+	keyAt := cause.At
+	if keyAt.IsZero() {
+		keyAt = at
+	}
+	entry := Entry{
+		Key:           aztclient.NewestFirstKey(keyAt) + "-" + id,
+		At:            at.UTC(),
+		EventType:     cause.EventType,
+		Source:        cause.Source,
+		CorrelationId: cause.CorrelationId,
+		RequesterId:   cause.RequesterId,
+		Requester:     cause.Requester,
+		Changes:       changes,
+	}
+	properties, err := entitycodec.Encode(entry)
+	if err != nil {
+		return fmt.Errorf("error recording state change: %v", err)
+	}
+	row, err := s.rows.Get(entry.Key)
+	if err != nil {
+		return fmt.Errorf("error reading state change %s: %v", entry.Key, err)
+	}
+	if err := s.rows.Put(aztclient.Row{Key: entry.Key, ETag: row.ETag, Properties: properties}); err != nil {
+		return fmt.Errorf("error recording state change: %v", err)
+	}
+	return s.prune(at)
+}
+
+// prune deletes entries older than the retention
+func (s *Store) prune(now time.Time) error {
+	if s.retention <= 0 {
+		return nil
+	}
+	expired, err := s.rows.List(aztclient.NewestFirstKey(now.Add(-s.retention)), pruneBatch)
+	if err != nil {
+		return fmt.Errorf("error listing expired history: %v", err)
+	}
+	for _, row := range expired {
+		if err := s.rows.Delete(row.Key); err != nil {
+			return fmt.Errorf("error pruning history: %v", err)
+		}
+	}
+	if len(expired) != 0 {
+		log.Printf("Pruned %d history entries older than %s", len(expired), s.retention)
+	}
+	return nil
+}
+
+// Page returns up to limit entries older than the entry with key after, newest first, an empty after starts
+// from the newest one. more tells if there are older entries
+func (s *Store) Page(after string, limit int) (entries []Entry, more bool, err error) {
+	rows, err := s.rows.List(after, limit+1)
+	if err != nil {
+		return nil, false, fmt.Errorf("error listing history: %v", err)
+	}
+	if len(rows) > limit {
+		rows, more = rows[:limit], true
+	}
+	entries = []Entry{}
+	for _, row := range rows {
+		var entry Entry
+		if err := entitycodec.Decode(row.Properties, &entry); err != nil {
+			return nil, false, fmt.Errorf("error reading history entry %s: %v", row.Key, err)
+		}
+		entry.Key = row.Key
+		entries = append(entries, entry)
+	}
+	return entries, more, nil
+}
@@ -0,0 +1,84 @@
+package history
+
+import (
+	"godin/pkg/memtclient"
+	"godin/pkg/statestorageinterface"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	heartbeat := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	before := statestorageinterface.StateAttributes{Status: "stopped", LastHeartbeat: heartbeat.Add(-30 * time.Second)}
+	after := statestorageinterface.StateAttributes{Status: "starting", StatusEvent: "id1", PendingInteraction: "token1", LastHeartbeat: heartbeat}
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("error diffing states: %v", err)
+	}
+	expected := []Change{
+		{Column: "status", Previous: "stopped", Value: "starting"},
+		{Column: "status_event", Previous: "", Value: "id1"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes to be %v but were %v", expected, changes)
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore(memtclient.NewPartitionClient(memtclient.NewTable(), "valheim-history-world"), 24*time.Hour)
+	start := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	cause := Cause{EventType: "start", Source: "interactions", CorrelationId: "id1", RequesterId: "100", Requester: "viking"}
+	statuses := []string{"", "starting", "started", "listening"}
+	for i := 1; i < len(statuses); i++ {
+		before := statestorageinterface.StateAttributes{Status: statuses[i-1]}
+		after := statestorageinterface.StateAttributes{Status: statuses[i]}
+		if err := store.Record(cause, before, after, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("error recording change to %s: %v", statuses[i], err)
+		}
+	}
+	unchanged := statestorageinterface.StateAttributes{Status: "listening", LastHeartbeat: start.Add(4 * time.Minute)}
+	if err := store.Record(Cause{EventType: "heartbeat"}, statestorageinterface.StateAttributes{Status: "listening"}, unchanged, start.Add(4*time.Minute)); err != nil {
+		t.Fatalf("error recording heartbeat: %v", err)
+	}
+
+	entries, more, err := store.Page("", 2)
+	if err != nil {
+		t.Fatalf("error reading history: %v", err)
+	}
+	if len(entries) != 2 || !more || entries[0].Changes[0].Value != "listening" || entries[1].Changes[0].Value != "started" {
+		t.Fatalf("expected the 2 newest entries and more but got %+v (more %v)", entries, more)
+	}
+	if entries[0].Requester != "viking" || entries[0].CorrelationId != "id1" || !entries[0].At.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("expected the entry to keep its cause and time but was %+v", entries[0])
+	}
+	entries, more, err = store.Page(entries[1].Key, 2)
+	if err != nil || len(entries) != 1 || more || entries[0].Changes[0].Previous != "" {
+		t.Errorf("expected the oldest entry last but got %+v (more %v, %v)", entries, more, err)
+	}
+
+	// a day later the first entries expired
+	if err := store.Record(cause, statestorageinterface.StateAttributes{Status: "listening"}, statestorageinterface.StateAttributes{Status: "stopping"}, start.Add(24*time.Hour+150*time.Second)); err != nil {
+		t.Fatalf("error recording change to stopping: %v", err)
+	}
+	entries, _, err = store.Page("", 10)
+	if err != nil || len(entries) != 2 || entries[1].Changes[0].Value != "listening" {
+		t.Errorf("expected entries older than the retention to be pruned but got %+v (%v)", entries, err)
+	}
+}
+
+func TestRecordAgain(t *testing.T) {
+	store := NewStore(memtclient.NewPartitionClient(memtclient.NewTable(), "valheim-history-world"), 0)
+	start := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	cause := Cause{EventType: "player_joined", Source: "vm", CorrelationId: "id1", At: start}
+	// a retried event records its change again, later
+	for i, ip := range []string{"192.168.0.1", "192.168.0.2"} {
+		if err := store.Record(cause, statestorageinterface.StateAttributes{}, statestorageinterface.StateAttributes{Ip: ip}, start.Add(time.Duration(i+1)*time.Minute)); err != nil {
+			t.Fatalf("%s - error recording change: %v", ip, err)
+		}
+	}
+	entries, _, err := store.Page("", 10)
+	if err != nil || len(entries) != 1 || entries[0].Changes[0].Value != "192.168.0.2" {
+		t.Errorf("expected a single entry with the last change but got %+v (%v)", entries, err)
+	}
+}
@@ -0,0 +1,180 @@
+// Package local has the fake backends godin runs with in --local mode, they log what they would do
+// and the fake vm produces the events the real one would
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"godin/pkg/disclient"
+	"godin/pkg/events"
+	"godin/pkg/queue"
+	"godin/pkg/steamapi"
+	"godin/pkg/vmplacement"
+	"godin/pkg/vmssclient"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ip is the address the fake vm reports
+const Ip = "127.0.0.1"
+
+type DiscordClient struct{}
+
+func NewDiscordClient() disclient.DiscordClientInterface {
+	return DiscordClient{}
+}
+
+func (dc DiscordClient) SendMessage(msg string) error {
+	log.Printf("[discord] channel: %s", msg)
+	return nil
+}
+
+func (dc DiscordClient) SendAdminMessage(msg string) error {
+	log.Printf("[discord] admin channel: %s", msg)
+	return nil
+}
+
+func (dc DiscordClient) SendButtonMessage(msg, label, customId string) error {
+	log.Printf("[discord] channel: %s [%s: %s]", msg, label, customId)
+	return nil
+}
+
+func (dc DiscordClient) EditInteractionResponse(token, msg string) error {
+	log.Printf("[discord] edit %s: %s", token, msg)
+	return nil
+}
+
+// VmssClient pretends to scale the game server, once scaled up it enqueues the public ip
+// and listening events the vm agent would send after bootDelay, then a heartbeat every heartbeat until
+// it's scaled down or crashes
+type VmssClient struct {
+	events    queue.Queue
+	bootDelay time.Duration
+	heartbeat time.Duration
+	mu        sync.Mutex
+	stop      chan struct{}
+}
+
+func NewVmssClient(events queue.Queue, bootDelay, heartbeat time.Duration) *VmssClient {
+	return &VmssClient{
+		events:    events,
+		bootDelay: bootDelay,
+		heartbeat: heartbeat,
+	}
+}
+
+func (vc *VmssClient) ScaleUp(report func(vmssclient.Attempt)) (vmplacement.Placement, error) {
+	placement := vmplacement.Placement{Vmss: "local", Sku: "local"}
+	log.Printf("[vmss] scaling up on %s", placement)
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	if vc.stop != nil {
+		return placement, nil
+	}
+	stop := make(chan struct{})
+	vc.stop = stop
+	go func() {
+		time.Sleep(vc.bootDelay)
+		for _, line := range []string{Ip, "Game server connected, listening on port 2456"} {
+			if err := EnqueueLogLine(vc.events, line); err != nil {
+				log.Printf("[vmss] error enqueuing %q: %v", line, err)
+			}
+		}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(vc.heartbeat):
+				if err := enqueue(vc.events, events.Heartbeat, nil); err != nil {
+					log.Printf("[vmss] error enqueuing heartbeat: %v", err)
+				}
+			}
+		}
+	}()
+	report(vmssclient.Attempt{Placement: placement})
+	return placement, nil
+}
+
+func (vc *VmssClient) ScaleDown(placement vmplacement.Placement) error {
+	log.Printf("[vmss] scaling down %s", placement)
+	vc.Crash()
+	return nil
+}
+
+// Crash stops the fake vm without telling anyone, like a crashed container
+func (vc *VmssClient) Crash() {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	if vc.stop != nil {
+		close(vc.stop)
+		vc.stop = nil
+	}
+}
+
+// EnqueueLogLine puts the event of a game server log line on the events queue
+func EnqueueLogLine(q queue.Queue, line string) error {
+	event, err := events.FromLogLine(line)
+	if err != nil {
+		return err
+	}
+	message, err := events.Encode(event)
+	if err != nil {
+		return err
+	}
+	log.Printf("[vm] enqueuing %s event for %q", event.Type, line)
+	return q.Enqueue(message)
+}
+
+// EnqueueEvicted puts the event the agent sends when azure preempts the vm on the events queue
+func EnqueueEvicted(q queue.Queue) error {
+	return enqueue(q, events.Evicted, events.EvictedPayload{EventId: uuid.NewString()})
+}
+
+func enqueue(q queue.Queue, eventType events.Type, payload interface{}) error {
+	event, err := events.New(eventType, events.SourceVm, nil, payload)
+	if err != nil {
+		return err
+	}
+	message, err := events.Encode(event)
+	if err != nil {
+		return err
+	}
+	log.Printf("[vm] enqueuing %s event", eventType)
+	return q.Enqueue(message)
+}
+
+// SteamClient answers with recorded names, players it has no recording of are named after their steam id
+type SteamClient struct {
+	names map[string]string
+}
+
+// NewSteamClient reads recorded names from a json object of steam id to name, namesPath is optional
+func NewSteamClient(namesPath string) (steamapi.ClientInterface, error) {
+	names := map[string]string{}
+	if namesPath != "" {
+		contentBytes, err := os.ReadFile(namesPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading steam names: %v", err)
+		}
+		if err := json.Unmarshal(contentBytes, &names); err != nil {
+			return nil, fmt.Errorf("error unmarshalling steam names: %v", err)
+		}
+	}
+	return SteamClient{
+		names: names,
+	}, nil
+}
+
+func (sc SteamClient) GetUserRealName(steamid string) (string, error) {
+	if name, ok := sc.names[steamid]; ok {
+		return name, nil
+	}
+	if len(steamid) > 4 {
+		steamid = steamid[len(steamid)-4:]
+	}
+	return "viking-" + steamid, nil
+}
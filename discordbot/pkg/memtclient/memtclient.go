@@ -0,0 +1,173 @@
+package memtclient
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"godin/pkg/aztclient"
+	"godin/pkg/entitycodec"
+	"godin/pkg/godinerrors"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/utils"
+)
+
+// entity is a table row, ETag is bumped on every write like azure tables do
+type entity struct {
+	etag       int
+	properties map[string]interface{}
+}
+
+// Table keeps entities in memory, keyed by "partitionKey/rowKey", for tests and throwaway local runs
+type Table struct {
+	lock     sync.Mutex
+	entities map[string]entity
+}
+
+func NewTable() *Table {
+	return &Table{entities: map[string]entity{}}
+}
+
+// Put replaces the properties of an entity as another writer would, to seed the table
+func (t *Table) Put(partitionKey, rowKey string, properties map[string]interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	key := partitionKey + "/" + rowKey
+	t.entities[key] = entity{etag: t.entities[key].etag + 1, properties: copyProperties(properties)}
+}
+
+func copyProperties(properties map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(properties))
+	for k, v := range properties {
+		copied[k] = v
+	}
+	return copied
+}
+
+// TableClient is a client for one entity of a Table
+type TableClient struct {
+	table        *Table
+	partitionKey string
+	rowKey       string
+	etag         int
+}
+
+// NewTableClient creates a TableClient for one entity of table
+func NewTableClient(table *Table, partitionKey, rowKey string) aztclient.TableClientInterface {
+	return &TableClient{
+		table:        table,
+		partitionKey: partitionKey,
+		rowKey:       rowKey,
+	}
+}
+
+func (tc *TableClient) key() string {
+	return tc.partitionKey + "/" + tc.rowKey
+}
+
+// Read returns the entity properties, creating the entity when it doesn't exist yet
+func (tc *TableClient) Read(columns ...string) (map[string]interface{}, error) {
+	tc.table.lock.Lock()
+	defer tc.table.lock.Unlock()
+	e, ok := tc.table.entities[tc.key()]
+	if !ok {
+		e = entity{etag: 1, properties: map[string]interface{}{}}
+		tc.table.entities[tc.key()] = e
+	}
+	tc.etag = e.etag
+	if err := utils.ValidateColumns(e.properties, columns); err != nil {
+		return nil, godinerrors.ReadError{
+			Code:    godinerrors.MissingColumnError,
+			Message: err.Error(),
+		}
+	}
+	return copyProperties(e.properties), nil
+}
+
+// Write replaces the entity properties, failing with a conflict if it was written since it was read
+func (tc *TableClient) Write(state statestorageinterface.StateAttributes) error {
+	tc.table.lock.Lock()
+	defer tc.table.lock.Unlock()
+	if current := tc.table.entities[tc.key()].etag; current != tc.etag {
+		return godinerrors.WriteError{
+			Code:    godinerrors.ConflictError,
+			Message: fmt.Sprintf("error writing entity %s: etag %d doesn't match %d", tc.key(), tc.etag, current),
+		}
+	}
+	properties, err := entitycodec.Encode(state)
+	if err != nil {
+		return err
+	}
+	tc.etag++
+	tc.table.entities[tc.key()] = entity{etag: tc.etag, properties: properties}
+	return nil
+}
+
+// PartitionClient is a partition of a Table
+type PartitionClient struct {
+	table        *Table
+	partitionKey string
+}
+
+// NewPartitionClient creates a PartitionClient for partitionKey in table
+func NewPartitionClient(table *Table, partitionKey string) aztclient.PartitionClientInterface {
+	return &PartitionClient{
+		table:        table,
+		partitionKey: partitionKey,
+	}
+}
+
+func (pc *PartitionClient) key(rowKey string) string {
+	return pc.partitionKey + "/" + rowKey
+}
+
+func (pc *PartitionClient) Get(key string) (aztclient.Row, error) {
+	pc.table.lock.Lock()
+	defer pc.table.lock.Unlock()
+	e, ok := pc.table.entities[pc.key(key)]
+	if !ok {
+		return aztclient.Row{Key: key}, nil
+	}
+	return aztclient.Row{Key: key, ETag: strconv.Itoa(e.etag), Properties: copyProperties(e.properties)}, nil
+}
+
+func (pc *PartitionClient) Put(row aztclient.Row) error {
+	pc.table.lock.Lock()
+	defer pc.table.lock.Unlock()
+	e, ok := pc.table.entities[pc.key(row.Key)]
+	if (row.ETag == "" && ok) || (row.ETag != "" && (!ok || row.ETag != strconv.Itoa(e.etag))) {
+		return godinerrors.WriteError{
+			Code:    godinerrors.ConflictError,
+			Message: fmt.Sprintf("error writing row %s: it changed since etag %q was read", pc.key(row.Key), row.ETag),
+		}
+	}
+	pc.table.entities[pc.key(row.Key)] = entity{etag: e.etag + 1, properties: copyProperties(row.Properties)}
+	return nil
+}
+
+func (pc *PartitionClient) List(after string, limit int) ([]aztclient.Row, error) {
+	pc.table.lock.Lock()
+	defer pc.table.lock.Unlock()
+	keys := []string{}
+	for key := range pc.table.entities {
+		if rowKey, found := strings.CutPrefix(key, pc.partitionKey+"/"); found && rowKey > after {
+			keys = append(keys, rowKey)
+		}
+	}
+	sort.Strings(keys)
+	rows := []aztclient.Row{}
+	for _, key := range keys[:min(limit, len(keys))] {
+		e := pc.table.entities[pc.key(key)]
+		rows = append(rows, aztclient.Row{Key: key, ETag: strconv.Itoa(e.etag), Properties: copyProperties(e.properties)})
+	}
+	return rows, nil
+}
+
+func (pc *PartitionClient) Delete(key string) error {
+	pc.table.lock.Lock()
+	defer pc.table.lock.Unlock()
+	delete(pc.table.entities, pc.key(key))
+	return nil
+}
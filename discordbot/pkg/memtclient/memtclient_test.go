@@ -0,0 +1,21 @@
+package memtclient
+
+import (
+	"godin/pkg/aztclient"
+	"godin/pkg/tclienttest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	table := NewTable()
+	tclienttest.Run(t, func(partitionKey, rowKey string) (aztclient.TableClientInterface, error) {
+		return NewTableClient(table, partitionKey, rowKey), nil
+	}, "")
+}
+
+func TestPartitionConformance(t *testing.T) {
+	table := NewTable()
+	tclienttest.RunPartition(t, func(partitionKey string) (aztclient.PartitionClientInterface, error) {
+		return NewPartitionClient(table, partitionKey), nil
+	}, "")
+}
@@ -0,0 +1,108 @@
+package permissions
+
+import (
+	"encoding/json"
+	"fmt"
+	"godin/pkg/aztclient"
+	"godin/pkg/utils"
+	"slices"
+	"strings"
+)
+
+// Rule lists the discord role ids and user ids allowed to run a command
+type Rule struct {
+	Roles []string `json:"roles"`
+	Users []string `json:"users"`
+}
+
+func (r Rule) allows(userid string, roles []string) bool {
+	if slices.Contains(r.Users, userid) {
+		return true
+	}
+	for _, role := range roles {
+		if slices.Contains(r.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminCommands can only be run by who their own rule lists, they never fall back to Default or to everyone
+var adminCommands = []string{"replay"}
+
+// serverCommands scale the vm up and down, once a policy has command rules nobody can run them without their
+// own rule or Default. Until then anyone can, like before policies existed
+var serverCommands = []string{"start", "stop"}
+
+// Policy maps commands to the rule that guards them. Commands are matched by their full path first
+// ("server start") and then by name ("server"), commands without a rule fall back to Default,
+// and when there is no Default either anyone can run them. Admin and server commands are the exception,
+// see adminCommands and serverCommands.
+type Policy struct {
+	Commands map[string]Rule `json:"commands"`
+	Default  *Rule           `json:"default,omitempty"`
+}
+
+func (p Policy) ruleFor(command string) (Rule, bool) {
+	if rule, ok := p.Commands[command]; ok {
+		return rule, true
+	}
+	name, _, _ := strings.Cut(command, " ")
+	if rule, ok := p.Commands[name]; ok {
+		return rule, true
+	}
+	if slices.Contains(adminCommands, name) {
+		return Rule{}, true
+	}
+	if p.Default != nil {
+		return *p.Default, true
+	}
+	if slices.Contains(serverCommands, name) && p.Commands != nil {
+		return Rule{}, true
+	}
+	return Rule{}, false
+}
+
+// Allowed tells whether the user, or one of its roles, may run the command
+func (p Policy) Allowed(command, userid string, roles []string) bool {
+	rule, ok := p.ruleFor(command)
+	if !ok {
+		return true
+	}
+	return rule.allows(userid, roles)
+}
+
+type PolicyStoreInterface interface {
+	Load() (Policy, error)
+}
+
+// TablePolicyStore reads the policy from the `policy` column of an entity, stored as json so it can be
+// edited by hand with any table storage explorer
+type TablePolicyStore struct {
+	storage aztclient.TableClientInterface
+}
+
+func NewTablePolicyStore(storage aztclient.TableClientInterface) PolicyStoreInterface {
+	return &TablePolicyStore{
+		storage: storage,
+	}
+}
+
+func (tps *TablePolicyStore) Load() (Policy, error) {
+	entity, err := tps.storage.Read("policy")
+	if err != nil {
+		if utils.IsMissingColumnError(err) {
+			return Policy{}, nil
+		}
+		return Policy{}, err
+	}
+	policyJson, ok := entity["policy"].(string)
+	if !ok {
+		return Policy{}, fmt.Errorf("error reading policy, expected a json string but was %T", entity["policy"])
+	}
+	var policy Policy
+	if err := json.Unmarshal([]byte(policyJson), &policy); err != nil {
+		return Policy{}, fmt.Errorf("error unmarshalling policy: %v", err)
+	}
+	return policy, nil
+}
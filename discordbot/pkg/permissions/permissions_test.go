@@ -0,0 +1,71 @@
+package permissions
+
+import (
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	type testcase struct {
+		Name     string
+		Policy   Policy
+		Command  string
+		UserId   string
+		Roles    []string
+		Expected bool
+	}
+	policy := Policy{
+		Commands: map[string]Rule{
+			"stop":         {Roles: []string{"admins"}, Users: []string{"100"}},
+			"server":       {Roles: []string{"vikings"}},
+			"server start": {Roles: []string{"vikings", "guests"}},
+		},
+	}
+	testcases := []testcase{
+		{Name: "empty policy allows everyone", Policy: Policy{}, Command: "status", UserId: "1", Expected: true},
+		{Name: "empty policy allows start", Policy: Policy{}, Command: "start", UserId: "1", Roles: []string{"vikings"}, Expected: true},
+		{Name: "empty policy allows stop", Policy: Policy{}, Command: "stop", UserId: "1", Expected: true},
+		{Name: "no command rules denies stop", Policy: Policy{Commands: map[string]Rule{}}, Command: "stop", UserId: "1", Expected: false},
+		{Name: "command without rule", Policy: policy, Command: "status", UserId: "1", Expected: true},
+		{Name: "server command without rule", Policy: policy, Command: "start", UserId: "1", Roles: []string{"vikings"}, Expected: false},
+		{Name: "allowed by role", Policy: policy, Command: "stop", UserId: "1", Roles: []string{"guests", "admins"}, Expected: true},
+		{Name: "allowed by user", Policy: policy, Command: "stop", UserId: "100", Expected: true},
+		{Name: "denied", Policy: policy, Command: "stop", UserId: "1", Roles: []string{"vikings"}, Expected: false},
+		{Name: "subcommand rule", Policy: policy, Command: "server start", UserId: "1", Roles: []string{"guests"}, Expected: true},
+		{Name: "falls back to command rule", Policy: policy, Command: "server stop", UserId: "1", Roles: []string{"guests"}, Expected: false},
+		{Name: "admin command without rule", Policy: Policy{}, Command: "replay", UserId: "1", Expected: false},
+		{
+			Name:     "admin command ignores default",
+			Policy:   Policy{Default: &Rule{Users: []string{"1"}}},
+			Command:  "replay",
+			UserId:   "1",
+			Expected: false,
+		},
+		{
+			Name:     "admin command rule",
+			Policy:   Policy{Commands: map[string]Rule{"replay": {Users: []string{"1"}}}},
+			Command:  "replay",
+			UserId:   "1",
+			Expected: true,
+		},
+		{
+			Name:     "server command allowed by default",
+			Policy:   Policy{Default: &Rule{Roles: []string{"vikings"}}},
+			Command:  "start",
+			UserId:   "1",
+			Roles:    []string{"vikings"},
+			Expected: true,
+		},
+		{
+			Name:     "default rule",
+			Policy:   Policy{Default: &Rule{Users: []string{"100"}}},
+			Command:  "start",
+			UserId:   "1",
+			Expected: false,
+		},
+	}
+	for _, tc := range testcases {
+		if allowed := tc.Policy.Allowed(tc.Command, tc.UserId, tc.Roles); allowed != tc.Expected {
+			t.Errorf("%s - expected allowed to be %t but was %t", tc.Name, tc.Expected, allowed)
+		}
+	}
+}
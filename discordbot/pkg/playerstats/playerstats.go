@@ -0,0 +1,223 @@
+// Package playerstats keeps the playtime aggregates of each player, keyed by steam id, from the joins and
+// leaves of the state changes
+package playerstats
+
+import (
+	"fmt"
+	"godin/pkg/aztclient"
+	"godin/pkg/entitycodec"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/utils"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxConflictRetries is how many times a change of a player is reapplied after losing a write race
+const maxConflictRetries = 5
+
+// listBatch is how many players All reads at a time
+const listBatch = 100
+
+// Stats are the aggregates of a player, a play session runs from a join to its leave
+type Stats struct {
+	SteamId string `table:"-"`
+	// Name is the steam name the player last joined with
+	Name           string        `table:"name"`
+	Playtime       time.Duration `table:"playtime"`
+	Sessions       int           `table:"sessions"`
+	FirstSeen      time.Time     `table:"first_seen"`
+	LastSeen       time.Time     `table:"last_seen"`
+	LongestSession time.Duration `table:"longest_session"`
+	// LastJoinedAt is the join time of the last session counted, a leave recorded again isn't counted twice
+	LastJoinedAt time.Time `table:"last_joined_at"`
+}
+
+// Store keeps the stats of the players of a world in their own partition
+type Store struct {
+	rows aztclient.PartitionClientInterface
+}
+
+func NewStore(rows aztclient.PartitionClientInterface) *Store {
+	return &Store{
+		rows: rows,
+	}
+}
+
+// Changes lists the players that joined and left between two states. A player joining again without a
+// leave left and joined. Players migrated without a steam id are left out, they have no key to keep stats under
+func Changes(before, after statestorageinterface.StateAttributes) (joined, left []statestorageinterface.Player) {
+	previous, current := before.Players, after.Players
+	for _, player := range previous.Sorted() {
+		if still, ok := current[player.SteamId]; player.SteamId != "" && (!ok || !still.JoinedAt.Equal(player.JoinedAt)) {
+			left = append(left, player)
+		}
+	}
+	for _, player := range current.Sorted() {
+		if was, ok := previous[player.SteamId]; player.SteamId != "" && (!ok || !was.JoinedAt.Equal(player.JoinedAt)) {
+			joined = append(joined, player)
+		}
+	}
+	return joined, left
+}
+
+// Record updates the stats of the players that joined and left between two states, at is when they left
+func (s *Store) Record(before, after statestorageinterface.StateAttributes, at time.Time) error {
+	joined, left := Changes(before, after)
+	for _, player := range left {
+		if err := s.Left(player, at); err != nil {
+			return err
+		}
+	}
+	for _, player := range joined {
+		if err := s.Joined(player); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Joined records a player joining, the session is counted when it leaves
+func (s *Store) Joined(player statestorageinterface.Player) error {
+	return s.update(player.SteamId, func(stats *Stats) {
+		stats.Name = player.Name
+		seen(stats, player.JoinedAt)
+	})
+}
+
+// Left closes the session of a player at, a player without a join time only counts as seen. Sessions are
+// told apart by their join time, closing the same session again doesn't count it twice
+func (s *Store) Left(player statestorageinterface.Player, at time.Time) error {
+	return s.update(player.SteamId, func(stats *Stats) {
+		if player.Name != "" {
+			stats.Name = player.Name
+		}
+		seen(stats, player.JoinedAt)
+		seen(stats, at)
+		if player.JoinedAt.IsZero() || at.Before(player.JoinedAt) || stats.LastJoinedAt.Equal(player.JoinedAt.UTC()) {
+			return
+		}
+		stats.LastJoinedAt = player.JoinedAt.UTC()
+		played := at.Sub(player.JoinedAt)
+		stats.Playtime += played
+		stats.Sessions++
+		stats.LongestSession = max(stats.LongestSession, played)
+	})
+}
+
+func seen(stats *Stats, at time.Time) {
+	if at.IsZero() {
+		return
+	}
+	at = at.UTC()
+	if stats.FirstSeen.IsZero() || at.Before(stats.FirstSeen) {
+		stats.FirstSeen = at
+	}
+	if at.After(stats.LastSeen) {
+		stats.LastSeen = at
+	}
+}
+
+// update applies change to the stats of a player and saves them, reapplying it on a fresh read when they
+// were written since they were read
+func (s *Store) update(steamId string, change func(stats *Stats)) error {
+	for attempt := 1; ; attempt++ {
+		row, err := s.rows.Get(steamId)
+		if err != nil {
+			return fmt.Errorf("error reading stats of %s: %v", steamId, err)
+		}
+		stats, err := decode(row)
+		if err != nil {
+			return err
+		}
+		change(&stats)
+		properties, err := entitycodec.Encode(stats)
+		if err != nil {
+			return fmt.Errorf("error encoding stats of %s: %v", steamId, err)
+		}
+		err = s.rows.Put(aztclient.Row{Key: steamId, ETag: row.ETag, Properties: properties})
+		if err == nil {
+			return nil
+		}
+		if !utils.IsConflictError(err) || attempt > maxConflictRetries {
+			return fmt.Errorf("error writing stats of %s: %v", steamId, err)
+		}
+		log.Printf("Stats of %s changed since they were read, reapplying the change: %v", steamId, err)
+	}
+}
+
+func decode(row aztclient.Row) (Stats, error) {
+	var stats Stats
+	if err := entitycodec.Decode(row.Properties, &stats); err != nil {
+		return Stats{}, fmt.Errorf("error reading stats of %s: %v", row.Key, err)
+	}
+	stats.SteamId = row.Key
+	return stats, nil
+}
+
+// Get returns the stats of a player, false when it never joined
+func (s *Store) Get(steamId string) (Stats, bool, error) {
+	row, err := s.rows.Get(steamId)
+	if err != nil {
+		return Stats{}, false, fmt.Errorf("error reading stats of %s: %v", steamId, err)
+	}
+	if row.ETag == "" {
+		return Stats{}, false, nil
+	}
+	stats, err := decode(row)
+	return stats, err == nil, err
+}
+
+// All returns the stats of every player, in steam id order
+func (s *Store) All() ([]Stats, error) {
+	all := []Stats{}
+	after := ""
+	for {
+		rows, err := s.rows.List(after, listBatch)
+		if err != nil {
+			return nil, fmt.Errorf("error listing player stats: %v", err)
+		}
+		for _, row := range rows {
+			stats, err := decode(row)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, stats)
+		}
+		if len(rows) < listBatch {
+			return all, nil
+		}
+		after = rows[len(rows)-1].Key
+	}
+}
+
+// Leaderboard returns up to limit players with the most playtime, most first
+func (s *Store) Leaderboard(limit int) ([]Stats, error) {
+	all, err := s.All()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Playtime > all[j].Playtime })
+	return all[:min(limit, len(all))], nil
+}
+
+// Find returns the stats of the player with the steam id or the name, names are matched ignoring case
+func (s *Store) Find(player string) (Stats, bool, error) {
+	// names can have characters row keys can't, only steam ids are looked up by key
+	if player != "" && strings.Trim(player, "0123456789") == "" {
+		if stats, found, err := s.Get(player); err != nil || found {
+			return stats, found, err
+		}
+	}
+	all, err := s.All()
+	if err != nil {
+		return Stats{}, false, err
+	}
+	for _, stats := range all {
+		if strings.EqualFold(stats.Name, player) {
+			return stats, true, nil
+		}
+	}
+	return Stats{}, false, nil
+}
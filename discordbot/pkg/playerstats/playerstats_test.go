@@ -0,0 +1,103 @@
+package playerstats
+
+import (
+	"godin/pkg/memtclient"
+	"godin/pkg/statestorageinterface"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChanges(t *testing.T) {
+	joinedAt := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	player1 := statestorageinterface.Player{SteamId: "1", Name: "player1", JoinedAt: joinedAt}
+	player2 := statestorageinterface.Player{SteamId: "2", Name: "player2", JoinedAt: joinedAt.Add(time.Minute)}
+	rejoined := statestorageinterface.Player{SteamId: "2", Name: "player2", JoinedAt: joinedAt.Add(time.Hour)}
+	migrated := statestorageinterface.Player{Name: "viking"}
+	players := func(players ...statestorageinterface.Player) statestorageinterface.StateAttributes {
+		online := statestorageinterface.Players{}
+		for _, player := range players {
+			online[player.SteamId] = player
+		}
+		return statestorageinterface.StateAttributes{Players: online}
+	}
+	type testcase struct {
+		Name           string
+		Before         statestorageinterface.StateAttributes
+		After          statestorageinterface.StateAttributes
+		ExpectedJoined []statestorageinterface.Player
+		ExpectedLeft   []statestorageinterface.Player
+	}
+	testcases := []testcase{
+		{Name: "join", Before: players(player1), After: players(player1, player2), ExpectedJoined: []statestorageinterface.Player{player2}},
+		{Name: "leave", Before: players(player1, player2), After: players(player2), ExpectedLeft: []statestorageinterface.Player{player1}},
+		{Name: "stop", Before: players(player1, player2), After: players(), ExpectedLeft: []statestorageinterface.Player{player1, player2}},
+		{Name: "join again", Before: players(player1, player2), After: players(player1, rejoined), ExpectedJoined: []statestorageinterface.Player{rejoined}, ExpectedLeft: []statestorageinterface.Player{player2}},
+		{Name: "migrated", Before: players(migrated), After: players()},
+	}
+	for _, tc := range testcases {
+		joined, left := Changes(tc.Before, tc.After)
+		if !reflect.DeepEqual(joined, tc.ExpectedJoined) || !reflect.DeepEqual(left, tc.ExpectedLeft) {
+			t.Errorf("%s - expected %v to join and %v to leave but were %v and %v", tc.Name, tc.ExpectedJoined, tc.ExpectedLeft, joined, left)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore(memtclient.NewPartitionClient(memtclient.NewTable(), "valheim-players-world"))
+	start := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	player1 := statestorageinterface.Player{SteamId: "76561198073103840", Name: "player1", JoinedAt: start}
+	player2 := statestorageinterface.Player{SteamId: "76561198073103841", Name: "player2", JoinedAt: start.Add(time.Hour)}
+	online := func(players ...statestorageinterface.Player) statestorageinterface.StateAttributes {
+		online := statestorageinterface.Players{}
+		for _, player := range players {
+			online.Add(player)
+		}
+		return statestorageinterface.StateAttributes{Players: online}
+	}
+	rejoined := player1
+	rejoined.JoinedAt = start.Add(4 * time.Hour)
+	steps := []struct {
+		Before statestorageinterface.StateAttributes
+		After  statestorageinterface.StateAttributes
+		At     time.Time
+	}{
+		{Before: online(), After: online(player1), At: start},
+		{Before: online(player1), After: online(player1, player2), At: start.Add(time.Hour)},
+		{Before: online(player1, player2), After: online(player2), At: start.Add(3 * time.Hour)},
+		// a retried event records its change again
+		{Before: online(player1, player2), After: online(player2), At: start.Add(3 * time.Hour)},
+		{Before: online(player2), After: online(player2, rejoined), At: rejoined.JoinedAt},
+		{Before: online(player2, rejoined), After: online(), At: start.Add(5 * time.Hour)},
+	}
+	for i, step := range steps {
+		if err := store.Record(step.Before, step.After, step.At); err != nil {
+			t.Fatalf("step %d - error recording player changes: %v", i, err)
+		}
+	}
+
+	expected := []Stats{
+		{SteamId: player1.SteamId, Name: "player1", Playtime: 4 * time.Hour, Sessions: 2, FirstSeen: start, LastSeen: start.Add(5 * time.Hour), LongestSession: 3 * time.Hour, LastJoinedAt: rejoined.JoinedAt},
+		{SteamId: player2.SteamId, Name: "player2", Playtime: 4 * time.Hour, Sessions: 1, FirstSeen: start.Add(time.Hour), LastSeen: start.Add(5 * time.Hour), LongestSession: 4 * time.Hour, LastJoinedAt: player2.JoinedAt},
+	}
+	leaders, err := store.Leaderboard(10)
+	if err != nil {
+		t.Fatalf("error reading the leaderboard: %v", err)
+	}
+	if !reflect.DeepEqual(leaders, expected) {
+		t.Errorf("expected the leaderboard to be %+v but was %+v", expected, leaders)
+	}
+	if leaders, _ := store.Leaderboard(1); len(leaders) != 1 {
+		t.Errorf("expected the leaderboard to be cut to 1 player but was %+v", leaders)
+	}
+
+	for _, query := range []string{"76561198073103841", "PLAYER2"} {
+		stats, found, err := store.Find(query)
+		if err != nil || !found || !reflect.DeepEqual(stats, expected[1]) {
+			t.Errorf("%s - expected to find player2 but got %+v, %v, %v", query, stats, found, err)
+		}
+	}
+	if _, found, err := store.Find("player3"); err != nil || found {
+		t.Errorf("expected player3 not to be found but got %v, %v", found, err)
+	}
+}
@@ -0,0 +1,202 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrMessageNotFound is returned when deleting a message that was already deleted,
+// or whose pop receipt is stale because it was dequeued again after its visibility timeout
+var ErrMessageNotFound = errors.New("message not found or pop receipt mismatch")
+
+type Message struct {
+	Id           string
+	PopReceipt   string
+	Text         string
+	DequeueCount int64
+}
+
+// Queue mirrors the semantics of azure storage queues: a dequeued message stays invisible for the visibility
+// timeout and comes back, with its dequeue count incremented, unless it's deleted before that
+type Queue interface {
+	Enqueue(text string) error
+	// Dequeue returns nil when there is no visible message
+	Dequeue(visibilityTimeout time.Duration) (*Message, error)
+	Delete(msg *Message) error
+}
+
+type entry struct {
+	Id           string    `json:"id"`
+	PopReceipt   string    `json:"pop_receipt"`
+	Text         string    `json:"text"`
+	DequeueCount int64     `json:"dequeue_count"`
+	EnqueuedAt   time.Time `json:"enqueued_at"`
+	VisibleAt    time.Time `json:"visible_at"`
+}
+
+// entries holds the queue logic shared by the memory and file queues
+type entries []entry
+
+func (e entries) enqueue(text string, now time.Time) entries {
+	return append(e, entry{
+		Id:         uuid.NewString(),
+		Text:       text,
+		EnqueuedAt: now,
+		VisibleAt:  now,
+	})
+}
+
+func (e entries) dequeue(visibilityTimeout time.Duration, now time.Time) *Message {
+	sort.SliceStable(e, func(i, j int) bool { return e[i].EnqueuedAt.Before(e[j].EnqueuedAt) })
+	for i := range e {
+		if e[i].VisibleAt.After(now) {
+			continue
+		}
+		e[i].DequeueCount++
+		e[i].PopReceipt = uuid.NewString()
+		e[i].VisibleAt = now.Add(visibilityTimeout)
+		return &Message{
+			Id:           e[i].Id,
+			PopReceipt:   e[i].PopReceipt,
+			Text:         e[i].Text,
+			DequeueCount: e[i].DequeueCount,
+		}
+	}
+	return nil
+}
+
+func (e entries) delete(msg *Message) (entries, error) {
+	for i := range e {
+		if e[i].Id == msg.Id && e[i].PopReceipt == msg.PopReceipt {
+			return append(e[:i], e[i+1:]...), nil
+		}
+	}
+	return e, ErrMessageNotFound
+}
+
+// MemoryQueue is an in-process queue for tests
+type MemoryQueue struct {
+	mu      sync.Mutex
+	entries entries
+	now     func() time.Time
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		now: time.Now,
+	}
+}
+
+func (mq *MemoryQueue) Enqueue(text string) error {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	mq.entries = mq.entries.enqueue(text, mq.now())
+	return nil
+}
+
+func (mq *MemoryQueue) Dequeue(visibilityTimeout time.Duration) (*Message, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	return mq.entries.dequeue(visibilityTimeout, mq.now()), nil
+}
+
+func (mq *MemoryQueue) Delete(msg *Message) error {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	var err error
+	mq.entries, err = mq.entries.delete(msg)
+	return err
+}
+
+// FileQueue is a durable queue kept in a json file, for local runs. It's safe for concurrent use
+// within a process, not across processes sharing the file
+type FileQueue struct {
+	mu   sync.Mutex
+	path string
+	now  func() time.Time
+}
+
+func NewFileQueue(path string) (*FileQueue, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating queue directory: %v", err)
+	}
+	return &FileQueue{
+		path: path,
+		now:  time.Now,
+	}, nil
+}
+
+func (fq *FileQueue) load() (entries, error) {
+	contentBytes, err := os.ReadFile(fq.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading queue file: %v", err)
+	}
+	var e entries
+	if err := json.Unmarshal(contentBytes, &e); err != nil {
+		return nil, fmt.Errorf("error unmarshalling queue file %s: %v", fq.path, err)
+	}
+	return e, nil
+}
+
+// save writes to a temporary file and renames it over the queue file, so a crash never leaves it half written
+func (fq *FileQueue) save(e entries) error {
+	contentBytes, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling queue: %v", err)
+	}
+	tmp := fq.path + ".tmp" + strconv.Itoa(os.Getpid())
+	if err := os.WriteFile(tmp, contentBytes, 0644); err != nil {
+		return fmt.Errorf("error writing queue file: %v", err)
+	}
+	return os.Rename(tmp, fq.path)
+}
+
+func (fq *FileQueue) Enqueue(text string) error {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	e, err := fq.load()
+	if err != nil {
+		return err
+	}
+	return fq.save(e.enqueue(text, fq.now()))
+}
+
+func (fq *FileQueue) Dequeue(visibilityTimeout time.Duration) (*Message, error) {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	e, err := fq.load()
+	if err != nil {
+		return nil, err
+	}
+	msg := e.dequeue(visibilityTimeout, fq.now())
+	if msg == nil {
+		return nil, nil
+	}
+	return msg, fq.save(e)
+}
+
+func (fq *FileQueue) Delete(msg *Message) error {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	e, err := fq.load()
+	if err != nil {
+		return err
+	}
+	e, err = e.delete(msg)
+	if err != nil {
+		return err
+	}
+	return fq.save(e)
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQueues(t *testing.T) {
+	type testcase struct {
+		Name  string
+		Queue func(now func() time.Time) Queue
+	}
+	dir := t.TempDir()
+	testcases := []testcase{
+		{
+			Name: "memory",
+			Queue: func(now func() time.Time) Queue {
+				mq := NewMemoryQueue()
+				mq.now = now
+				return mq
+			},
+		},
+		{
+			Name: "file",
+			Queue: func(now func() time.Time) Queue {
+				fq, err := NewFileQueue(filepath.Join(dir, "queues", "events.json"))
+				if err != nil {
+					t.Fatalf("error creating file queue: %v", err)
+				}
+				fq.now = now
+				return fq
+			},
+		},
+	}
+	for _, tc := range testcases {
+		now := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+		q := tc.Queue(func() time.Time { return now })
+
+		if msg, err := q.Dequeue(time.Minute); err != nil || msg != nil {
+			t.Errorf("%s - expected empty queue to dequeue nothing but was %v (%v)", tc.Name, msg, err)
+		}
+		for _, text := range []string{"first", "second"} {
+			if err := q.Enqueue(text); err != nil {
+				t.Fatalf("%s - error enqueuing: %v", tc.Name, err)
+			}
+			now = now.Add(time.Second)
+		}
+
+		first, err := q.Dequeue(time.Minute)
+		if err != nil || first == nil || first.Text != "first" || first.DequeueCount != 1 {
+			t.Fatalf("%s - expected to dequeue first once but was %v (%v)", tc.Name, first, err)
+		}
+		second, _ := q.Dequeue(time.Minute)
+		if second == nil || second.Text != "second" {
+			t.Fatalf("%s - expected first to be invisible and second dequeued but was %v", tc.Name, second)
+		}
+		if msg, _ := q.Dequeue(time.Minute); msg != nil {
+			t.Errorf("%s - expected nothing visible but was %v", tc.Name, msg)
+		}
+		if err := q.Delete(second); err != nil {
+			t.Errorf("%s - error deleting second: %v", tc.Name, err)
+		}
+
+		now = now.Add(2 * time.Minute)
+		again, _ := q.Dequeue(time.Minute)
+		if again == nil || again.Id != first.Id || again.DequeueCount != 2 {
+			t.Fatalf("%s - expected first back after its visibility timeout with dequeue count 2 but was %v", tc.Name, again)
+		}
+		if err := q.Delete(first); err != ErrMessageNotFound {
+			t.Errorf("%s - expected delete with a stale pop receipt to fail but was %v", tc.Name, err)
+		}
+		if err := q.Delete(again); err != nil {
+			t.Errorf("%s - error deleting first: %v", tc.Name, err)
+		}
+		if msg, _ := q.Dequeue(time.Minute); msg != nil {
+			t.Errorf("%s - expected queue to be empty but was %v", tc.Name, msg)
+		}
+	}
+}
+
+func TestFileQueueDurable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	fq, _ := NewFileQueue(path)
+	if err := fq.Enqueue("start"); err != nil {
+		t.Fatalf("error enqueuing: %v", err)
+	}
+	reopened, _ := NewFileQueue(path)
+	msg, err := reopened.Dequeue(time.Minute)
+	if err != nil || msg == nil || msg.Text != "start" {
+		t.Errorf("expected reopened queue to dequeue start but was %v (%v)", msg, err)
+	}
+}
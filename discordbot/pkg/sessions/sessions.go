@@ -0,0 +1,141 @@
+// Package sessions keeps the ended start→stop cycles of the server, newest first, to account for how long the vm ran
+package sessions
+
+import (
+	"fmt"
+	"godin/pkg/aztclient"
+	"godin/pkg/entitycodec"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/utils"
+	"log"
+	"time"
+)
+
+// listBatch is how many sessions Since reads at a time
+const listBatch = 50
+
+// Store keeps the sessions of a world in their own partition
+type Store struct {
+	rows aztclient.PartitionClientInterface
+}
+
+func NewStore(rows aztclient.PartitionClientInterface) *Store {
+	return &Store{
+		rows: rows,
+	}
+}
+
+// key sorts sessions by the time they were requested, newest first
+func key(session statestorageinterface.Session) string {
+	return aztclient.NewestFirstKey(session.RequestedAt) + "-" + session.Id
+}
+
+// Record appends an ended session, recording it again does nothing so retries can go through
+func (s *Store) Record(session statestorageinterface.Session) error {
+	if session.IsOpen() {
+		return fmt.Errorf("error recording session %s: it is still open", session.Id)
+	}
+	properties, err := entitycodec.Encode(session)
+	if err != nil {
+		return fmt.Errorf("error recording session %s: %v", session.Id, err)
+	}
+	if err := s.rows.Put(aztclient.Row{Key: key(session), Properties: properties}); err != nil {
+		if utils.IsConflictError(err) {
+			log.Printf("Session %s was already recorded", session.Id)
+			return nil
+		}
+		return fmt.Errorf("error recording session %s: %v", session.Id, err)
+	}
+	return nil
+}
+
+// Recent returns up to limit sessions, newest first
+func (s *Store) Recent(limit int) ([]statestorageinterface.Session, error) {
+	rows, err := s.rows.List("", limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %v", err)
+	}
+	return decode(rows)
+}
+
+// Since returns the sessions requested at or after since, newest first
+func (s *Store) Since(since time.Time) ([]statestorageinterface.Session, error) {
+	sessions := []statestorageinterface.Session{}
+	after := ""
+	for {
+		rows, err := s.rows.List(after, listBatch)
+		if err != nil {
+			return nil, fmt.Errorf("error listing sessions: %v", err)
+		}
+		batch, err := decode(rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, session := range batch {
+			if session.RequestedAt.Before(since) {
+				return sessions, nil
+			}
+			sessions = append(sessions, session)
+		}
+		if len(rows) < listBatch {
+			return sessions, nil
+		}
+		after = rows[len(rows)-1].Key
+	}
+}
+
+func decode(rows []aztclient.Row) ([]statestorageinterface.Session, error) {
+	sessions := []statestorageinterface.Session{}
+	for _, row := range rows {
+		var session statestorageinterface.Session
+		if err := entitycodec.Decode(row.Properties, &session); err != nil {
+			return nil, fmt.Errorf("error reading session %s: %v", row.Key, err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// Totals sum up the sessions requested in a month
+type Totals struct {
+	// Month is the first instant of the month, in UTC
+	Month    time.Time
+	Sessions int
+	Uptime   time.Duration
+	// Players are the distinct players that joined in the month
+	Players int
+}
+
+// MonthlyTotals sums sessions up by the UTC month they were requested in, for the months months up to the
+// one of now, newest first. Open sessions count up to now, months without sessions are left out
+func MonthlyTotals(sessions []statestorageinterface.Session, months int, now time.Time) []Totals {
+	now = now.UTC()
+	current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	totals := make([]Totals, months)
+	players := make([]map[string]bool, months)
+	for i := range totals {
+		totals[i].Month = current.AddDate(0, -i, 0)
+		players[i] = map[string]bool{}
+	}
+	for _, session := range sessions {
+		requested := session.RequestedAt.UTC()
+		i := (current.Year()-requested.Year())*12 + int(current.Month()-requested.Month())
+		if i < 0 || i >= months {
+			continue
+		}
+		totals[i].Sessions++
+		totals[i].Uptime += session.Uptime(now)
+		for player := range session.Players {
+			players[i][player] = true
+		}
+	}
+	counted := []Totals{}
+	for i := range totals {
+		if totals[i].Sessions == 0 {
+			continue
+		}
+		totals[i].Players = len(players[i])
+		counted = append(counted, totals[i])
+	}
+	return counted
+}
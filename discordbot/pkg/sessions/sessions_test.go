@@ -0,0 +1,73 @@
+package sessions
+
+import (
+	"godin/pkg/memtclient"
+	"godin/pkg/statestorageinterface"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	store := NewStore(memtclient.NewPartitionClient(memtclient.NewTable(), "valheim-sessions-world"))
+	start := time.Date(2024, 9, 28, 20, 0, 0, 0, time.UTC)
+	recorded := []statestorageinterface.Session{}
+	for i, id := range []string{"start1", "start2", "start3"} {
+		requested := start.Add(time.Duration(i) * 7 * 24 * time.Hour)
+		session := statestorageinterface.Session{
+			Id:          id,
+			RequestedAt: requested,
+			StartedAt:   requested.Add(time.Minute),
+			ListeningAt: requested.Add(3 * time.Minute),
+			EndedAt:     requested.Add(2 * time.Hour),
+			EndStatus:   "stopped",
+			PeakPlayers: 1,
+			Players:     map[string]string{"1": "player1", id: id},
+		}
+		if err := store.Record(session); err != nil {
+			t.Fatalf("error recording session %s: %v", id, err)
+		}
+		recorded = append(recorded, session)
+	}
+	if err := store.Record(recorded[1]); err != nil {
+		t.Errorf("expected recording a session again to do nothing but got %v", err)
+	}
+	if err := store.Record(statestorageinterface.Session{Id: "open", RequestedAt: start}); err == nil {
+		t.Errorf("expected recording an open session to fail")
+	}
+
+	recent, err := store.Recent(2)
+	if err != nil {
+		t.Fatalf("error reading recent sessions: %v", err)
+	}
+	if !reflect.DeepEqual(recent, []statestorageinterface.Session{recorded[2], recorded[1]}) {
+		t.Errorf("expected the last two sessions newest first but were %v", recent)
+	}
+	since, err := store.Since(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("error reading sessions since october: %v", err)
+	}
+	if len(since) != 2 || since[0].Id != "start3" || since[1].Id != "start2" {
+		t.Errorf("expected the october sessions but were %v", since)
+	}
+}
+
+func TestMonthlyTotals(t *testing.T) {
+	now := time.Date(2024, 10, 20, 12, 0, 0, 0, time.UTC)
+	sessions := []statestorageinterface.Session{
+		{RequestedAt: now.Add(-time.Hour), Players: map[string]string{"1": "player1"}},
+		{RequestedAt: time.Date(2024, 10, 2, 20, 0, 0, 0, time.UTC), EndedAt: time.Date(2024, 10, 2, 22, 0, 0, 0, time.UTC), Players: map[string]string{"1": "player1", "2": "player2"}},
+		{RequestedAt: time.Date(2024, 9, 30, 23, 0, 0, 0, time.UTC), EndedAt: time.Date(2024, 10, 1, 1, 0, 0, 0, time.UTC)},
+		{RequestedAt: time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC), EndedAt: time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC)},
+	}
+	expected := []Totals{
+		{Month: time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), Sessions: 2, Uptime: 3 * time.Hour, Players: 2},
+		{Month: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), Sessions: 1, Uptime: 2 * time.Hour},
+	}
+	if totals := MonthlyTotals(sessions, 3, now); !reflect.DeepEqual(totals, expected) {
+		t.Errorf("expected totals to be %v but were %v", expected, totals)
+	}
+	if totals := MonthlyTotals(nil, 3, now); len(totals) != 0 {
+		t.Errorf("expected no totals without sessions but were %v", totals)
+	}
+}
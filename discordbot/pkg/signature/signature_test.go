@@ -0,0 +1,75 @@
+package signature
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	signer, err := NewSigner()
+	if err != nil {
+		t.Fatalf("error creating signer: %v", err)
+	}
+	verifier, err := NewEd25519Verifier(signer.PublicKey(), DefaultMaxAge)
+	if err != nil {
+		t.Fatalf("error creating verifier: %v", err)
+	}
+	type testcase struct {
+		Name          string
+		SignedBody    string
+		SentBody      string
+		SignedAt      time.Time
+		Unsigned      bool
+		ExpectedError error
+	}
+	body := `{"type":1}`
+	testcases := []testcase{
+		{Name: "valid", SignedBody: body, SentBody: body, SignedAt: time.Now()},
+		{Name: "tampered body", SignedBody: body, SentBody: `{"type":2}`, SignedAt: time.Now(), ExpectedError: ErrInvalidSignature},
+		{Name: "replayed", SignedBody: body, SentBody: body, SignedAt: time.Now().Add(-10 * time.Minute), ExpectedError: ErrStaleTimestamp},
+		{Name: "from the future", SignedBody: body, SentBody: body, SignedAt: time.Now().Add(10 * time.Minute), ExpectedError: ErrStaleTimestamp},
+		{Name: "unsigned", SentBody: body, Unsigned: true, ExpectedError: ErrMissingSignature},
+	}
+	for _, tc := range testcases {
+		req, _ := http.NewRequest(http.MethodPost, "/api/interactions", strings.NewReader(tc.SentBody))
+		if !tc.Unsigned {
+			signer.SignAt(req, []byte(tc.SignedBody), tc.SignedAt)
+		}
+		err := verifier.Verify(req)
+		if !errors.Is(err, tc.ExpectedError) {
+			t.Errorf("%s - expected error to be %v but was %v", tc.Name, tc.ExpectedError, err)
+		}
+		if err == nil {
+			readBody, _ := io.ReadAll(req.Body)
+			if string(readBody) != tc.SentBody {
+				t.Errorf("%s - expected body to be re-attached as %s but was %s", tc.Name, tc.SentBody, readBody)
+			}
+		}
+	}
+}
+
+func TestInvalidPublicKey(t *testing.T) {
+	for _, key := range []string{"", "not hex", "abcd"} {
+		if _, err := NewEd25519Verifier(key, DefaultMaxAge); err == nil {
+			t.Errorf("expected public key %q to be rejected", key)
+		}
+	}
+}
+
+func TestSignerVerifierClock(t *testing.T) {
+	signer, err := NewSigner()
+	if err != nil {
+		t.Fatalf("error creating signer: %v", err)
+	}
+	// a signer with a stopped clock signs and verifies at the same time
+	signer.now = func() time.Time { return time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC) }
+	req, _ := http.NewRequest(http.MethodPost, "/api/interactions", strings.NewReader(`{"type":1}`))
+	signer.Sign(req, []byte(`{"type":1}`))
+	if err := signer.Verifier(DefaultMaxAge).Verify(req); err != nil {
+		t.Errorf("expected the verifier to share the clock of the signer but got %v", err)
+	}
+}
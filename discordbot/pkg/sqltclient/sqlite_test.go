@@ -0,0 +1,25 @@
+package sqltclient
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// TestMain points the conformance test to a sqlite database when no other one is set
+func TestMain(m *testing.M) {
+	if os.Getenv("SQL_TEST_DRIVER") != "" {
+		os.Exit(m.Run())
+	}
+	dir, err := os.MkdirTemp("", "sqltclient")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("SQL_TEST_DRIVER", "sqlite")
+	os.Setenv("SQL_TEST_DSN", filepath.Join(dir, "state.db"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
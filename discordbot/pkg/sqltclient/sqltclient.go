@@ -0,0 +1,243 @@
+package sqltclient
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"godin/pkg/aztclient"
+	"godin/pkg/entitycodec"
+	"godin/pkg/godinerrors"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/utils"
+)
+
+// schema keeps one row per entity, its properties as json and an etag bumped on every write like azure tables do
+const schema = `CREATE TABLE IF NOT EXISTS entities (
+	partition_key VARCHAR(255) NOT NULL,
+	row_key VARCHAR(255) NOT NULL,
+	etag INTEGER NOT NULL,
+	properties TEXT NOT NULL,
+	PRIMARY KEY (partition_key, row_key)
+)`
+
+// Open connects to the database and creates the entities table. The driver has to be registered by the
+// binary, godin built with the sqlite tag registers modernc.org/sqlite, queries use ? placeholders
+func Open(driverName, dataSourceName string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s database: %v", driverName, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating entities table: %v", err)
+	}
+	return db, nil
+}
+
+// TableClient is a client for one entity of the entities table
+type TableClient struct {
+	db           *sql.DB
+	partitionKey string
+	rowKey       string
+	etag         int64
+}
+
+// NewTableClient creates a TableClient for one entity of the database opened with Open
+func NewTableClient(db *sql.DB, partitionKey, rowKey string) aztclient.TableClientInterface {
+	return &TableClient{
+		db:           db,
+		partitionKey: partitionKey,
+		rowKey:       rowKey,
+	}
+}
+
+func (tc *TableClient) selectEntity() (int64, string, error) {
+	var etag int64
+	var properties string
+	err := tc.db.QueryRow(
+		"SELECT etag, properties FROM entities WHERE partition_key = ? AND row_key = ?",
+		tc.partitionKey, tc.rowKey,
+	).Scan(&etag, &properties)
+	return etag, properties, err
+}
+
+// Read returns the entity properties, creating the entity when it doesn't exist yet
+func (tc *TableClient) Read(columns ...string) (map[string]interface{}, error) {
+	etag, propertiesJson, err := tc.selectEntity()
+	if errors.Is(err, sql.ErrNoRows) {
+		// a concurrent reader may create it first, the insert then fails and its row is read instead
+		if _, err := tc.db.Exec(
+			"INSERT INTO entities (partition_key, row_key, etag, properties) VALUES (?, ?, 1, '{}')",
+			tc.partitionKey, tc.rowKey,
+		); err != nil {
+			etag, propertiesJson, err = tc.selectEntity()
+			if err != nil {
+				return nil, fmt.Errorf("error creating entity: %v", err)
+			}
+		} else {
+			etag, propertiesJson = 1, "{}"
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading entity: %v", err)
+	}
+	tc.etag = etag
+	properties := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(propertiesJson), &properties); err != nil {
+		return nil, fmt.Errorf("error unmarshalling entity properties: %v", err)
+	}
+	if err := utils.ValidateColumns(properties, columns); err != nil {
+		return nil, godinerrors.ReadError{
+			Code:    godinerrors.MissingColumnError,
+			Message: err.Error(),
+		}
+	}
+	return properties, nil
+}
+
+// Write replaces the entity properties, failing with a conflict if it was written since it was read
+func (tc *TableClient) Write(state statestorageinterface.StateAttributes) error {
+	properties, err := entitycodec.Encode(state)
+	if err != nil {
+		return err
+	}
+	stateBytes, err := json.Marshal(properties)
+	if err != nil {
+		return fmt.Errorf("error marshalling state: %v", err)
+	}
+	result, err := tc.db.Exec(
+		"UPDATE entities SET etag = ?, properties = ? WHERE partition_key = ? AND row_key = ? AND etag = ?",
+		tc.etag+1, string(stateBytes), tc.partitionKey, tc.rowKey, tc.etag,
+	)
+	if err != nil {
+		return fmt.Errorf("error writing entity: %v", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error writing entity: %v", err)
+	}
+	if updated == 0 {
+		return godinerrors.WriteError{
+			Code:    godinerrors.ConflictError,
+			Message: fmt.Sprintf("error writing entity %s/%s: it changed since etag %d was read", tc.partitionKey, tc.rowKey, tc.etag),
+		}
+	}
+	tc.etag++
+	return nil
+}
+
+// PartitionClient is a partition of the entities table
+type PartitionClient struct {
+	db           *sql.DB
+	partitionKey string
+}
+
+// NewPartitionClient creates a PartitionClient for partitionKey in the database opened with Open
+func NewPartitionClient(db *sql.DB, partitionKey string) aztclient.PartitionClientInterface {
+	return &PartitionClient{
+		db:           db,
+		partitionKey: partitionKey,
+	}
+}
+
+func scanRow(key string, etag int64, propertiesJson string) (aztclient.Row, error) {
+	properties := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(propertiesJson), &properties); err != nil {
+		return aztclient.Row{}, fmt.Errorf("error unmarshalling row %s: %v", key, err)
+	}
+	return aztclient.Row{Key: key, ETag: strconv.FormatInt(etag, 10), Properties: properties}, nil
+}
+
+func (pc *PartitionClient) Get(key string) (aztclient.Row, error) {
+	var etag int64
+	var properties string
+	err := pc.db.QueryRow(
+		"SELECT etag, properties FROM entities WHERE partition_key = ? AND row_key = ?",
+		pc.partitionKey, key,
+	).Scan(&etag, &properties)
+	if errors.Is(err, sql.ErrNoRows) {
+		return aztclient.Row{Key: key}, nil
+	}
+	if err != nil {
+		return aztclient.Row{}, fmt.Errorf("error reading row %s: %v", key, err)
+	}
+	return scanRow(key, etag, properties)
+}
+
+func (pc *PartitionClient) Put(row aztclient.Row) error {
+	propertiesBytes, err := json.Marshal(row.Properties)
+	if err != nil {
+		return fmt.Errorf("error marshalling row %s: %v", row.Key, err)
+	}
+	conflict := godinerrors.WriteError{
+		Code:    godinerrors.ConflictError,
+		Message: fmt.Sprintf("error writing row %s/%s: it changed since etag %q was read", pc.partitionKey, row.Key, row.ETag),
+	}
+	if row.ETag == "" {
+		_, err := pc.db.Exec(
+			"INSERT INTO entities (partition_key, row_key, etag, properties) VALUES (?, ?, 1, ?)",
+			pc.partitionKey, row.Key, string(propertiesBytes),
+		)
+		if err != nil {
+			// drivers word primary key violations their own way, a row that exists now is one
+			if existing, getErr := pc.Get(row.Key); getErr == nil && existing.ETag != "" {
+				return conflict
+			}
+			return fmt.Errorf("error writing row %s: %v", row.Key, err)
+		}
+		return nil
+	}
+	etag, err := strconv.ParseInt(row.ETag, 10, 64)
+	if err != nil {
+		return conflict
+	}
+	result, err := pc.db.Exec(
+		"UPDATE entities SET etag = ?, properties = ? WHERE partition_key = ? AND row_key = ? AND etag = ?",
+		etag+1, string(propertiesBytes), pc.partitionKey, row.Key, etag,
+	)
+	if err != nil {
+		return fmt.Errorf("error writing row %s: %v", row.Key, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error writing row %s: %v", row.Key, err)
+	}
+	if updated == 0 {
+		return conflict
+	}
+	return nil
+}
+
+func (pc *PartitionClient) List(after string, limit int) ([]aztclient.Row, error) {
+	result, err := pc.db.Query(
+		"SELECT row_key, etag, properties FROM entities WHERE partition_key = ? AND row_key > ? ORDER BY row_key LIMIT ?",
+		pc.partitionKey, after, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing rows: %v", err)
+	}
+	defer result.Close()
+	rows := []aztclient.Row{}
+	for result.Next() {
+		var key, properties string
+		var etag int64
+		if err := result.Scan(&key, &etag, &properties); err != nil {
+			return nil, fmt.Errorf("error listing rows: %v", err)
+		}
+		row, err := scanRow(key, etag, properties)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, result.Err()
+}
+
+func (pc *PartitionClient) Delete(key string) error {
+	if _, err := pc.db.Exec("DELETE FROM entities WHERE partition_key = ? AND row_key = ?", pc.partitionKey, key); err != nil {
+		return fmt.Errorf("error deleting row %s: %v", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package sqltclient
+
+import (
+	"database/sql"
+	"godin/pkg/aztclient"
+	"godin/pkg/tclienttest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestConformance runs against the database of SQL_TEST_DRIVER and SQL_TEST_DSN, a sqlite file by default,
+// other drivers have to be registered in the test binary, see sqlite_test.go
+func TestConformance(t *testing.T) {
+	driver, dsn := os.Getenv("SQL_TEST_DRIVER"), os.Getenv("SQL_TEST_DSN")
+	if driver == "" || !registered(driver) {
+		t.Skip("no sql driver to test against, set SQL_TEST_DRIVER and SQL_TEST_DSN")
+	}
+	db, err := Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	defer db.Close()
+	// rows are left behind, a prefix per run keeps runs apart
+	prefix := strconv.FormatInt(time.Now().UnixNano(), 10) + "-"
+	tclienttest.Run(t, func(partitionKey, rowKey string) (aztclient.TableClientInterface, error) {
+		return NewTableClient(db, partitionKey, rowKey), nil
+	}, prefix)
+	tclienttest.RunPartition(t, func(partitionKey string) (aztclient.PartitionClientInterface, error) {
+		return NewPartitionClient(db, partitionKey), nil
+	}, prefix)
+}
+
+func registered(driver string) bool {
+	for _, name := range sql.Drivers() {
+		if name == driver {
+			return true
+		}
+	}
+	return false
+}
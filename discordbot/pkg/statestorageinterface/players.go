@@ -0,0 +1,72 @@
+package statestorageinterface
+
+import (
+	"maps"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Player is a player online on the server
+type Player struct {
+	SteamId  string    `json:"steam_id"`
+	Name     string    `json:"name"`
+	JoinedAt time.Time `json:"joined_at"`
+	// Character is the in-game character the player spawned with, empty until the server logs it
+	Character string `json:"character,omitempty"`
+}
+
+// Players are the online players keyed by steam id, kept as json in the players column
+type Players map[string]Player
+
+// PlayersFromNames migrates the comma delimited names of the online_players column. Those players have no
+// steam id, so they are keyed by name until they leave or join again
+func PlayersFromNames(names string) Players {
+	players := Players{}
+	for _, name := range strings.Split(names, ",") {
+		if name != "" {
+			players[name] = Player{Name: name}
+		}
+	}
+	return players
+}
+
+// Clone copies the players, so changing the copy leaves the attributes they came from as they were
+func (p Players) Clone() Players {
+	return maps.Clone(p)
+}
+
+// Add records a join, a player joining again replaces its previous record
+func (p Players) Add(player Player) {
+	p.removeMigrated(player.Name)
+	p[player.SteamId] = player
+}
+
+// Remove records a leave, name is needed to find players migrated without a steam id
+func (p Players) Remove(steamId, name string) {
+	delete(p, steamId)
+	p.removeMigrated(name)
+}
+
+func (p Players) removeMigrated(name string) {
+	for key, player := range p {
+		if player.SteamId == "" && player.Name == name {
+			delete(p, key)
+		}
+	}
+}
+
+// Sorted returns the players in the order they joined
+func (p Players) Sorted() []Player {
+	players := make([]Player, 0, len(p))
+	for _, player := range p {
+		players = append(players, player)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		if !players[i].JoinedAt.Equal(players[j].JoinedAt) {
+			return players[i].JoinedAt.Before(players[j].JoinedAt)
+		}
+		return players[i].Name < players[j].Name
+	})
+	return players
+}
@@ -0,0 +1,37 @@
+package statestorageinterface
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlayers(t *testing.T) {
+	players := PlayersFromNames("player1,player2,")
+	if len(players) != 2 {
+		t.Fatalf("expected 2 migrated players but got %v", players)
+	}
+	joinedAt := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	players.Add(Player{SteamId: "76561198073103840", Name: "player1", JoinedAt: joinedAt})
+	players.Add(Player{SteamId: "76561198073103840", Name: "player1", JoinedAt: joinedAt.Add(time.Minute)})
+	players.Add(Player{SteamId: "76561198073103842", Name: "player3", JoinedAt: joinedAt})
+	sorted := players.Sorted()
+	expectedNames := []string{"player2", "player3", "player1"}
+	if len(sorted) != len(expectedNames) {
+		t.Fatalf("expected players %v but got %v", expectedNames, sorted)
+	}
+	for i, name := range expectedNames {
+		if sorted[i].Name != name {
+			t.Errorf("expected player %d to be %s but was %s", i, name, sorted[i].Name)
+		}
+	}
+	players.Remove("76561198073103841", "player2")
+	players.Remove("76561198073103840", "player1")
+	if len(players) != 1 || !players["76561198073103842"].JoinedAt.Equal(joinedAt) {
+		t.Errorf("expected only player3 to be left but got %v", players)
+	}
+	clone := players.Clone()
+	clone.Remove("76561198073103842", "player3")
+	if len(clone) != 0 || len(players) != 1 {
+		t.Errorf("expected removing from a clone to leave the players as they were but got %v", players)
+	}
+}
@@ -0,0 +1,61 @@
+package statestorageinterface
+
+import (
+	"maps"
+	"time"
+)
+
+// Session is a start→stop cycle of the server, the session column keeps the open or the last one as json
+// and the ended ones are kept as rows by sessions.Store
+type Session struct {
+	// Id is the correlation id of the start event
+	Id          string    `json:"id" table:"id"`
+	RequestedAt time.Time `json:"requested_at" table:"requested_at"`
+	// StartedAt is when the scale up returned, ListeningAt when the game server first listened
+	StartedAt   time.Time `json:"started_at" table:"started_at"`
+	ListeningAt time.Time `json:"listening_at" table:"listening_at"`
+	// EndedAt is when the vm stopped running, EndStatus the status it ended with: stopped, failed or evicted
+	EndedAt     time.Time `json:"ended_at" table:"ended_at"`
+	EndStatus   string    `json:"end_status" table:"end_status"`
+	PeakPlayers int       `json:"peak_players" table:"peak_players"`
+	// Players are the names of the distinct players that joined, keyed by steam id
+	Players map[string]string `json:"players" table:"players"`
+}
+
+// Clone copies the session, so changing the copy leaves the attributes it came from as it was
+func (s Session) Clone() Session {
+	s.Players = maps.Clone(s.Players)
+	return s
+}
+
+// IsOpen tells whether the vm of the session is still running
+func (s Session) IsOpen() bool {
+	return !s.RequestedAt.IsZero() && s.EndedAt.IsZero()
+}
+
+// ScaleUpLatency is how long the scale up took, 0 when the server listened before it returned
+func (s Session) ScaleUpLatency() time.Duration {
+	if s.StartedAt.IsZero() {
+		return 0
+	}
+	return s.StartedAt.Sub(s.RequestedAt)
+}
+
+// TimeToListening is how long players waited for the server, 0 when it never listened
+func (s Session) TimeToListening() time.Duration {
+	if s.ListeningAt.IsZero() {
+		return 0
+	}
+	return s.ListeningAt.Sub(s.RequestedAt)
+}
+
+// Uptime is how long the vm ran, from the start request to its end or now for an open session
+func (s Session) Uptime(now time.Time) time.Duration {
+	if s.RequestedAt.IsZero() {
+		return 0
+	}
+	if s.EndedAt.IsZero() {
+		return now.Sub(s.RequestedAt)
+	}
+	return s.EndedAt.Sub(s.RequestedAt)
+}
@@ -1,9 +1,49 @@
 package statestorageinterface
 
+import (
+	"godin/pkg/vmplacement"
+	"reflect"
+	"time"
+)
+
+// StateAttributes are the columns of the state entity, written and read with entitycodec
 type StateAttributes struct {
-	Ip            string `json:"ip"`
-	OnlinePlayers string `json:"online_players"` // for now this will just be comma delimited list of player names
-	Status        string `json:"status"`
+	Ip string `table:"ip,required"`
+	// Players are the online players
+	Players     Players   `table:"players"`
+	Status      string    `table:"status,required"`
+	StatusSince time.Time `table:"status_since"`
+	StatusEvent string    `table:"status_event"` // correlation id of the event that made the last status change
+	// token of the deferred interaction that is still waiting for the server to be ready
+	PendingInteraction string `table:"pending_interaction"`
+	// error of the last event that failed to be handled, and the last event that ended up in the poison queue
+	LastError     string `table:"last_error"`
+	PoisonedEvent string `table:"poisoned_event"`
+	// vmss placement the server was last scaled up on, the zero placement is the main scale set
+	Placement     vmplacement.Placement `table:"placement"`
+	LastHeartbeat time.Time             `table:"last_heartbeat"`
+	// when the last player left a listening server, when the idle shutdown was warned about and when its stop
+	// was enqueued
+	EmptySince    time.Time `table:"empty_since"`
+	IdleWarnedAt  time.Time `table:"idle_warned_at"`
+	IdleStoppedAt time.Time `table:"idle_stopped_at"`
+	// go durations overriding the idle shutdown defaults of the world, "0" disables it
+	IdleTimeout string `table:"idle_timeout"`
+	IdleWarning string `table:"idle_warning"`
+	// Session is the open session, or the last one until the next start
+	Session Session `table:"session"`
+}
+
+// Clone copies the attributes, so changing the state they came from leaves the copy as it was
+func (a StateAttributes) Clone() StateAttributes {
+	a.Players = a.Players.Clone()
+	a.Session = a.Session.Clone()
+	return a
+}
+
+// Equal tells whether two copies of the attributes have the same columns
+func (a StateAttributes) Equal(b StateAttributes) bool {
+	return reflect.DeepEqual(a, b)
 }
 
 type StateInterface interface {
@@ -12,9 +52,30 @@ type StateInterface interface {
 	Load() error
 	GetIp() string
 	SetIp(string)
-	GetOnlinePlayers() []string
-	AddOnlinePlayer(string)
-	RemoveOnlinePlayer(string)
+	GetPlayers() []Player
+	AddPlayer(Player)
+	RemovePlayer(steamId, name string)
+	SetPlayerCharacter(steamId, character string)
+	ClearPlayers()
 	GetStatus() string
-	SetStatus(string)
+	Transition(status, cause string) error
+	GetStatusSince() time.Time
+	GetPendingInteraction() string
+	SetPendingInteraction(string)
+	GetLastError() string
+	SetLastError(string)
+	GetPoisonedEvent() string
+	SetPoisonedEvent(string)
+	GetPlacement() vmplacement.Placement
+	SetPlacement(vmplacement.Placement)
+	GetLastHeartbeat() time.Time
+	SetLastHeartbeat(time.Time)
+	GetEmptySince() time.Time
+	SetEmptySince(time.Time)
+	GetIdleWarnedAt() time.Time
+	SetIdleWarnedAt(time.Time)
+	GetIdleStoppedAt() time.Time
+	SetIdleStoppedAt(time.Time)
+	GetSession() Session
+	SetSession(Session)
 }
@@ -3,7 +3,6 @@ package steamapi
 import (
 	"encoding/json"
 	"fmt"
-	"godin/pkg/utils"
 	"io"
 	"log"
 	"net/http"
@@ -35,11 +34,7 @@ type SteamResponse struct {
 	} `json:"response"`
 }
 
-func (c Client) GetUserRealName(action string) (string, error) {
-	steamid, err := utils.ExtractSteamId(action)
-	if err != nil {
-		return "", err
-	}
+func (c Client) GetUserRealName(steamid string) (string, error) {
 	url := c.baseUrl + fmt.Sprintf("/ISteamUser/GetPlayerSummaries/v2?steamids=%s", steamid)
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
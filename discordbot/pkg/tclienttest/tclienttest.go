@@ -0,0 +1,190 @@
+// Package tclienttest is the conformance suite of the TableClientInterface and PartitionClientInterface backends
+package tclienttest
+
+import (
+	"godin/pkg/aztclient"
+	"godin/pkg/entitycodec"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/utils"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// NewClient opens the entity of partitionKey and rowKey, every client it returns goes to the same table
+type NewClient func(partitionKey, rowKey string) (aztclient.TableClientInterface, error)
+
+// Run checks a backend reads and writes entities like azure tables do, with the same optimistic
+// concurrency. Every check uses its own row keys, so the table can be reused across runs
+func Run(t *testing.T, newClient NewClient, rowKeyPrefix string) {
+	open := func(partitionKey, rowKey string) aztclient.TableClientInterface {
+		client, err := newClient(partitionKey, rowKeyPrefix+rowKey)
+		if err != nil {
+			t.Fatalf("error creating client for %s/%s: %v", partitionKey, rowKey, err)
+		}
+		return client
+	}
+	checkNewEntity(t, open)
+	checkReadWrite(t, open)
+	checkConflict(t, open)
+	checkKeys(t, open)
+}
+
+type openFunc func(partitionKey, rowKey string) aztclient.TableClientInterface
+
+func checkNewEntity(t *testing.T, open openFunc) {
+	client := open("valheim-vmss", "new")
+	if _, err := client.Read("ip"); !utils.IsMissingColumnError(err) {
+		t.Errorf("new entity - expected a missing column error but was %v", err)
+	}
+	// azure adds its system properties, like the keys and the timestamp, so only state columns are checked
+	properties, err := client.Read()
+	if _, ok := properties["status"]; err != nil || ok {
+		t.Errorf("new entity - expected no state properties but got %v (%v)", properties, err)
+	}
+}
+
+func checkReadWrite(t *testing.T, open openFunc) {
+	client := open("valheim-vmss", "readwrite")
+	if _, err := client.Read(); err != nil {
+		t.Fatalf("read write - error reading: %v", err)
+	}
+	if err := client.Write(statestorageinterface.StateAttributes{Ip: "127.0.0.1", Status: "started"}); err != nil {
+		t.Fatalf("read write - error writing: %v", err)
+	}
+	if err := client.Write(statestorageinterface.StateAttributes{Ip: "127.0.0.1", Status: "listening"}); err != nil {
+		t.Fatalf("read write - error writing twice after a read: %v", err)
+	}
+	properties, err := open("valheim-vmss", "readwrite").Read("ip", "status", "players")
+	if err != nil {
+		t.Fatalf("read write - error reading from another client: %v", err)
+	}
+	if properties["ip"] != "127.0.0.1" || properties["status"] != "listening" || properties["players"] != "" {
+		t.Errorf("read write - expected the last write to be read but was %v", properties)
+	}
+}
+
+func checkConflict(t *testing.T, open openFunc) {
+	first := open("valheim-vmss", "conflict")
+	second := open("valheim-vmss", "conflict")
+	if _, err := first.Read(); err != nil {
+		t.Fatalf("conflict - error reading: %v", err)
+	}
+	if _, err := second.Read(); err != nil {
+		t.Fatalf("conflict - error reading: %v", err)
+	}
+	if err := first.Write(statestorageinterface.StateAttributes{Status: "starting"}); err != nil {
+		t.Fatalf("conflict - error writing: %v", err)
+	}
+	if err := second.Write(statestorageinterface.StateAttributes{Status: "stopping"}); !utils.IsConflictError(err) {
+		t.Errorf("conflict - expected writing a stale entity to be a conflict but was %v", err)
+	}
+	if _, err := second.Read(); err != nil {
+		t.Fatalf("conflict - error reading again: %v", err)
+	}
+	if err := second.Write(statestorageinterface.StateAttributes{Status: "stopping"}); err != nil {
+		t.Errorf("conflict - expected writing after reading again to succeed but was %v", err)
+	}
+	if err := first.Write(statestorageinterface.StateAttributes{Status: "started"}); !utils.IsConflictError(err) {
+		t.Errorf("conflict - expected the first client to be stale now but was %v", err)
+	}
+	properties, err := open("valheim-vmss", "conflict").Read("status")
+	if err != nil || properties["status"] != "stopping" {
+		t.Errorf("conflict - expected only the winning writes to be kept but was %v (%v)", properties, err)
+	}
+}
+
+func checkKeys(t *testing.T, open openFunc) {
+	client := open("valheim-vmss", "keys")
+	client.Read()
+	if err := client.Write(statestorageinterface.StateAttributes{Status: "listening"}); err != nil {
+		t.Fatalf("keys - error writing: %v", err)
+	}
+	if _, err := open("valheim-permissions", "keys").Read("status"); !utils.IsMissingColumnError(err) {
+		t.Errorf("keys - expected entities of other partitions to be kept apart but was %v", err)
+	}
+	if _, err := open("valheim-vmss", "keys-other").Read("status"); !utils.IsMissingColumnError(err) {
+		t.Errorf("keys - expected entities of other rows to be kept apart but was %v", err)
+	}
+}
+
+// NewPartitionClient opens the rows of partitionKey, every client it returns goes to the same table
+type NewPartitionClient func(partitionKey string) (aztclient.PartitionClientInterface, error)
+
+// testRow is the kind of record kept in partitions, with the types entitycodec doesn't keep as strings
+type testRow struct {
+	Name     string    `table:"name"`
+	Count    int       `table:"count"`
+	Online   bool      `table:"online"`
+	Since    time.Time `table:"since"`
+	Sessions []string  `table:"sessions"`
+}
+
+// RunPartition checks a backend keeps the rows of a partition like azure tables do, in key order and with
+// the same optimistic concurrency. The partition is named after the prefix, so the table can be reused across runs
+func RunPartition(t *testing.T, newClient NewPartitionClient, partitionPrefix string) {
+	client, err := newClient(partitionPrefix + "rows")
+	if err != nil {
+		t.Fatalf("error creating partition client: %v", err)
+	}
+	row, err := client.Get("b")
+	if err != nil || row.Key != "b" || row.ETag != "" {
+		t.Fatalf("rows - expected a missing row without etag but was %v (%v)", row, err)
+	}
+	expected := testRow{Name: "viking", Count: 3, Online: true, Since: time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC), Sessions: []string{"s1"}}
+	properties, err := entitycodec.Encode(expected)
+	if err != nil {
+		t.Fatalf("rows - error encoding row: %v", err)
+	}
+	for _, key := range []string{"b", "a", "c"} {
+		if err := client.Put(aztclient.Row{Key: key, Properties: properties}); err != nil {
+			t.Fatalf("rows - error creating row %s: %v", key, err)
+		}
+	}
+	if err := client.Put(aztclient.Row{Key: "b", Properties: properties}); !utils.IsConflictError(err) {
+		t.Errorf("rows - expected creating an existing row to be a conflict but was %v", err)
+	}
+
+	row, err = client.Get("b")
+	if err != nil || row.ETag == "" {
+		t.Fatalf("rows - expected row b with an etag but was %v (%v)", row, err)
+	}
+	var decoded testRow
+	if err := entitycodec.Decode(row.Properties, &decoded); err != nil || !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("rows - expected row b to be read as %+v but was %+v (%v)", expected, decoded, err)
+	}
+	stale := row
+	row.Properties["count"] = int64(4)
+	if err := client.Put(row); err != nil {
+		t.Errorf("rows - error replacing row b: %v", err)
+	}
+	if err := client.Put(stale); !utils.IsConflictError(err) {
+		t.Errorf("rows - expected replacing a stale row to be a conflict but was %v", err)
+	}
+
+	if err := client.Delete("a"); err != nil {
+		t.Errorf("rows - error deleting row a: %v", err)
+	}
+	if err := client.Delete("a"); err != nil {
+		t.Errorf("rows - expected deleting a missing row to be ignored but was %v", err)
+	}
+	rows, err := client.List("", 10)
+	if err != nil || len(rows) != 2 || rows[0].Key != "b" || rows[1].Key != "c" {
+		t.Fatalf("rows - expected rows b and c but were %v (%v)", rows, err)
+	}
+	if err := entitycodec.Decode(rows[0].Properties, &decoded); err != nil || decoded.Count != 4 {
+		t.Errorf("rows - expected the replaced row b to be listed but was %+v (%v)", decoded, err)
+	}
+	rows, err = client.List("b", 1)
+	if err != nil || len(rows) != 1 || rows[0].Key != "c" {
+		t.Errorf("rows - expected listing after b to be c but was %v (%v)", rows, err)
+	}
+
+	other, err := newClient(partitionPrefix + "rows-other")
+	if err != nil {
+		t.Fatalf("error creating partition client: %v", err)
+	}
+	if rows, err := other.List("", 10); err != nil || len(rows) != 0 {
+		t.Errorf("rows - expected rows of other partitions to be kept apart but were %v (%v)", rows, err)
+	}
+}
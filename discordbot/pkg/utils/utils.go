@@ -31,6 +31,19 @@ func IsMissingColumnError(err error) bool {
 	return readError.Code == godinerrors.MissingColumnError
 }
 
+func IsConflictError(err error) bool {
+	writeError, ok := err.(godinerrors.WriteError)
+	if !ok {
+		return false
+	}
+	return writeError.Code == godinerrors.ConflictError
+}
+
+func IsTransitionError(err error) bool {
+	_, ok := err.(godinerrors.TransitionError)
+	return ok
+}
+
 func ExtractSteamId(action string) (string, error) {
 	r := regexp.MustCompile(`(Got connection SteamID|Closing socket) (\d{17})`)
 	steamidbytes := r.FindAllStringSubmatch(action, 999)
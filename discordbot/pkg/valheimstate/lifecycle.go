@@ -0,0 +1,46 @@
+package valheimstate
+
+import (
+	"godin/pkg/godinerrors"
+	"slices"
+)
+
+// Server statuses
+const (
+	Stopped   = "stopped"
+	Starting  = "starting"
+	Started   = "started"
+	Listening = "listening"
+	Stopping  = "stopping"
+	Evicted   = "evicted"
+	Unhealthy = "unhealthy"
+	Failed    = "failed"
+)
+
+// transitions are the statuses each status can move to. A vm can report listening before its scale up
+// returned, so starting can skip started, and a server that never ran has the empty status of a new entity
+var transitions = map[string][]string{
+	Stopped:   {Starting, Failed},
+	Starting:  {Started, Listening, Stopping, Evicted, Failed},
+	Started:   {Listening, Stopping, Evicted, Failed},
+	Listening: {Stopping, Evicted, Unhealthy},
+	Stopping:  {Stopped, Failed},
+	Evicted:   {Starting, Stopping},
+	Unhealthy: {Listening, Stopping, Evicted},
+	Failed:    {Starting, Stopping},
+}
+
+// CheckTransition tells whether the lifecycle allows moving from one status to another,
+// moving to the current status is a DuplicateTransitionError
+func CheckTransition(from, to string) error {
+	if from == "" {
+		from = Stopped
+	}
+	if from == to {
+		return godinerrors.TransitionError{Code: godinerrors.DuplicateTransitionError, From: from, To: to}
+	}
+	if !slices.Contains(transitions[from], to) {
+		return godinerrors.TransitionError{Code: godinerrors.IllegalTransitionError, From: from, To: to}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package valheimstate
+
+import (
+	"godin/pkg/godinerrors"
+	"godin/pkg/statestorageinterface"
+	"testing"
+)
+
+func TestCheckTransition(t *testing.T) {
+	type testcase struct {
+		From         string
+		To           string
+		ExpectedCode godinerrors.ErrorCode
+	}
+	testcases := []testcase{
+		{From: "", To: Starting},
+		{From: Stopped, To: Starting},
+		{From: Starting, To: Listening},
+		{From: Listening, To: Stopping},
+		{From: Unhealthy, To: Listening},
+		{From: Stopped, To: Listening, ExpectedCode: godinerrors.IllegalTransitionError},
+		{From: Stopping, To: Starting, ExpectedCode: godinerrors.IllegalTransitionError},
+		{From: Listening, To: Started, ExpectedCode: godinerrors.IllegalTransitionError},
+		{From: Starting, To: Starting, ExpectedCode: godinerrors.DuplicateTransitionError},
+		{From: "", To: Stopped, ExpectedCode: godinerrors.DuplicateTransitionError},
+	}
+	for _, tc := range testcases {
+		err := CheckTransition(tc.From, tc.To)
+		var code godinerrors.ErrorCode
+		if err != nil {
+			code = err.(godinerrors.TransitionError).Code
+		}
+		if code != tc.ExpectedCode {
+			t.Errorf("%s to %s - expected %q but got %v", tc.From, tc.To, tc.ExpectedCode, err)
+		}
+	}
+}
+
+func TestTransitionRetry(t *testing.T) {
+	state := &State{Attributes: statestorageinterface.StateAttributes{Status: Stopped}}
+	if err := state.Transition(Starting, "event1"); err != nil {
+		t.Fatalf("error starting: %v", err)
+	}
+	since := state.Attributes.StatusSince
+	if state.Attributes.Status != Starting || since.IsZero() || state.Attributes.StatusEvent != "event1" {
+		t.Errorf("expected starting since now because of event1 but was %+v", state.Attributes)
+	}
+	if err := state.Transition(Starting, "event1"); err != nil {
+		t.Errorf("expected the retry of event1 to go through but got %v", err)
+	}
+	if err := state.Transition(Starting, "event2"); err == nil {
+		t.Errorf("expected a second start to be rejected")
+	}
+	if err := state.Transition(Started, "event1"); err != nil || state.Attributes.Status != Started {
+		t.Errorf("expected event1 to move the server to started but got %v", err)
+	}
+	if err := state.Transition(Starting, "event1"); err != nil || state.Attributes.Status != Started {
+		t.Errorf("expected a late retry of event1 to leave the server started but got %v", err)
+	}
+}
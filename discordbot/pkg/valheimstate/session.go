@@ -0,0 +1,56 @@
+package valheimstate
+
+import (
+	"godin/pkg/statestorageinterface"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// sessionEnds are the statuses the vm no longer runs in, they end the open session
+var sessionEnds = []string{Stopped, Failed, Evicted}
+
+// TrackSession keeps the session up to date with a change of the state, from the status and players before it.
+// Moving to starting opens a session, reaching started and listening times it, and players online while it is
+// open count towards its peak and distinct players, until a status it ends in
+func TrackSession(state statestorageinterface.StateInterface, before statestorageinterface.StateAttributes, at time.Time) {
+	session := state.GetSession()
+	status := state.GetStatus()
+	if status != before.Status {
+		switch {
+		case status == Starting:
+			session = statestorageinterface.Session{Id: state.GetAttributes().StatusEvent, RequestedAt: at}
+		case status == Started && session.IsOpen() && session.StartedAt.IsZero():
+			session.StartedAt = at
+		case status == Listening && session.IsOpen() && session.ListeningAt.IsZero():
+			session.ListeningAt = at
+		case slices.Contains(sessionEnds, status) && session.IsOpen():
+			session.EndedAt = at
+			session.EndStatus = status
+		}
+	}
+	if session.IsOpen() {
+		players := state.GetPlayers()
+		if session.Players == nil && len(players) != 0 {
+			session.Players = map[string]string{}
+		}
+		for _, player := range players {
+			key := player.SteamId
+			if key == "" {
+				key = player.Name
+			}
+			session.Players[key] = player.Name
+		}
+		session.PeakPlayers = max(session.PeakPlayers, len(players))
+	}
+	state.SetSession(session)
+}
+
+// SessionEnded returns the session a saved change ended, if it ended one
+func SessionEnded(before, after statestorageinterface.StateAttributes) (statestorageinterface.Session, bool) {
+	session := after.Session
+	if reflect.DeepEqual(before.Session, session) || session.RequestedAt.IsZero() || session.IsOpen() {
+		return statestorageinterface.Session{}, false
+	}
+	return session, true
+}
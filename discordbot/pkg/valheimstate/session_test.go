@@ -0,0 +1,133 @@
+package valheimstate
+
+import (
+	"godin/pkg/statestorageinterface"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrackSession(t *testing.T) {
+	type testcase struct {
+		Name    string
+		Mutate  func(state statestorageinterface.StateInterface)
+		Ends    bool
+		Checked func(session statestorageinterface.Session) bool
+	}
+	start := time.Date(2024, 10, 5, 22, 0, 0, 0, time.UTC)
+	join := func(steamId, name string) func(state statestorageinterface.StateInterface) {
+		return func(state statestorageinterface.StateInterface) {
+			state.AddPlayer(statestorageinterface.Player{SteamId: steamId, Name: name})
+		}
+	}
+	testcases := []testcase{
+		{
+			Name:   "join while stopped",
+			Mutate: join("1", "player1"),
+			Checked: func(session statestorageinterface.Session) bool {
+				return session.RequestedAt.IsZero() && session.PeakPlayers == 0
+			},
+		},
+		{
+			Name: "start",
+			Mutate: func(state statestorageinterface.StateInterface) {
+				state.ClearPlayers()
+				state.Transition(Starting, "start1")
+			},
+			Checked: func(session statestorageinterface.Session) bool {
+				return session.Id == "start1" && session.RequestedAt.Equal(start.Add(time.Minute)) && session.IsOpen()
+			},
+		},
+		{
+			Name:   "started",
+			Mutate: func(state statestorageinterface.StateInterface) { state.Transition(Started, "start1") },
+			Checked: func(session statestorageinterface.Session) bool {
+				return session.ScaleUpLatency() == time.Minute && session.TimeToListening() == 0
+			},
+		},
+		{
+			Name:   "listening",
+			Mutate: func(state statestorageinterface.StateInterface) { state.Transition(Listening, "listening1") },
+			Checked: func(session statestorageinterface.Session) bool {
+				return session.TimeToListening() == 2*time.Minute
+			},
+		},
+		{
+			Name:    "join",
+			Mutate:  join("1", "player1"),
+			Checked: func(session statestorageinterface.Session) bool { return session.PeakPlayers == 1 },
+		},
+		{
+			Name:    "second join",
+			Mutate:  join("2", "player2"),
+			Checked: func(session statestorageinterface.Session) bool { return session.PeakPlayers == 2 },
+		},
+		{
+			Name: "leave and join",
+			Mutate: func(state statestorageinterface.StateInterface) {
+				state.RemovePlayer("1", "player1")
+				join("3", "player3")(state)
+			},
+			Checked: func(session statestorageinterface.Session) bool {
+				return session.PeakPlayers == 2 && reflect.DeepEqual(session.Players, map[string]string{"1": "player1", "2": "player2", "3": "player3"})
+			},
+		},
+		{
+			Name:   "unhealthy",
+			Mutate: func(state statestorageinterface.StateInterface) { state.Transition(Unhealthy, "watchdog") },
+			Checked: func(session statestorageinterface.Session) bool {
+				return session.IsOpen() && session.TimeToListening() == 2*time.Minute
+			},
+		},
+		{
+			Name: "stopping",
+			Mutate: func(state statestorageinterface.StateInterface) {
+				state.Transition(Stopping, "stop1")
+				state.ClearPlayers()
+			},
+			Checked: func(session statestorageinterface.Session) bool { return session.IsOpen() && session.PeakPlayers == 2 },
+		},
+		{
+			Name:   "stopped",
+			Mutate: func(state statestorageinterface.StateInterface) { state.Transition(Stopped, "stop1") },
+			Ends:   true,
+			Checked: func(session statestorageinterface.Session) bool {
+				return !session.IsOpen() && session.EndStatus == Stopped && session.Uptime(start.Add(time.Hour)) == 8*time.Minute && len(session.Players) == 3
+			},
+		},
+		{
+			Name:   "join after the end",
+			Mutate: join("4", "player4"),
+			Checked: func(session statestorageinterface.Session) bool {
+				return session.Id == "start1" && len(session.Players) == 3
+			},
+		},
+		{
+			Name:   "evicted start",
+			Mutate: func(state statestorageinterface.StateInterface) { state.Transition(Starting, "start2") },
+			Checked: func(session statestorageinterface.Session) bool {
+				return session.Id == "start2" && session.PeakPlayers == 1 && len(session.Players) == 1
+			},
+		},
+		{
+			Name:   "evicted",
+			Mutate: func(state statestorageinterface.StateInterface) { state.Transition(Evicted, "evicted2") },
+			Ends:   true,
+			Checked: func(session statestorageinterface.Session) bool {
+				return session.EndStatus == Evicted && session.TimeToListening() == 0 && session.ScaleUpLatency() == 0
+			},
+		},
+	}
+	state := &State{Attributes: statestorageinterface.StateAttributes{Status: Stopped}}
+	for i, tc := range testcases {
+		before := state.GetAttributes()
+		tc.Mutate(state)
+		TrackSession(state, before, start.Add(time.Duration(i)*time.Minute))
+		if !tc.Checked(state.GetSession()) {
+			t.Errorf("%s - unexpected session %+v", tc.Name, state.Attributes.Session)
+		}
+		if _, ends := SessionEnded(before, state.GetAttributes()); ends != tc.Ends {
+			t.Errorf("%s - expected the session to end %v but was %v", tc.Name, tc.Ends, ends)
+		}
+	}
+}
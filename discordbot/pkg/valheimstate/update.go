@@ -0,0 +1,59 @@
+package valheimstate
+
+import (
+	"fmt"
+	"godin/pkg/statestorageinterface"
+	"godin/pkg/utils"
+	"log"
+	"time"
+)
+
+// maxConflictRetries is how many times Update reapplies a mutation after losing a write race
+const maxConflictRetries = 5
+
+// Update applies mutate to the state, along with the session bookkeeping of TrackSession, and saves it, an
+// unchanged state isn't written. When the entity was written by someone else since it was read, the state is
+// read again and mutate reapplied to it, so mutate must only change state and decide from what it reads in it,
+// side effects go after Update returns
+func Update(state statestorageinterface.StateInterface, mutate func(state statestorageinterface.StateInterface) error) error {
+	return UpdateRecorded(state, mutate, nil)
+}
+
+// UpdateRecorded is Update, calling record, when it isn't nil, with the attributes before and after the change
+// before saving it. An error of record leaves the state unsaved and is returned, so the change is made and recorded
+// again when the event is retried. record is called again when the change is reapplied, it must be idempotent
+func UpdateRecorded(
+	state statestorageinterface.StateInterface,
+	mutate func(state statestorageinterface.StateInterface) error,
+	record func(before, after statestorageinterface.StateAttributes) error,
+) error {
+	for attempt := 1; ; attempt++ {
+		before := state.GetAttributes()
+		if err := mutate(state); err != nil {
+			return err
+		}
+		TrackSession(state, before, time.Now().UTC())
+		if state.GetAttributes().Equal(before) {
+			return nil
+		}
+		if record != nil {
+			if err := record(before, state.GetAttributes()); err != nil {
+				return err
+			}
+		}
+		err := state.Save()
+		if err == nil {
+			return nil
+		}
+		if !utils.IsConflictError(err) {
+			return err
+		}
+		if attempt > maxConflictRetries {
+			return fmt.Errorf("error saving state, still conflicting after %d attempts: %v", attempt, err)
+		}
+		log.Printf("State changed since it was read, reapplying the change: %v", err)
+		if err := state.Load(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package valheimstate
+
+import (
+	"fmt"
+	"godin/pkg/godinerrors"
+	"godin/pkg/statestorageinterface"
+	"testing"
+)
+
+// ConflictingTableClient fails the first conflicts writes like a table whose entity keeps being written by others,
+// every read returns the entity as the other writer left it
+type ConflictingTableClient struct {
+	conflicts int
+	stored    map[string]interface{}
+	writes    []statestorageinterface.StateAttributes
+}
+
+func (ctc *ConflictingTableClient) Read(columns ...string) (map[string]interface{}, error) {
+	return ctc.stored, nil
+}
+
+func (ctc *ConflictingTableClient) Write(state statestorageinterface.StateAttributes) error {
+	if ctc.conflicts > 0 {
+		ctc.conflicts--
+		ctc.stored["players"] = fmt.Sprintf(`{"%d":{"steam_id":"%d","name":"player%d"}}`, ctc.conflicts, ctc.conflicts, ctc.conflicts)
+		return godinerrors.WriteError{Code: godinerrors.ConflictError, Message: "etag mismatch"}
+	}
+	ctc.writes = append(ctc.writes, state)
+	return nil
+}
+
+func TestUpdate(t *testing.T) {
+	type testcase struct {
+		Name            string
+		Conflicts       int
+		ExpectedErr     bool
+		ExpectedPlayers int
+	}
+	testcases := []testcase{
+		{Name: "no conflict", Conflicts: 0, ExpectedPlayers: 1},
+		{Name: "reapplied after conflicts", Conflicts: 2, ExpectedPlayers: 2},
+		{Name: "too many conflicts", Conflicts: maxConflictRetries + 1, ExpectedErr: true},
+	}
+	for _, tc := range testcases {
+		storage := &ConflictingTableClient{
+			conflicts: tc.Conflicts,
+			stored:    map[string]interface{}{"ip": "", "status": Listening},
+		}
+		state := NewValheimState(storage)
+		if err := state.Load(); err != nil {
+			t.Fatalf("%s - error loading state: %v", tc.Name, err)
+		}
+		mutations := 0
+		err := Update(state, func(state statestorageinterface.StateInterface) error {
+			mutations++
+			state.AddPlayer(statestorageinterface.Player{SteamId: "76561198073103840", Name: "viking"})
+			return nil
+		})
+		if (err != nil) != tc.ExpectedErr {
+			t.Errorf("%s - expected error %v but got %v", tc.Name, tc.ExpectedErr, err)
+		}
+		if tc.ExpectedErr {
+			continue
+		}
+		if mutations != tc.Conflicts+1 || len(storage.writes) != 1 {
+			t.Errorf("%s - expected %d mutations and a single write but got %d and %d", tc.Name, tc.Conflicts+1, mutations, len(storage.writes))
+		}
+		if players := len(state.GetPlayers()); players != tc.ExpectedPlayers {
+			t.Errorf("%s - expected the change to be applied on the fresh read with %d players but got %d", tc.Name, tc.ExpectedPlayers, players)
+		}
+	}
+
+	storage := &ConflictingTableClient{stored: map[string]interface{}{"ip": "", "status": Listening}}
+	state := NewValheimState(storage)
+	state.Load()
+	if err := Update(state, func(state statestorageinterface.StateInterface) error { return nil }); err != nil || len(storage.writes) != 0 {
+		t.Errorf("expected an unchanged state not to be written but got %v and %d writes", err, len(storage.writes))
+	}
+}
+
+func TestUpdateRecorded(t *testing.T) {
+	storage := &ConflictingTableClient{
+		conflicts: 1,
+		stored:    map[string]interface{}{"ip": "", "status": Listening},
+	}
+	state := NewValheimState(storage)
+	if err := state.Load(); err != nil {
+		t.Fatalf("error loading state: %v", err)
+	}
+	saved := [][2]statestorageinterface.StateAttributes{}
+	record := func(before, after statestorageinterface.StateAttributes) error {
+		saved = append(saved, [2]statestorageinterface.StateAttributes{before, after})
+		return nil
+	}
+	if err := UpdateRecorded(state, func(state statestorageinterface.StateInterface) error {
+		state.SetIp("192.168.0.1")
+		return nil
+	}, record); err != nil {
+		t.Fatalf("error updating state: %v", err)
+	}
+	// the change is recorded again when it is reapplied, the last time against the fresh read
+	if len(saved) != 2 || len(saved[1][0].Players) == 0 || saved[1][0].Ip != "" || saved[1][1].Ip != "192.168.0.1" {
+		t.Errorf("expected the change to be recorded for both attempts, the last from the fresh read, but was %v", saved)
+	}
+	if err := UpdateRecorded(state, func(state statestorageinterface.StateInterface) error { return nil }, record); err != nil || len(saved) != 2 {
+		t.Errorf("expected an unchanged state not to be recorded but was %v (%v)", saved, err)
+	}
+	writes := len(storage.writes)
+	failing := func(before, after statestorageinterface.StateAttributes) error {
+		return fmt.Errorf("history unavailable")
+	}
+	if err := UpdateRecorded(state, func(state statestorageinterface.StateInterface) error {
+		state.SetIp("192.168.0.2")
+		return nil
+	}, failing); err == nil || len(storage.writes) != writes {
+		t.Errorf("expected a change that failed to be recorded not to be saved but got %v and %d writes", err, len(storage.writes)-writes)
+	}
+}
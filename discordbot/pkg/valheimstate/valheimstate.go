@@ -1,11 +1,14 @@
 package valheimstate
 
 import (
+	"fmt"
 	"godin/pkg/aztclient"
+	"godin/pkg/entitycodec"
 	"godin/pkg/statestorageinterface"
 	"godin/pkg/utils"
-	"slices"
-	"strings"
+	"godin/pkg/vmplacement"
+	"log"
+	"time"
 )
 
 type State struct {
@@ -19,33 +22,63 @@ func NewValheimState(aztclient aztclient.TableClientInterface) statestorageinter
 	}
 }
 
+// GetAttributes returns a copy of the columns, the players and session are cloned so later changes don't show
 func (s *State) GetAttributes() statestorageinterface.StateAttributes {
-	return s.Attributes
+	return s.Attributes.Clone()
 }
 
-func (s *State) AddOnlinePlayer(player string) {
-	if len(s.Attributes.OnlinePlayers) == 0 {
-		s.Attributes.OnlinePlayers = player
-	} else {
-		players := strings.Split(s.Attributes.OnlinePlayers, ",")
-		players = append(players, player)
-		s.Attributes.OnlinePlayers = strings.Join(players, ",")
+func (s *State) AddPlayer(player statestorageinterface.Player) {
+	if s.Attributes.Players == nil {
+		s.Attributes.Players = statestorageinterface.Players{}
 	}
+	s.Attributes.Players.Add(player)
 }
 
-func (s *State) RemoveOnlinePlayer(player string) {
-	players := strings.Split(s.Attributes.OnlinePlayers, ",")
-	players = slices.DeleteFunc(players, func(s string) bool { return s == player })
-	s.Attributes.OnlinePlayers = strings.Join(players, ",")
+func (s *State) RemovePlayer(steamId, name string) {
+	s.Attributes.Players.Remove(steamId, name)
+	if len(s.Attributes.Players) == 0 {
+		s.Attributes.Players = nil
+	}
+}
+
+// SetPlayerCharacter records the character of an online player, unknown players are ignored
+func (s *State) SetPlayerCharacter(steamId, character string) {
+	player, ok := s.Attributes.Players[steamId]
+	if !ok {
+		return
+	}
+	player.Character = character
+	s.Attributes.Players[steamId] = player
 }
 
-func (s *State) GetOnlinePlayers() []string {
-	players := strings.Split(s.Attributes.OnlinePlayers, ",")
-	return players
+func (s *State) ClearPlayers() {
+	s.Attributes.Players = nil
 }
 
-func (s *State) SetStatus(status string) {
+// GetPlayers returns the online players in the order they joined
+func (s *State) GetPlayers() []statestorageinterface.Player {
+	return s.Attributes.Players.Sorted()
+}
+
+// Transition moves the server to status if the lifecycle allows it, cause is the correlation id of the event
+// asking for it. The event that made the current status is let through without changing it, so its retries can go on
+func (s *State) Transition(status, cause string) error {
+	if err := CheckTransition(s.Attributes.Status, status); err != nil {
+		if cause != "" && s.Attributes.StatusEvent == cause {
+			return nil
+		}
+		log.Printf("Rejected status change of %s: %v", cause, err)
+		return err
+	}
 	s.Attributes.Status = status
+	s.Attributes.StatusSince = time.Now().UTC()
+	s.Attributes.StatusEvent = cause
+	return nil
+}
+
+// GetStatusSince returns when the current status was set, or the zero time if it was never recorded
+func (s *State) GetStatusSince() time.Time {
+	return s.Attributes.StatusSince
 }
 
 func (s *State) GetStatus() string {
@@ -57,16 +90,25 @@ func (s *State) Save() error {
 }
 
 func (s *State) Load() error {
-	state, err := s.storage.Read("ip", "online_players", "status")
+	state, err := s.storage.Read(entitycodec.Required(s.Attributes)...)
 	if err != nil {
 		if utils.IsMissingColumnError(err) {
 			return s.Save()
 		}
 		return err
 	}
-	s.Attributes.Ip = state["ip"].(string)
-	s.Attributes.OnlinePlayers = state["online_players"].(string)
-	s.Attributes.Status = state["status"].(string)
+	// columns added later are optional, entities written before them existed won't have them
+	var attributes statestorageinterface.StateAttributes
+	if err := entitycodec.Decode(state, &attributes); err != nil {
+		return fmt.Errorf("error loading state: %v", err)
+	}
+	if names, ok := state["online_players"].(string); ok && len(attributes.Players) == 0 {
+		// entities written before players existed list names in online_players, saving drops that column
+		if players := statestorageinterface.PlayersFromNames(names); len(players) > 0 {
+			attributes.Players = players
+		}
+	}
+	s.Attributes = attributes
 	return nil
 }
 
@@ -77,3 +119,79 @@ func (s *State) SetIp(ip string) {
 func (s *State) GetIp() string {
 	return s.Attributes.Ip
 }
+
+func (s *State) SetPendingInteraction(token string) {
+	s.Attributes.PendingInteraction = token
+}
+
+func (s *State) GetPendingInteraction() string {
+	return s.Attributes.PendingInteraction
+}
+
+func (s *State) SetLastError(err string) {
+	s.Attributes.LastError = err
+}
+
+func (s *State) GetLastError() string {
+	return s.Attributes.LastError
+}
+
+func (s *State) SetPoisonedEvent(message string) {
+	s.Attributes.PoisonedEvent = message
+}
+
+func (s *State) GetPoisonedEvent() string {
+	return s.Attributes.PoisonedEvent
+}
+
+func (s *State) SetPlacement(placement vmplacement.Placement) {
+	s.Attributes.Placement = placement
+}
+
+func (s *State) GetPlacement() vmplacement.Placement {
+	return s.Attributes.Placement
+}
+
+func (s *State) SetLastHeartbeat(at time.Time) {
+	s.Attributes.LastHeartbeat = at.UTC()
+}
+
+// GetLastHeartbeat returns when the vm agent last reported, or the zero time if it never did
+func (s *State) GetLastHeartbeat() time.Time {
+	return s.Attributes.LastHeartbeat
+}
+
+// SetEmptySince records when the server was left without players, the zero time clears it
+func (s *State) SetEmptySince(at time.Time) {
+	s.Attributes.EmptySince = at.UTC()
+}
+
+func (s *State) GetEmptySince() time.Time {
+	return s.Attributes.EmptySince
+}
+
+func (s *State) SetIdleWarnedAt(at time.Time) {
+	s.Attributes.IdleWarnedAt = at.UTC()
+}
+
+func (s *State) GetIdleWarnedAt() time.Time {
+	return s.Attributes.IdleWarnedAt
+}
+
+// SetIdleStoppedAt records when the idle stop was enqueued, so it is enqueued once, the zero time clears it
+func (s *State) SetIdleStoppedAt(at time.Time) {
+	s.Attributes.IdleStoppedAt = at.UTC()
+}
+
+func (s *State) GetIdleStoppedAt() time.Time {
+	return s.Attributes.IdleStoppedAt
+}
+
+// GetSession returns the open session, or the last one until the next start
+func (s *State) GetSession() statestorageinterface.Session {
+	return s.Attributes.Session.Clone()
+}
+
+func (s *State) SetSession(session statestorageinterface.Session) {
+	s.Attributes.Session = session
+}
@@ -0,0 +1,30 @@
+package valheimstate
+
+import (
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	type testcase struct {
+		Name            string
+		Stored          map[string]interface{}
+		ExpectedErr     bool
+		ExpectedPlayers int
+	}
+	testcases := []testcase{
+		{Name: "current entity", Stored: map[string]interface{}{"ip": "192.168.0.1", "status": Listening, "players": `{"76561198073103840":{"steam_id":"76561198073103840","name":"player1"}}`, "status_since": "2024-10-05T22:00:00Z"}, ExpectedPlayers: 1},
+		{Name: "online_players migrated", Stored: map[string]interface{}{"ip": "", "status": Listening, "online_players": "player1,player2"}, ExpectedPlayers: 2},
+		{Name: "non string ip", Stored: map[string]interface{}{"ip": 42.0, "status": Listening}, ExpectedErr: true},
+		{Name: "invalid players", Stored: map[string]interface{}{"ip": "", "status": Listening, "players": "player1"}, ExpectedErr: true},
+	}
+	for _, tc := range testcases {
+		state := NewValheimState(&ConflictingTableClient{stored: tc.Stored})
+		err := state.Load()
+		if (err != nil) != tc.ExpectedErr {
+			t.Errorf("%s - expected error %v but got %v", tc.Name, tc.ExpectedErr, err)
+		}
+		if len(state.GetPlayers()) != tc.ExpectedPlayers {
+			t.Errorf("%s - expected %d players but was %v", tc.Name, tc.ExpectedPlayers, state.GetPlayers())
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package vmplacement
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Placement is where the game server vm is allocated. The sku of a scale set can be changed while it has no vm,
+// its zone and priority can't, so fallbacks to another zone or to regular priority need their own scale set,
+// deployed like the main one, and Zone and Priority only tell what it is
+type Placement struct {
+	Vmss     string `json:"vmss,omitempty"`
+	Sku      string `json:"sku,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	Priority string `json:"priority,omitempty"`
+}
+
+func (p Placement) String() string {
+	sku := p.Sku
+	if sku == "" {
+		sku = "default sku"
+	}
+	priority := p.Priority
+	if priority == "" {
+		priority = "Spot"
+	}
+	description := fmt.Sprintf("%s %s on %s", sku, strings.ToLower(priority), p.Vmss)
+	if p.Zone != "" {
+		description += fmt.Sprintf(" in zone %s", p.Zone)
+	}
+	return description
+}
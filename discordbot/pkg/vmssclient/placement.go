@@ -0,0 +1,77 @@
+package vmssclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"godin/pkg/vmplacement"
+	"slices"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// capacityErrorCodes are the errors azure fails allocations with when it has no room for the vm,
+// trying another sku, zone or priority may succeed where the same one would fail again
+var capacityErrorCodes = []string{
+	"AllocationFailed",
+	"ZonalAllocationFailed",
+	"OverconstrainedAllocationRequest",
+	"OverconstrainedZonalAllocationRequest",
+	"SkuNotAvailable",
+	"SpotMaxPriceTooLow",
+}
+
+// ParsePlacements reads the json array of fallback placements, empty when there is no config
+func ParsePlacements(config string) ([]vmplacement.Placement, error) {
+	if config == "" {
+		return nil, nil
+	}
+	var placements []vmplacement.Placement
+	if err := json.Unmarshal([]byte(config), &placements); err != nil {
+		return nil, fmt.Errorf("error unmarshalling placements: %v", err)
+	}
+	for _, placement := range placements {
+		if (placement.Zone != "" || placement.Priority != "") && placement.Vmss == "" {
+			return nil, fmt.Errorf("placement %s changes zone or priority, which needs its own scale set", placement)
+		}
+	}
+	return placements, nil
+}
+
+// Attempt is the outcome of scaling up on a placement, Err is nil when it got a vm
+type Attempt struct {
+	Placement vmplacement.Placement
+	Err       error
+}
+
+// IsCapacityError tells whether err is azure running out of room for the vm, as opposed to a failure
+// another placement wouldn't fix
+func IsCapacityError(err error) bool {
+	return slices.Contains(capacityErrorCodes, ErrorCode(err))
+}
+
+// ErrorCode is the azure error code of err, or err itself when it didn't come from azure
+func ErrorCode(err error) string {
+	var responseError *azcore.ResponseError
+	if errors.As(err, &responseError) {
+		return responseError.ErrorCode
+	}
+	return err.Error()
+}
+
+// WalkPlacements scales up on each placement in turn until one succeeds. It stops at the first error
+// that isn't a capacity error, since the next placements would most likely fail the same way
+func WalkPlacements(placements []vmplacement.Placement, scaleUp func(vmplacement.Placement) error, report func(Attempt)) (vmplacement.Placement, error) {
+	var err error
+	for _, placement := range placements {
+		err = scaleUp(placement)
+		report(Attempt{Placement: placement, Err: err})
+		if err == nil {
+			return placement, nil
+		}
+		if !IsCapacityError(err) {
+			return vmplacement.Placement{}, err
+		}
+	}
+	return vmplacement.Placement{}, fmt.Errorf("no capacity in any of the %d placements, last error: %v", len(placements), err)
+}
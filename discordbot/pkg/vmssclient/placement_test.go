@@ -0,0 +1,99 @@
+package vmssclient
+
+import (
+	"fmt"
+	"godin/pkg/vmplacement"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+func TestWalkPlacements(t *testing.T) {
+	type testcase struct {
+		Name              string
+		Errors            map[string]error
+		ExpectedPlacement vmplacement.Placement
+		ExpectedAttempts  []string
+		ExpectError       bool
+	}
+	placements := []vmplacement.Placement{
+		{Vmss: "valheim-server-vmss"},
+		{Vmss: "valheim-server-vmss", Sku: "Standard_D2s_v5"},
+		{Vmss: "valheim-server-vmss-regular", Sku: "Standard_D2as_v4", Priority: "Regular"},
+	}
+	allocationFailed := &azcore.ResponseError{ErrorCode: "AllocationFailed"}
+	testcases := []testcase{
+		{
+			Name:              "first placement",
+			Errors:            map[string]error{},
+			ExpectedPlacement: placements[0],
+			ExpectedAttempts:  []string{"default sku spot on valheim-server-vmss: <nil>"},
+		},
+		{
+			Name: "falls back on capacity errors",
+			Errors: map[string]error{
+				placements[0].String(): allocationFailed,
+				placements[1].String(): &azcore.ResponseError{ErrorCode: "SkuNotAvailable"},
+			},
+			ExpectedPlacement: placements[2],
+			ExpectedAttempts: []string{
+				"default sku spot on valheim-server-vmss: " + allocationFailed.Error(),
+				"Standard_D2s_v5 spot on valheim-server-vmss: " + (&azcore.ResponseError{ErrorCode: "SkuNotAvailable"}).Error(),
+				"Standard_D2as_v4 regular on valheim-server-vmss-regular: <nil>",
+			},
+		},
+		{
+			Name: "stops on other errors",
+			Errors: map[string]error{
+				placements[0].String(): fmt.Errorf("authorization failed"),
+			},
+			ExpectedAttempts: []string{"default sku spot on valheim-server-vmss: authorization failed"},
+			ExpectError:      true,
+		},
+		{
+			Name: "no capacity anywhere",
+			Errors: map[string]error{
+				placements[0].String(): allocationFailed,
+				placements[1].String(): allocationFailed,
+				placements[2].String(): allocationFailed,
+			},
+			ExpectedAttempts: []string{
+				"default sku spot on valheim-server-vmss: " + allocationFailed.Error(),
+				"Standard_D2s_v5 spot on valheim-server-vmss: " + allocationFailed.Error(),
+				"Standard_D2as_v4 regular on valheim-server-vmss-regular: " + allocationFailed.Error(),
+			},
+			ExpectError: true,
+		},
+	}
+	for _, tc := range testcases {
+		attempts := []string{}
+		placement, err := WalkPlacements(placements, func(p vmplacement.Placement) error {
+			return tc.Errors[p.String()]
+		}, func(attempt Attempt) {
+			attempts = append(attempts, fmt.Sprintf("%s: %v", attempt.Placement, attempt.Err))
+		})
+		if (err != nil) != tc.ExpectError {
+			t.Errorf("%s - expected error to be %t but was %v", tc.Name, tc.ExpectError, err)
+		}
+		if placement != tc.ExpectedPlacement {
+			t.Errorf("%s - expected placement to be %+v but was %+v", tc.Name, tc.ExpectedPlacement, placement)
+		}
+		if !reflect.DeepEqual(attempts, tc.ExpectedAttempts) {
+			t.Errorf("%s - expected attempts to be %v but were %v", tc.Name, tc.ExpectedAttempts, attempts)
+		}
+	}
+}
+
+func TestParsePlacements(t *testing.T) {
+	placements, err := ParsePlacements(`[{"sku": "Standard_D2s_v5"}, {"vmss": "valheim-server-vmss-z2", "zone": "2"}]`)
+	if err != nil || len(placements) != 2 || placements[1].Zone != "2" {
+		t.Errorf("expected two placements but was %v (%v)", placements, err)
+	}
+	if _, err := ParsePlacements(`[{"sku": "Standard_D2s_v5", "priority": "Regular"}]`); err == nil {
+		t.Errorf("expected a priority fallback without its own scale set to fail")
+	}
+	if placements, err := ParsePlacements(""); err != nil || placements != nil {
+		t.Errorf("expected no config to mean no fallbacks but was %v (%v)", placements, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"godin/pkg/utils"
+	"godin/pkg/vmplacement"
 	"log"
 	"os"
 	"time"
@@ -16,8 +17,10 @@ import (
 )
 
 type VmssClientInterface interface {
-	ScaleUp() error
-	ScaleDown() error
+	// ScaleUp walks the placements until one of them gets a vm, calling report after every attempt
+	ScaleUp(report func(Attempt)) (vmplacement.Placement, error)
+	// ScaleDown stops the game server and removes the vm of the placement it was scaled up on
+	ScaleDown(placement vmplacement.Placement) error
 }
 
 type VmssClient struct {
@@ -25,9 +28,11 @@ type VmssClient struct {
 	VmssName          string
 	ResourceGroupName string
 	Ip                string
+	// Placements are tried in order when scaling up, the first one is the scale set as deployed
+	Placements []vmplacement.Placement
 }
 
-func NewVmssClient(resourcegroupname, vmssname, subscriptionid, ip string) (VmssClientInterface, error) {
+func NewVmssClient(resourcegroupname, vmssname, subscriptionid, ip string, fallbacks []vmplacement.Placement) (VmssClientInterface, error) {
 	cred, err := azidentity.NewManagedIdentityCredential(nil)
 	if err != nil {
 		log.Printf("error creating azure cred: %v", err)
@@ -38,37 +43,73 @@ func NewVmssClient(resourcegroupname, vmssname, subscriptionid, ip string) (Vmss
 		log.Printf("error creating vmss client: %v", err)
 		return nil, fmt.Errorf("error creating vmss client: %v", err)
 	}
+	placements := []vmplacement.Placement{{Vmss: vmssname}}
+	for _, fallback := range fallbacks {
+		if fallback.Vmss == "" {
+			fallback.Vmss = vmssname
+		}
+		placements = append(placements, fallback)
+	}
 	return &VmssClient{
 		Client:            client,
 		VmssName:          vmssname,
 		ResourceGroupName: resourcegroupname,
 		Ip:                ip,
+		Placements:        placements,
 	}, nil
 }
 
-func (vc *VmssClient) getCapacity() (*int64, error) {
-	vmss, err := vc.Client.Get(context.TODO(), vc.ResourceGroupName, vc.VmssName, nil)
+func (vc *VmssClient) get(vmssname string) (armcompute.VirtualMachineScaleSet, error) {
+	vmss, err := vc.Client.Get(context.TODO(), vc.ResourceGroupName, vmssname, nil)
 	if err != nil {
-		return nil, err
+		return armcompute.VirtualMachineScaleSet{}, err
 	}
-	return vmss.SKU.Capacity, nil
+	return vmss.VirtualMachineScaleSet, nil
 }
 
-func (vc *VmssClient) ScaleUp() error {
-	currentCapacity, err := vc.getCapacity()
-	if err != nil {
-		return err
-	}
-	if *currentCapacity == 1 {
-		return nil
-	}
+func (vc *VmssClient) ScaleUp(report func(Attempt)) (vmplacement.Placement, error) {
+	// the server may already run on any of the scale sets, a fallback one included
+	checked := map[string]bool{}
+	for _, placement := range vc.Placements {
+		if checked[placement.Vmss] {
+			continue
+		}
+		checked[placement.Vmss] = true
+		vmss, err := vc.get(placement.Vmss)
+		if err != nil {
+			return vmplacement.Placement{}, err
+		}
+		if *vmss.SKU.Capacity == 1 {
+			placement.Sku = *vmss.SKU.Name
+			return placement, nil
+		}
+	}
+	return WalkPlacements(vc.Placements, vc.scaleUpOn, report)
+}
 
+// scaleUpOn sets the capacity of the placement scale set to 1, with its sku. When there is no capacity for it
+// the failed vm is removed so the next placement starts clean
+func (vc *VmssClient) scaleUpOn(placement vmplacement.Placement) error {
+	sku := &armcompute.SKU{
+		Capacity: utils.ToPtr(int64(1)),
+	}
+	if placement.Sku != "" {
+		sku.Name = utils.ToPtr(placement.Sku)
+	}
+	err := vc.setSku(placement.Vmss, sku)
+	if err != nil && IsCapacityError(err) {
+		if resetErr := vc.setSku(placement.Vmss, &armcompute.SKU{Capacity: utils.ToPtr(int64(0))}); resetErr != nil {
+			log.Printf("error scaling %s back down after failing to allocate: %v", placement.Vmss, resetErr)
+		}
+	}
+	return err
+}
+
+func (vc *VmssClient) setSku(vmssname string, sku *armcompute.SKU) error {
 	params := armcompute.VirtualMachineScaleSetUpdate{
-		SKU: &armcompute.SKU{
-			Capacity: utils.ToPtr(int64(1)),
-		},
+		SKU: sku,
 	}
-	poller, err := vc.Client.BeginUpdate(context.TODO(), vc.ResourceGroupName, vc.VmssName, params, nil)
+	poller, err := vc.Client.BeginUpdate(context.TODO(), vc.ResourceGroupName, vmssname, params, nil)
 	if err != nil {
 		return err
 	}
@@ -76,10 +117,7 @@ func (vc *VmssClient) ScaleUp() error {
 		Frequency: 5 * time.Second,
 	}
 	_, err = poller.PollUntilDone(context.TODO(), &pudOpts)
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
 func (vc *VmssClient) execInVm(command string) error {
@@ -104,12 +142,15 @@ func (vc *VmssClient) execInVm(command string) error {
 	return nil
 }
 
-func (vc *VmssClient) ScaleDown() error {
-	currentCapacity, err := vc.getCapacity()
+func (vc *VmssClient) ScaleDown(placement vmplacement.Placement) error {
+	if placement.Vmss == "" {
+		placement.Vmss = vc.VmssName
+	}
+	vmss, err := vc.get(placement.Vmss)
 	if err != nil {
 		return err
 	}
-	if *currentCapacity == 0 {
+	if *vmss.SKU.Capacity == 0 {
 		return nil
 	}
 
@@ -117,21 +158,5 @@ func (vc *VmssClient) ScaleDown() error {
 	if err != nil {
 		return fmt.Errorf("failed to stop valheim container: %v", err)
 	}
-	params := armcompute.VirtualMachineScaleSetUpdate{
-		SKU: &armcompute.SKU{
-			Capacity: utils.ToPtr(int64(0)),
-		},
-	}
-	poller, err := vc.Client.BeginUpdate(context.TODO(), vc.ResourceGroupName, vc.VmssName, params, nil)
-	if err != nil {
-		return err
-	}
-	pudOpts := runtime.PollUntilDoneOptions{
-		Frequency: 5 * time.Second,
-	}
-	_, err = poller.PollUntilDone(context.TODO(), &pudOpts)
-	if err != nil {
-		return err
-	}
-	return nil
+	return vc.setSku(placement.Vmss, &armcompute.SKU{Capacity: utils.ToPtr(int64(0))})
 }
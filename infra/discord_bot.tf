@@ -40,10 +40,17 @@ resource "azurerm_windows_function_app" "godinbot" {
     WEBSITE_MOUNT_ENABLED            = 1
     AZURE_SUBSCRIPTION_ID            = data.azurerm_client_config.current.subscription_id
     BASE64_SERVER_KEY                = var.base64_server_key
+    DISCORD_ADMIN_CHANNEL_ID         = var.discord_admin_channel_id
+    DISCORD_APPLICATION_ID           = var.discord_application_id
     DISCORD_BOT_TOKEN                = var.discord_bot_token
     DISCORD_CHANNEL_ID               = var.discord_channel_id
     DISCORD_PUBLIC_KEY               = var.discord_public_key
+    HEARTBEAT_MAX_GAP                = var.heartbeat_max_gap
+    HISTORY_RETENTION                = var.history_retention
+    IDLE_TIMEOUT                     = var.idle_timeout
+    IDLE_WARNING                     = var.idle_warning
     STEAM_API_KEY                    = var.steam_api_key
+    VMSS_FALLBACKS                   = var.vmss_fallbacks
     VMSS_NAME                        = azurerm_linux_virtual_machine_scale_set.compute.name
     VMSS_RESOURCE_GROUP_NAME         = azurerm_resource_group.rg.name
     VMSS_SUBSCRIPTION_ID             = data.azurerm_client_config.current.subscription_id
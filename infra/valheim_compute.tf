@@ -84,6 +84,7 @@ data "template_file" "cloud_init" {
     server_pass                         = random_string.valheim_password.result
     events_storage_account_name         = azurerm_storage_account.godinbot.name
     events_queue_name                   = azurerm_storage_queue.events.name
+    godin_agent_url                     = var.godin_agent_url
   }
 }
 
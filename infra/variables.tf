@@ -22,6 +22,12 @@ variable "discord_bot_token" {
   description = "bot token so the function app can send messages reporting valheim server state changes"
 }
 
+variable "discord_application_id" {
+  type        = string
+  sensitive   = false
+  description = "id of the discord application, used to edit deferred interaction responses"
+}
+
 variable "discord_public_key" {
   type        = string
   sensitive   = true
@@ -39,3 +45,51 @@ variable "discord_channel_id" {
   sensitive   = false
   description = "id of the channel messages will be sent to"
 }
+
+variable "discord_admin_channel_id" {
+  type        = string
+  sensitive   = false
+  default     = ""
+  description = "id of the channel failures are reported to, defaults to discord_channel_id"
+}
+
+variable "godin_agent_url" {
+  type        = string
+  sensitive   = false
+  description = "url the vm downloads the linux godin-agent binary from, built with make agent"
+}
+
+variable "vmss_fallbacks" {
+  type        = string
+  sensitive   = false
+  default     = ""
+  description = "json array of {vmss, sku, zone, priority} placements tried in order when the scale set can't get a vm, see README"
+}
+
+variable "heartbeat_max_gap" {
+  type        = string
+  sensitive   = false
+  default     = "3m"
+  description = "how long the server can go without agent heartbeats before the watchdog flags it unhealthy"
+}
+
+variable "idle_timeout" {
+  type        = string
+  sensitive   = false
+  default     = "30m"
+  description = "how long the server can stay without players before it is stopped, 0 to never stop it"
+}
+
+variable "history_retention" {
+  type        = string
+  sensitive   = false
+  default     = "720h"
+  description = "how long state history entries are kept, 0 to keep them forever"
+}
+
+variable "idle_warning" {
+  type        = string
+  sensitive   = false
+  default     = "5m"
+  description = "how long before an idle stop the channel is warned"
+}